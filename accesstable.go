@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Pushes and pops of small indices are most of what programs do. Their asm
+// only depends on the segment, the index and a couple of settings, so it's
+// written once into tables and looked up from then on
+const accessTableSize = 64
+
+// The segments whose asm is the same wherever it's used. Statics are named
+// after their file
+var accessTableSegments = []string{"constant", "argument", "local", "this", "that", "pointer", "temp"}
+
+// How many words each fixed-size segment has
+var segmentSizes = map[string]int{
+	"constant": 32768,
+	"pointer":  2,
+	"temp":     8,
+}
+
+// Why a push or pop can't be, if it can't: a segment the VM doesn't have, a
+// pop to constant or an index outside the segment
+func accessError(kind string, segment string, index int) error {
+	if kind == "pop" && segment == "constant" {
+		return fmt.Errorf("no such access: pop constant %d", index)
+	}
+
+	switch segment {
+	case "constant", "pointer", "temp", "argument", "local", "this", "that", "static", "global":
+	default:
+		return fmt.Errorf("no such access: %s %s %d, invalid segment", kind, segment, index)
+	}
+
+	size, ok := segmentSizes[segment]
+	if !ok {
+		size = 32768
+	}
+
+	if index < 0 || index >= size {
+		return fmt.Errorf("no such access: %s %s %d, index out of range", kind, segment, index)
+	}
+
+	return nil
+}
+
+// The asm for each segment and index below accessTableSize, of a push or pop
+type accessAsm struct {
+	kind string
+	asm  map[string]*[accessTableSize]string
+}
+
+// The asm for an access, if it's in the table. An access the VM language
+// doesn't allow is an error
+func (a accessAsm) lookup(segment string, index int) (string, bool, error) {
+	if err := accessError(a.kind, segment, index); err != nil {
+		return "", false, err
+	}
+
+	indices, ok := a.asm[segment]
+	if !ok || index >= accessTableSize {
+		return "", false, nil
+	}
+
+	return indices[index], true, nil
+}
+
+type accessTable struct {
+	// The settings the asm was written for
+	optimizationLevel int
+	temp              int
+
+	push, pop accessAsm
+}
+
+var currentAccessTable atomic.Pointer[accessTable]
+
+func newAccessTable() *accessTable {
+	table := &accessTable{
+		optimizationLevel: optimizationLevel,
+		temp:              memory.Temp,
+		push:              accessAsm{"push", map[string]*[accessTableSize]string{}},
+		pop:               accessAsm{"pop", map[string]*[accessTableSize]string{}},
+	}
+
+	scratch := newTranslator()
+
+	for _, segment := range accessTableSegments {
+		push, pop := new([accessTableSize]string), new([accessTableSize]string)
+
+		// Only statics can fail to be given an address, and they aren't here
+		for index := 0; index < accessTableSize; index++ {
+			push[index], _ = scratch.handlePush(segment, index)
+			pop[index], _ = scratch.handlePop(segment, index)
+		}
+
+		table.push.asm[segment], table.pop.asm[segment] = push, pop
+	}
+
+	return table
+}
+
+// The tables for the settings as they are, written again whenever they've
+// changed since. Translators running at once may each write them, to the
+// same effect
+func accessTables() *accessTable {
+	table := currentAccessTable.Load()
+
+	if table == nil || table.optimizationLevel != optimizationLevel || table.temp != memory.Temp {
+		table = newAccessTable()
+		currentAccessTable.Store(table)
+	}
+
+	return table
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where a command's asm ended up in ROM, from Start up to but not including
+// End
+type sourceMapping struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	// Where a `// source:` comment said the command came from
+	Source string `json:"source,omitempty"`
+}
+
+// Maps each translated command to its ROM addresses. The commands' asm
+// appears in the output in order, surrounded by the bootstrap and routines
+func sourceMap(instructions []string) []sourceMapping {
+	mappings := []sourceMapping{}
+	address := 0
+	next := 0
+
+	for _, instruction := range instructions {
+		size := instructionCount(instruction)
+
+		if next < len(translation.translatedCommands) && instruction == translation.translatedCommands[next].Asm {
+			command := translation.translatedCommands[next].Command
+
+			mappings = append(mappings, sourceMapping{
+				File:    filepath.Base(command.File),
+				Line:    command.Line,
+				Command: command.String(),
+				Start:   address,
+				End:     address + size,
+				Source:  command.Source,
+			})
+
+			next++
+		}
+
+		address += size
+	}
+
+	return mappings
+}
+
+// Writes a zip holding the asm along with its source map, symbol table and
+// statistics
+func saveArchive(instructions []string, fileName string) error {
+	name := strings.TrimSuffix(filepath.Base(fileName), ".zip")
+
+	mappings, err := json.MarshalIndent(sourceMap(instructions), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	asm := instructions
+	if shouldStrip {
+		asm = stripAsm(instructions)
+	}
+
+	entries := []struct {
+		name     string
+		contents string
+	}{
+		{name + ".asm", withLineEndings(strings.Join(asm, ""))},
+		{name + ".map.json", string(mappings) + "\n"},
+		{name + ".sym", symbolFile(resolveSymbols(asmLines(asm)))},
+		{name + ".stats.md", statsReport(instructions, true)},
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	for _, entry := range entries {
+		writer, err := archive.Create(entry.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := writer.Write([]byte(entry.contents)); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Whether an operation belongs to the extended dialect rather than the course's
+var extendedOperations = map[string]bool{
+	"shl":  true,
+	"shr":  true,
+	"mult": true,
+	"div":  true,
+	"mod":  true,
+	"min":  true,
+	"max":  true,
+	"abs":  true,
+}
+
+// Jumps to a shared routine that replaces x and y with the result, coming back
+// to the continuation it's given in D
+func callRoutine(routine string, count *int) string {
+	retAddress := "RET_ADDRESS_" + routine + strconv.Itoa(*count)
+
+	lines := []string{
+		"@" + retAddress,
+		"D=A",
+		"@" + routine,
+		"0;JMP",
+		"(" + retAddress + ")",
+	}
+
+	*count++
+
+	return joinLines(lines)
+}
+
+// The call into the routine behind one of the extended dialect's operations
+func (t *translator) extendedOperation(op string) string {
+	switch op {
+	case "shl":
+		return t.shl()
+	case "shr":
+		return t.shr()
+	case "mult":
+		return callRoutine("MULT", &t.MultCount)
+	case "div":
+		return callRoutine("DIV", &t.DivCount)
+	case "mod":
+		return callRoutine("MOD", &t.ModCount)
+	case "abs":
+		return t.abs()
+	}
+
+	return t.minMax(op)
+}
+
+// Keeps x (second from top) or replaces it with y (top), on which is the
+// smaller for min or the larger for max. When their signs differ the
+// negative one is the smaller, as y-x could overflow. Otherwise, like gt
+// and lt, y-x says which
+func (t *translator) minMax(op string) string {
+	suffix := strings.ToUpper(op) + strconv.Itoa(t.BranchCount)
+	end, replace := "END_"+suffix, "REPLACE_"+suffix
+	yNegative, sameSign := "Y_NEGATIVE_"+suffix, "SAME_SIGN_"+suffix
+
+	// Where y-x says x is the one to keep, and where to go when x is the
+	// smaller or the larger
+	keep, xSmaller, xLarger := "D;JGE", end, replace
+	if op == "max" {
+		keep, xSmaller, xLarger = "D;JLE", replace, end
+	}
+
+	lines := []string{
+		"@SP",
+		"AM=M-1",
+		"D=M",
+		"@" + yNegative,
+		"D;JLT",
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@" + sameSign,
+		"D;JGE",
+		"@" + xSmaller,
+		"0;JMP",
+		"(" + yNegative + ")",
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@" + xLarger,
+		"D;JGE",
+		"(" + sameSign + ")",
+		"@SP",
+		"A=M",
+		"D=M",
+		"A=A-1",
+		"D=D-M",
+		"@" + end,
+		keep,
+		"(" + replace + ")",
+		"@SP",
+		"A=M",
+		"D=M",
+		"A=A-1",
+		"M=D",
+		"(" + end + ")",
+	}
+
+	t.BranchCount++
+
+	return joinLines(lines)
+}
+
+func (t *translator) abs() string {
+	end := "END_ABS" + strconv.Itoa(t.BranchCount)
+
+	lines := []string{
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@" + end,
+		"D;JGE",
+		"@SP",
+		"A=M-1",
+		"M=-M",
+		"(" + end + ")",
+	}
+
+	t.BranchCount++
+
+	return joinLines(lines)
+}
+
+// Multiplies x (second from top) by y (top), adding x shifted along for each
+// bit set in y. The bits of y left to go are in the locRegister, x shifted
+// so far in the valueRegister and the bit reached where y was on the stack.
+// Each bit is cleared as it's added, so the loop ends with y's highest
+func createMultRoutine() []string {
+	lines := []string{
+		"(MULT)",
+		"@R15",
+		"M=D",
+
+		"@SP",
+		"AM=M-1",
+		"D=M",
+		locRegister,
+		"M=D",
+		"@SP",
+		"A=M-1",
+		"D=M",
+		valueRegister,
+		"M=D",
+		"@SP",
+		"A=M-1",
+		"M=0",
+		"@SP",
+		"A=M",
+		"M=1",
+
+		"(MULT_LOOP)",
+		locRegister,
+		"D=M",
+		"@END_MULT",
+		"D;JEQ",
+		"@SP",
+		"A=M",
+		"D=M",
+		locRegister,
+		"D=D&M",
+		"@MULT_NEXT",
+		"D;JEQ",
+		locRegister,
+		"M=M-D",
+		valueRegister,
+		"D=M",
+		"@SP",
+		"A=M-1",
+		"M=D+M",
+
+		"(MULT_NEXT)",
+		valueRegister,
+		"D=M",
+		"M=D+M",
+		"@SP",
+		"A=M",
+		"D=M",
+		"M=D+M",
+		"@MULT_LOOP",
+		"0;JMP",
+
+		"(END_MULT)",
+		"@R15",
+		"A=M",
+		"0;JMP",
+	}
+
+	return []string{strings.Join(lines, "\n") + "\n"}
+}
+
+// Divides x (second from top) by y (top) one bit of x at a time, from the
+// top, leaving the quotient for DIV or the remainder for MOD. Both round
+// towards zero, the remainder taking the sign of x, and dividing by zero
+// gives 0 and x. The magnitudes are worked on negated, as -32768 has no
+// positive: the remainder in the locRegister, what's left of |x| in the
+// valueRegister, and -|y|, the quotient and the bits to go above the stack.
+// A -|y| below -16384 divides at most once, and is done without the loop,
+// where doubling the remainder would overflow
+func createDivisionRoutine(routine string) []string {
+	label := func(name string) string {
+		return routine + "_" + name
+	}
+
+	// What's above the stack, from the top of it
+	slot := func(n int) []string {
+		lines := []string{"@SP", "A=M"}
+		for i := 0; i < n; i++ {
+			lines = append(lines, "A=A+1")
+		}
+
+		return lines
+	}
+
+	var lines []string
+	add := func(more ...string) {
+		lines = append(lines, more...)
+	}
+
+	add(
+		"("+routine+")",
+		"@R15",
+		"M=D",
+
+		// -|y|
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@"+label("Y_NEGATIVE"),
+		"D;JLE",
+		"D=-D",
+		"("+label("Y_NEGATIVE")+")",
+	)
+	add(slot(0)...)
+	add(
+		"M=D",
+
+		// -|x|, where the remainder starts
+		"@SP",
+		"A=M-1",
+		"A=A-1",
+		"D=M",
+		"@"+label("X_NEGATIVE"),
+		"D;JLE",
+		"D=-D",
+		"("+label("X_NEGATIVE")+")",
+		locRegister,
+		"M=D",
+	)
+	add(slot(1)...)
+	add(
+		"M=0",
+	)
+	add(slot(0)...)
+	add(
+		"D=M",
+		"@"+label("RESULT"),
+		"D;JEQ",
+		"@16384",
+		"D=D+A",
+		"@"+label("ONCE"),
+		"D;JLT",
+
+		// The remainder starts from nothing and takes in the 16 bits of |x|
+		locRegister,
+		"D=M",
+		valueRegister,
+		"M=-D",
+		locRegister,
+		"M=0",
+		"@16",
+		"D=-A",
+	)
+	add(slot(2)...)
+	add(
+		"M=D",
+
+		"("+label("LOOP")+")",
+		locRegister,
+		"D=M",
+		"M=D+M",
+		valueRegister,
+		"D=M",
+		"@"+label("ZERO_BIT"),
+		"D;JGE",
+		locRegister,
+		"M=M-1",
+		"("+label("ZERO_BIT")+")",
+		valueRegister,
+		"D=M",
+		"M=D+M",
+	)
+	add(slot(1)...)
+	add(
+		"D=M",
+		"M=D+M",
+	)
+	add(slot(0)...)
+	add(
+		"D=M",
+		locRegister,
+		"D=M-D",
+		"@"+label("NEXT"),
+		"D;JGT",
+		locRegister,
+		"M=D",
+	)
+	add(slot(1)...)
+	add(
+		"M=M+1",
+		"("+label("NEXT")+")",
+	)
+	add(slot(2)...)
+	add(
+		"MD=M+1",
+		"@"+label("LOOP"),
+		"D;JLT",
+		"@"+label("RESULT"),
+		"0;JMP",
+
+		"("+label("ONCE")+")",
+	)
+	add(slot(0)...)
+	add(
+		"D=M",
+		locRegister,
+		"D=D-M",
+		"@"+label("RESULT"),
+		"D;JLT",
+		locRegister,
+		"M=-D",
+	)
+	add(slot(1)...)
+	add(
+		"M=1",
+
+		"("+label("RESULT")+")",
+	)
+
+	if routine == "DIV" {
+		// The quotient is negative when one of x and y is
+		add(slot(1)...)
+		add(
+			"D=M",
+			valueRegister,
+			"M=D",
+			"@SP",
+			"A=M-1",
+			"D=M",
+			"@"+label("Y_POSITIVE"),
+			"D;JGE",
+			valueRegister,
+			"M=-M",
+			"("+label("Y_POSITIVE")+")",
+		)
+	} else {
+		add(
+			locRegister,
+			"D=M",
+			valueRegister,
+			"M=-D",
+		)
+	}
+
+	add(
+		"@SP",
+		"A=M-1",
+		"A=A-1",
+		"D=M",
+		"@"+label("X_POSITIVE"),
+		"D;JGE",
+		valueRegister,
+		"M=-M",
+		"("+label("X_POSITIVE")+")",
+
+		valueRegister,
+		"D=M",
+		"@SP",
+		"AM=M-1",
+		"A=A-1",
+		"M=D",
+
+		"@R15",
+		"A=M",
+		"0;JMP",
+	)
+
+	return []string{strings.Join(lines, "\n") + "\n"}
+}
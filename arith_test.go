@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+// What the commands leave in temp 2, run by the VM interpreter and by the
+// CPU on their translation, from x and y in temp 0 and 1
+func runBothWays(t *testing.T, commands []Command, x int16, y int16) (int16, int16) {
+	m, err := newVMMachine(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ram[memory.Temp], m.ram[memory.Temp+1] = x, y
+	for !m.halted {
+		if err := m.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	translator := newTranslator()
+	var instructions []string
+	for _, command := range commands {
+		asm, err := translator.translateCommand(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		instructions = append(instructions, asm)
+	}
+
+	rom, err := assemble(asmLines(instructions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := newHackCPU(rom)
+	cpu.ram[0] = int16(memory.Stack)
+	cpu.ram[memory.Temp], cpu.ram[memory.Temp+1] = x, y
+	cpu.run()
+
+	if cpu.fault != "" {
+		t.Fatal(cpu.fault)
+	}
+
+	return m.ram[memory.Temp+2], cpu.ram[memory.Temp+2]
+}
+
+// min, max and abs agree between run and emulate at the edges of the range,
+// where y-x overflows
+func TestMinMaxAbs(t *testing.T) {
+	language := dialect
+	dialect = "extended"
+	defer func() { dialect = language }()
+
+	values := []int16{-32768, -32767, -1, 0, 1, 32766, 32767}
+
+	for _, op := range []string{"min", "max"} {
+		commands := []Command{
+			{Kind: "push", Args: []string{"temp", "0"}},
+			{Kind: "push", Args: []string{"temp", "1"}},
+			{Kind: op},
+			{Kind: "pop", Args: []string{"temp", "2"}},
+		}
+
+		for _, x := range values {
+			for _, y := range values {
+				want := x
+				if x < y != (op == "min") {
+					want = y
+				}
+
+				run, emulated := runBothWays(t, commands, x, y)
+				if run != want || emulated != want {
+					t.Errorf("%d %s %d: run gives %d, emulate %d, want %d", x, op, y, run, emulated, want)
+				}
+			}
+		}
+	}
+
+	commands := []Command{
+		{Kind: "push", Args: []string{"temp", "0"}},
+		{Kind: "abs"},
+		{Kind: "pop", Args: []string{"temp", "2"}},
+	}
+
+	for _, x := range values {
+		// -32768 has no positive counterpart, and stays as it is
+		want := x
+		if x < 0 {
+			want = -x
+		}
+
+		run, emulated := runBothWays(t, commands, x, 0)
+		if run != want || emulated != want {
+			t.Errorf("abs %d: run gives %d, emulate %d, want %d", x, run, emulated, want)
+		}
+	}
+}
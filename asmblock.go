@@ -0,0 +1,34 @@
+package main
+
+import "strings"
+
+// Hand-written asm in a .vm file, passed on to the output as it's written,
+// for hand-optimizing what matters most. Either a block:
+//
+//	asm {
+//	@SP
+//	AM=M-1
+//	}
+//
+// or a line at a time, as `//!asm @SP`. The translator doesn't look inside,
+// so the asm has to leave the stack and segments as the code around it
+// expects them
+const inlineAsmPrefix = "//!asm"
+
+// The asm on a `//!asm` line, if it is one
+func inlineAsmLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+
+	asm := strings.TrimPrefix(line, inlineAsmPrefix)
+	if asm == line || asm != "" && asm[0] != ' ' && asm[0] != '\t' {
+		return "", false
+	}
+
+	return strings.TrimSpace(asm), true
+}
+
+// Whether a line, comments taken out, opens an asm block
+func opensAsmBlock(code string) bool {
+	fields := strings.Fields(code)
+	return len(fields) == 2 && fields[0] == "asm" && fields[1] == "{" || len(fields) == 1 && fields[0] == "asm{"
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols every Hack assembler knows before reading the program
+var predefinedSymbols = map[string]int{
+	"SP": 0, "LCL": 1, "ARG": 2, "THIS": 3, "THAT": 4,
+	"SCREEN": 16384, "KBD": 24576,
+}
+
+func init() {
+	for i := 0; i <= 15; i++ {
+		predefinedSymbols[fmt.Sprintf("R%d", i)] = i
+	}
+}
+
+// The first RAM address the assembler hands out to variables
+const firstVariableAddress = 16
+
+type asmSymbol struct {
+	Name    string
+	Address int
+	// Labels live in ROM, variables in RAM
+	Label bool
+}
+
+// Splits translated instructions, which may hold several lines each, into
+// single trimmed lines
+func asmLines(instructions []string) []string {
+	var lines []string
+
+	for _, instruction := range instructions {
+		for _, line := range strings.Split(strings.TrimSuffix(instruction, "\n"), "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+
+	return lines
+}
+
+// Resolves labels and variables the same way the Hack assembler does: labels
+// to the ROM address of the next instruction, anything else to RAM from 16 in
+// order of first use
+func resolveSymbols(lines []string) []asmSymbol {
+	var symbols []asmSymbol
+	labels := map[string]bool{}
+	address := 0
+
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+
+		if strings.HasPrefix(line, "(") {
+			label := strings.Trim(line, "()")
+			labels[label] = true
+			symbols = append(symbols, asmSymbol{Name: label, Address: address, Label: true})
+			continue
+		}
+
+		if isInstruction(line) {
+			address++
+		}
+	}
+
+	variables := map[string]bool{}
+	next := firstVariableAddress
+
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+		if !strings.HasPrefix(line, "@") {
+			continue
+		}
+
+		name := line[1:]
+		if _, err := strconv.Atoi(name); err == nil {
+			continue
+		}
+
+		if _, ok := predefinedSymbols[name]; ok || labels[name] || variables[name] {
+			continue
+		}
+
+		variables[name] = true
+		symbols = append(symbols, asmSymbol{Name: name, Address: next})
+		next++
+	}
+
+	return symbols
+}
+
+// One `ROM|RAM address name` line per symbol, ROM first, each by address
+func symbolFile(symbols []asmSymbol) string {
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].Label != symbols[j].Label {
+			return symbols[i].Label
+		}
+
+		return symbols[i].Address < symbols[j].Address
+	})
+
+	var lines []string
+	for _, symbol := range symbols {
+		space := "RAM"
+		if symbol.Label {
+			space = "ROM"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %d %s", space, symbol.Address, symbol.Name))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// The a-bit and c-bits of each computation, keyed by the mnemonic
+var compCodes = map[string]uint16{
+	"0": 0b0101010, "1": 0b0111111, "-1": 0b0111010,
+	"D": 0b0001100, "A": 0b0110000, "M": 0b1110000,
+	"!D": 0b0001101, "!A": 0b0110001, "!M": 0b1110001,
+	"-D": 0b0001111, "-A": 0b0110011, "-M": 0b1110011,
+	"D+1": 0b0011111, "A+1": 0b0110111, "M+1": 0b1110111,
+	"D-1": 0b0001110, "A-1": 0b0110010, "M-1": 0b1110010,
+	"D+A": 0b0000010, "D+M": 0b1000010,
+	"D-A": 0b0010011, "D-M": 0b1010011,
+	"A-D": 0b0000111, "M-D": 0b1000111,
+	"D&A": 0b0000000, "D&M": 0b1000000,
+	"D|A": 0b0010101, "D|M": 0b1010101,
+}
+
+// The extended ALU's shifts, which go in instructions starting 101 rather
+// than 111
+var shiftCodes = map[string]uint16{
+	"A<<": 0b0100000, "D<<": 0b0110000, "M<<": 0b1100000,
+	"A>>": 0b0000000, "D>>": 0b0010000, "M>>": 0b1000000,
+}
+
+// Operand orders the assembler also accepts for the commutative computations
+var compAliases = map[string]string{
+	"A+D": "D+A", "M+D": "D+M", "A&D": "D&A", "M&D": "D&M", "A|D": "D|A", "M|D": "D|M",
+}
+
+var jumpCodes = map[string]uint16{
+	"": 0, "JGT": 1, "JEQ": 2, "JGE": 3, "JLT": 4, "JNE": 5, "JLE": 6, "JMP": 7,
+}
+
+func destCode(dest string) (uint16, bool) {
+	var code uint16
+
+	for _, register := range dest {
+		bit := map[rune]uint16{'A': 4, 'D': 2, 'M': 1}[register]
+		if bit == 0 || code&bit != 0 {
+			return 0, false
+		}
+
+		code |= bit
+	}
+
+	return code, true
+}
+
+// Assembles the program into Hack machine code, resolving symbols as
+// resolveSymbols does
+func assemble(lines []string) ([]uint16, error) {
+	symbols := map[string]int{}
+	for name, address := range predefinedSymbols {
+		symbols[name] = address
+	}
+
+	for _, symbol := range resolveSymbols(lines) {
+		if symbol.Label {
+			if _, ok := symbols[symbol.Name]; ok {
+				return nil, fmt.Errorf("label defined twice: %s", symbol.Name)
+			}
+		}
+
+		symbols[symbol.Name] = symbol.Address
+	}
+
+	var rom []uint16
+
+	for n, line := range lines {
+		line = strings.Join(strings.Fields(strings.Split(line, "//")[0]), "")
+		if line == "" || strings.HasPrefix(line, "(") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@") {
+			value, err := strconv.Atoi(line[1:])
+			if err != nil {
+				value = symbols[line[1:]]
+			} else if value < 0 || value > 32767 {
+				return nil, fmt.Errorf("line %d: constant out of range: %s", n+1, line)
+			}
+
+			rom = append(rom, uint16(value))
+			continue
+		}
+
+		dest, comp, jump := "", line, ""
+		if i := strings.Index(comp, "="); i >= 0 {
+			dest, comp = comp[:i], comp[i+1:]
+		}
+
+		if i := strings.Index(comp, ";"); i >= 0 {
+			comp, jump = comp[:i], comp[i+1:]
+		}
+
+		destBits, ok := destCode(dest)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid destination: %s", n+1, line)
+		}
+
+		jumpBits, ok := jumpCodes[jump]
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid jump: %s", n+1, line)
+		}
+
+		if alias, ok := compAliases[comp]; ok {
+			comp = alias
+		}
+
+		prefix := uint16(0b111) << 13
+		compBits, ok := compCodes[comp]
+		if !ok {
+			prefix = uint16(0b101) << 13
+			compBits, ok = shiftCodes[comp]
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid computation: %s", n+1, line)
+		}
+
+		rom = append(rom, prefix|compBits<<6|destBits<<3|jumpBits)
+	}
+
+	if len(rom) > 32768 {
+		return nil, fmt.Errorf("program too big for the ROM: %d instructions", len(rom))
+	}
+
+	return rom, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Labels go to the ROM address of the next instruction, whether they're used
+// before or after they're defined. Anything else that isn't predefined or a
+// number is a variable, from 16 in order of first use
+func TestResolveSymbols(t *testing.T) {
+	lines := []string{
+		"@i", "M=0",
+		"(LOOP)", "@LOOP", "0;JMP",
+		"@END", "(END)",
+		"@sum", "@i", "@R5", "@SCREEN", "@42",
+	}
+
+	var got []string
+	for _, symbol := range resolveSymbols(lines) {
+		got = append(got, strings.TrimSpace(symbolFile([]asmSymbol{symbol})))
+	}
+
+	want := "ROM 2 LOOP, ROM 5 END, RAM 16 i, RAM 17 sum"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ", "), want)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		line string
+		want uint16
+	}{
+		{"@21", 21},
+		{"D=M", 0b1111110000010000},
+		{"AM=M-1", 0b1111110010101000},
+		{"M=D+M", 0b1111000010001000},
+		// The commutative computations either way round
+		{"D=A+D", 0b1110000010010000},
+		{"D;JGT", 0b1110001100000001},
+		{"0;JMP", 0b1110101010000111},
+		// The extended ALU's shifts, starting 101
+		{"A=A<<", 0b1010100000100000},
+		{"D=D<<", 0b1010110000010000},
+		{"M=M<<", 0b1011100000001000},
+		{"D=A>>", 0b1010000000010000},
+		{"AD=D>>", 0b1010010000110000},
+		{"M=M>>;JLT", 0b1011000000001100},
+	}
+
+	for _, test := range tests {
+		rom, err := assemble([]string{test.line})
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+
+		if len(rom) != 1 || rom[0] != test.want {
+			t.Errorf("%s: got %016b, want %016b", test.line, rom, test.want)
+		}
+	}
+
+	for line, want := range map[string]string{
+		"D=D+D":        "line 1: invalid computation: D=D+D",
+		"DD=M":         "line 1: invalid destination: DD=M",
+		"0;JXX":        "line 1: invalid jump: 0;JXX",
+		"@40000":       "line 1: constant out of range: @40000",
+		"(TOP)\n(TOP)": "label defined twice: TOP",
+	} {
+		if _, err := assemble(strings.Split(line, "\n")); err == nil || err.Error() != want {
+			t.Errorf("%q: got %v, want %s", line, err, want)
+		}
+	}
+}
+
+// The CPU shifts by one place, keeping the sign on the way right
+func TestShifts(t *testing.T) {
+	rom, err := assemble([]string{
+		"@3", "D=A", "D=D<<", "@R0", "M=D",
+		"@R1", "M=-1", "M=M<<", "M=M<<",
+		"@R2", "M=-1", "M=M<<", "M=M>>",
+		"@12", "A=A>>", "D=A", "@R3", "M=D",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := newHackCPU(rom)
+	cpu.run()
+
+	for address, want := range []int16{6, -4, -1, 6} {
+		if got := cpu.ram[address]; got != want {
+			t.Errorf("RAM[%d] is %d, want %d", address, got, want)
+		}
+	}
+}
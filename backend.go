@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A target other than Hack asm that the parsed program can be emitted for
+type Backend interface {
+	// The extension of the file the output is written to, e.g. ".c"
+	Extension() string
+	Emit(commands []Command) (string, error)
+}
+
+var backends = map[string]Backend{
+	"c":    cBackend{},
+	"llvm": llvmBackend{},
+	"wat":  watBackend{},
+}
+
+// Parses the program and writes it out through the chosen backend, next to
+// where the .asm would go
+func emitBackend(backend Backend) error {
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		return err
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		return err
+	}
+
+	if osFolder != "" {
+		commands, err = includeOS(commands, osFolder)
+		if err != nil {
+			return err
+		}
+	}
+
+	source, err := backend.Emit(commands)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(pathToTranslate), ".vm") + backend.Extension()
+	folder := pathToTranslate
+	if filepath.Ext(folder) == ".vm" {
+		folder = filepath.Dir(folder)
+	}
+
+	fileName := filepath.Join(folder, name)
+	if outputPath != "" {
+		fileName = outputPath
+	}
+
+	return os.WriteFile(fileName, []byte(source), 0644)
+}
+
+// Where the parts of the program end up, for backends that work from the
+// commands rather than the asm. Positions are indexes into the commands
+type programLayout struct {
+	// Keyed by `function$label`, as labels are scoped to their function, or
+	// `File.vm$label` with -labels=file
+	labels    map[string]int
+	functions map[string]int
+	// RAM address of each static, keyed by `File.vm.index`
+	statics map[string]int
+	// The functions each command belongs to
+	owners []string
+	// What each command's labels are scoped to
+	scopes []string
+	// Gotos straight back to the label before them, which is how VM programs
+	// end; backends treat them as halting
+	halts map[int]bool
+}
+
+func scopedLabel(function string, label string) string {
+	return function + "$" + label
+}
+
+// What a label in a function and file is scoped to
+func labelOwner(function string, file string) string {
+	if labelScope == "file" {
+		return filepath.Base(file)
+	}
+
+	return function
+}
+
+func staticKey(file string, index string) string {
+	return filepath.Base(file) + "." + index
+}
+
+// Lays the program out, checking every jump and call has somewhere to go.
+// Statics get addresses the way the assembler would hand them out
+func layoutCommands(commands []Command) (programLayout, error) {
+	layout := programLayout{
+		labels:    map[string]int{},
+		functions: map[string]int{},
+		statics:   map[string]int{},
+		halts:     map[int]bool{},
+	}
+
+	next := firstVariableAddress
+	if memory.Static >= 0 {
+		next = memory.Static
+	}
+
+	current := ""
+
+	for i, command := range commands {
+		if command.Kind == "asm" {
+			return layout, fmt.Errorf("%s:%d: asm can only be translated to Hack asm", command.File, command.Line)
+		}
+
+		if command.Kind == "push" && len(command.Args) == 2 && command.Args[0] == "string" {
+			return layout, fmt.Errorf("%s:%d: push string can only be translated to Hack asm, or with -strings=os", command.File, command.Line)
+		}
+
+		if extendedOperations[command.Kind] && dialect != "extended" {
+			return layout, fmt.Errorf("%s:%d: %s needs -dialect=extended", command.File, command.Line, command.Kind)
+		}
+
+		if _, ok := commandArity[command.Kind]; !ok {
+			return layout, fmt.Errorf("%s:%d: invalid command: %s", command.File, command.Line, command)
+		}
+
+		if len(command.Args) != commandArity[command.Kind] {
+			return layout, fmt.Errorf("%s:%d: wrong number of arguments: %s", command.File, command.Line, command)
+		}
+
+		switch command.Kind {
+		case "function":
+			current = command.Args[0]
+			layout.functions[current] = i
+
+		case "label":
+			layout.labels[scopedLabel(labelOwner(current, command.File), command.Args[0])] = i
+
+		case "push", "pop":
+			if command.Args[0] != "static" {
+				break
+			}
+
+			key := staticKey(command.File, command.Args[1])
+			if _, ok := layout.statics[key]; ok {
+				break
+			}
+
+			if address, ok := pinnedStatic(command); ok {
+				layout.statics[key] = address
+				break
+			}
+
+			layout.statics[key] = next
+			next++
+		}
+
+		layout.owners = append(layout.owners, current)
+		layout.scopes = append(layout.scopes, labelOwner(current, command.File))
+
+		if command.Kind == "goto" && i > 0 && commands[i-1].Kind == "label" && commands[i-1].Args[0] == command.Args[0] {
+			layout.halts[i] = true
+		}
+	}
+
+	for i, command := range commands {
+		switch command.Kind {
+		case "goto", "if-goto":
+			if _, ok := layout.labels[scopedLabel(layout.scopes[i], command.Args[0])]; !ok {
+				return layout, fmt.Errorf("%s:%d: undefined label: %s", command.File, command.Line, command.Args[0])
+			}
+
+		case "call":
+			if _, ok := layout.functions[command.Args[0]]; !ok {
+				return layout, fmt.Errorf("%s:%d: undefined function: %s", command.File, command.Line, command.Args[0])
+			}
+		}
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type romBank struct {
+	File         string   `json:"file"`
+	Instructions int      `json:"instructions"`
+	Labels       []string `json:"labels"`
+	// Labels this bank jumps to that are defined in other banks
+	External []string `json:"external"`
+
+	lines []string
+}
+
+// Each bank after the first starts with this label, and each bank but the
+// last ends by jumping to the next
+func bankLabel(n int) string {
+	return fmt.Sprintf("BANK_%d", n)
+}
+
+func isInstruction(line string) bool {
+	return line != "" && !strings.HasPrefix(line, "(") && !strings.HasPrefix(line, "//")
+}
+
+// Splits the program into banks of at most `size` instructions, trampoline
+// included. Banks only end before an A-instruction or label, where A isn't
+// carrying anything into the next instruction
+func splitBanks(instructions []string, size int) ([]romBank, error) {
+	if size < 3 {
+		return nil, fmt.Errorf("bank size must be at least 3 instructions")
+	}
+
+	lines := asmLines(instructions)
+
+	var banks []romBank
+
+	for start := 0; start < len(lines); {
+		var bank romBank
+		if len(banks) > 0 {
+			bank.lines = append(bank.lines, fmt.Sprintf("(%s)", bankLabel(len(banks))))
+		}
+
+		// Find the last split point that still leaves room for the trampoline
+		count, end, lastSplit := 0, start, -1
+
+		for ; end < len(lines); end++ {
+			line := lines[end]
+			startsChunk := strings.HasPrefix(line, "@") || strings.HasPrefix(line, "(")
+
+			if startsChunk && count <= size-2 {
+				lastSplit = end
+			}
+
+			if isInstruction(line) {
+				count++
+			}
+
+			if count > size {
+				break
+			}
+		}
+
+		if end == len(lines) && count <= size {
+			// The rest fits without a trampoline
+			bank.lines = append(bank.lines, lines[start:]...)
+			banks = append(banks, bank)
+			break
+		}
+
+		if lastSplit <= start {
+			return nil, fmt.Errorf("no place to split bank %d within %d instructions", len(banks), size)
+		}
+
+		bank.lines = append(bank.lines, lines[start:lastSplit]...)
+		bank.lines = append(bank.lines,
+			fmt.Sprintf("@%s", bankLabel(len(banks)+1)),
+			"0;JMP",
+		)
+
+		banks = append(banks, bank)
+		start = lastSplit
+	}
+
+	describeBanks(banks)
+
+	return banks, nil
+}
+
+// Fills in the manifest details for each bank
+func describeBanks(banks []romBank) {
+	definedIn := map[string]int{}
+
+	for i := range banks {
+		for _, line := range banks[i].lines {
+			if strings.HasPrefix(line, "(") {
+				label := strings.Trim(line, "()")
+				definedIn[label] = i
+				banks[i].Labels = append(banks[i].Labels, label)
+			}
+
+			if isInstruction(line) {
+				banks[i].Instructions++
+			}
+		}
+	}
+
+	for i := range banks {
+		external := map[string]bool{}
+
+		for _, line := range banks[i].lines {
+			if !strings.HasPrefix(line, "@") {
+				continue
+			}
+
+			if bank, ok := definedIn[line[1:]]; ok && bank != i {
+				external[line[1:]] = true
+			}
+		}
+
+		banks[i].External = []string{}
+		for label := range external {
+			banks[i].External = append(banks[i].External, label)
+		}
+
+		sort.Strings(banks[i].External)
+	}
+}
+
+// The symbols of each bank: its own labels, at their addresses within the
+// bank, and the variables it uses. Those are found over the whole program,
+// so another bank's labels aren't taken for variables and each variable has
+// the one address
+func bankSymbols(banks []romBank) [][]asmSymbol {
+	var lines []string
+	for _, bank := range banks {
+		lines = append(lines, bank.lines...)
+	}
+
+	variables := map[string]asmSymbol{}
+	for _, symbol := range resolveSymbols(lines) {
+		if !symbol.Label {
+			variables[symbol.Name] = symbol
+		}
+	}
+
+	symbols := make([][]asmSymbol, len(banks))
+	for i, bank := range banks {
+		for _, symbol := range resolveSymbols(bank.lines) {
+			if symbol.Label {
+				symbols[i] = append(symbols[i], symbol)
+			} else if variable, ok := variables[symbol.Name]; ok {
+				symbols[i] = append(symbols[i], variable)
+			}
+		}
+	}
+
+	return symbols
+}
+
+func saveBanks(instructions []string, folder string, name string) error {
+	banks, err := splitBanks(instructions, romBankSize)
+	if err != nil {
+		return err
+	}
+
+	var symbols [][]asmSymbol
+	if shouldEmitSymbols {
+		symbols = bankSymbols(banks)
+	}
+
+	for i := range banks {
+		banks[i].File = fmt.Sprintf("%s.bank%d.asm", name, i)
+
+		contents := withLineEndings(strings.Join(banks[i].lines, "\n") + "\n")
+		if err := os.WriteFile(filepath.Join(folder, banks[i].File), []byte(contents), 0644); err != nil {
+			return err
+		}
+
+		// Each bank is assembled on its own, so its labels get addresses
+		// within it
+		if shouldEmitSymbols {
+			path := filepath.Join(folder, fmt.Sprintf("%s.bank%d.sym", name, i))
+			if err := os.WriteFile(path, []byte(symbolFile(symbols[i])), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"bankSize": romBankSize,
+		"banks":    banks,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(folder, name+".banks.json"), append(manifest, '\n'), 0644)
+}
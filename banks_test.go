@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every bank's .sym gives a variable the address it has in the whole
+// program, and has no other bank's labels as variables
+func TestBankSymbols(t *testing.T) {
+	program := []string{
+		"@Main.0\nM=0\n",
+		"@Main.1\nM=1\n",
+		"(LOOP)\n@Main.1\nD=M\n",
+		"@Main.0\nM=D\n",
+		"@LOOP\n0;JMP\n",
+	}
+
+	banks, err := splitBanks(program, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symbols := bankSymbols(banks)
+
+	var got []string
+	for i := range banks {
+		got = append(got, symbolFile(symbols[i]))
+	}
+
+	want := []string{
+		"RAM 16 Main.0\n",
+		"ROM 0 BANK_1\nROM 2 LOOP\nRAM 17 Main.1\n",
+		"ROM 0 BANK_2\nRAM 17 Main.1\n",
+		"ROM 0 BANK_3\nRAM 16 Main.0\n",
+	}
+
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
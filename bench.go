@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// The body of each function in the benchmark corpus, using every kind of
+// command. %[1]d is the function's number, %[2]d the one it calls and %[3]d
+// the static it uses
+const benchFunction = `function Bench.f%[1]d 2
+push argument 0
+push constant 7
+add
+pop local 0
+push static %[3]d
+push local 0
+lt
+if-goto DONE
+label LOOP
+push local 0
+push constant 1
+sub
+pop local 0
+push local 0
+if-goto LOOP
+label DONE
+push this 1
+pop that 2
+push temp 3
+pop pointer 1
+push local 1
+neg
+not
+push argument 1
+and
+push constant 3
+or
+push local 0
+eq
+push local 1
+gt
+pop temp 0
+push local 0
+call Bench.f%[2]d 1
+return
+`
+
+var benchFunctionLength = strings.Count(benchFunction, "\n")
+
+// How many statics the functions share, so however long the corpus, they fit
+// in the RAM below the stack
+const benchStatics = 64
+
+// A program of at least n commands, made up of functions using every kind of
+// command, for timing the translator without reading any files
+func BenchmarkCorpus(n int) []Command {
+	var source strings.Builder
+
+	for i := 0; i*benchFunctionLength < n; i++ {
+		fmt.Fprintf(&source, benchFunction, i, i/2, i%benchStatics)
+	}
+
+	commands, err := NewParser("Bench.vm").Parse(bufio.NewScanner(strings.NewReader(source.String())))
+	if err != nil {
+		panic(err)
+	}
+
+	return commands
+}
+
+// A function of at least n pushes and pops, of every segment but static and
+// indices up to 7, for timing the commands programs are mostly made of
+func AccessCorpus(n int) []Command {
+	var source strings.Builder
+
+	source.WriteString("function Bench.access 8\n")
+
+	for i := 0; i < n; i += 2 {
+		segment := accessTableSegments[i/2%len(accessTableSegments)]
+		index := i / 2 / len(accessTableSegments) % 8
+
+		if segment == "pointer" {
+			index %= 2
+		}
+
+		fmt.Fprintf(&source, "push %s %d\n", segment, index)
+
+		if segment == "constant" {
+			segment = "temp"
+		}
+
+		fmt.Fprintf(&source, "pop %s %d\n", segment, index)
+	}
+
+	source.WriteString("return\n")
+
+	commands, err := NewParser("Bench.vm").Parse(bufio.NewScanner(strings.NewReader(source.String())))
+	if err != nil {
+		panic(err)
+	}
+
+	return commands
+}
+
+// Writes a program like the benchmark corpus to a folder, its functions
+// spread over so many .vm files, for timing projects with many files
+func writeBenchFixture(folder string, n int, files int) error {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+
+	functions := (n + benchFunctionLength - 1) / benchFunctionLength
+	if functions < files {
+		functions = files
+	}
+
+	// Statics are the file's own, so the files share them out
+	statics := benchStatics / files
+	if statics < 1 {
+		statics = 1
+	}
+
+	i := 0
+	for file := 0; file < files; file++ {
+		var source strings.Builder
+
+		// The functions left, shared between the files left
+		for end := i + (functions-i)/(files-file); i < end; i++ {
+			fmt.Fprintf(&source, benchFunction, i, i/2, i%statics)
+		}
+
+		if err := os.WriteFile(filepath.Join(folder, fmt.Sprintf("Bench%d.vm", file)), []byte(source.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Translates the commands as a folder's are: through the optimization passes,
+// the translation and the program's layout
+func TranslateCorpus(commands []Command) ([]string, error) {
+	return layoutProgram(func() ([]string, error) {
+		commands, err := optimize(commands)
+		if err != nil {
+			return nil, err
+		}
+
+		return translate(commands)
+	})
+}
+
+// Times the translator on a generated corpus, or the program given, and
+// reports its throughput, what it allocates and the most memory it took
+func benchCommand(args []string) {
+	size := flag.Int("commands", 10000, "how many commands the generated corpus has")
+	duration := flag.Duration("time", time.Second, "how long to keep translating for")
+	fixture := flag.String("fixture", "", "write the generated corpus to this folder as -files .vm files, then time translating it")
+	files := flag.Int("files", 1, "how many .vm files the -fixture corpus is spread over")
+	corpus := flag.String("corpus", "program", "what the generated corpus is made of: program (every kind of command) or access (only pushes and pops)")
+	parseFlags(args)
+
+	if *fixture != "" {
+		if *files < 1 {
+			log.Fatalf("invalid files: %d", *files)
+		}
+
+		if err := writeBenchFixture(*fixture, *size, *files); err != nil {
+			log.Fatal(err)
+		}
+
+		pathToTranslate = *fixture
+	}
+
+	var commands []Command
+	var err error
+
+	if pathToTranslate != "" {
+		files, err := vmFiles(pathToTranslate)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if commands, err = parseFiles(files); err != nil {
+			log.Fatal(err)
+		}
+	} else if *corpus == "access" {
+		commands = AccessCorpus(*size)
+	} else if *corpus == "program" {
+		commands = BenchmarkCorpus(*size)
+	} else {
+		log.Fatalf("invalid corpus: %s", *corpus)
+	}
+
+	if len(commands) == 0 {
+		log.Fatal("nothing to translate")
+	}
+
+	// Once first, to fail early and to warm up
+	instructions, err := TranslateCorpus(commands)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The sizes of a run's VM and asm, as text
+	vmBytes, asmBytes := 0, 0
+	for _, command := range commands {
+		vmBytes += len(command.String()) + 1
+	}
+
+	for _, instruction := range instructions {
+		asmBytes += len(instruction)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	runs := 0
+	start := time.Now()
+
+	for runs == 0 || time.Since(start) < *duration {
+		if _, err := TranslateCorpus(commands); err != nil {
+			log.Fatal(err)
+		}
+
+		runs++
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	translated := float64(runs * len(commands))
+	seconds := elapsed.Seconds()
+
+	fmt.Printf("%d commands (%d KB of VM, %d KB of asm), translated %d times in %s\n",
+		len(commands), vmBytes>>10, asmBytes>>10, runs, elapsed.Round(time.Millisecond))
+	fmt.Printf("%.0f commands/sec\n", translated/seconds)
+	fmt.Printf("%.1f MB/s of VM in, %.1f MB/s of asm out\n",
+		float64(runs*vmBytes)/seconds/1e6, float64(runs*asmBytes)/seconds/1e6)
+	fmt.Printf("%.1f allocations and %.0f bytes per command\n",
+		float64(after.Mallocs-before.Mallocs)/translated, float64(after.TotalAlloc-before.TotalAlloc)/translated)
+	// What's been taken from the OS only ever grows, even once some of it is
+	// given back, so it's the most the translator has needed at once
+	fmt.Printf("peak memory %.1f MB (heap %.1f MB)\n", float64(after.Sys)/1e6, float64(after.HeapSys)/1e6)
+}
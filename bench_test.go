@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Translates the benchmark corpus as a folder is, reporting commands a second
+func BenchmarkTranslate(b *testing.B) {
+	commands := BenchmarkCorpus(10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		resetTranslation()
+
+		if _, err := TranslateCorpus(commands); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(commands)*b.N)/time.Since(start).Seconds(), "commands/s")
+}
+
+// Translates a command an op, so allocs/op is the allocations a command
+// makes
+func benchmarkCommands(b *testing.B, commands []Command) {
+	translator := newTranslator()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i%len(commands) == 0 {
+			translator = newTranslator()
+		}
+
+		if _, err := translator.translateCommand(commands[i%len(commands)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTranslateCommand(b *testing.B) {
+	benchmarkCommands(b, BenchmarkCorpus(1000))
+}
+
+// Pushes and pops, which come from the access tables
+func BenchmarkTranslateAccess(b *testing.B) {
+	benchmarkCommands(b, AccessCorpus(1000))
+}
+
+// The access tables hold what translating each push and pop would come to,
+// and hand it out without allocating
+func TestAccessTable(t *testing.T) {
+	table := accessTables()
+	translator := newTranslator()
+
+	for _, segment := range accessTableSegments {
+		for index := 0; index < accessTableSize; index++ {
+			for _, kind := range []string{"push", "pop"} {
+				access, handle := table.push, translator.handlePush
+				if kind == "pop" {
+					access, handle = table.pop, translator.handlePop
+				}
+
+				asm, ok, err := access.lookup(segment, index)
+				if !ok {
+					continue
+				}
+
+				want, _ := handle(segment, index)
+				if err != nil || asm != want {
+					t.Errorf("%s %s %d: table has %q, %v, translating gives %q", kind, segment, index, asm, err, want)
+				}
+			}
+		}
+	}
+
+	push := Command{Kind: "push", Args: []string{"local", "3"}}
+	pop := Command{Kind: "pop", Args: []string{"that", "1"}}
+
+	allocations := testing.AllocsPerRun(100, func() {
+		translator.translateCommand(push)
+		translator.translateCommand(pop)
+	})
+
+	if allocations != 0 {
+		t.Errorf("a push and pop allocate %v times, want none", allocations)
+	}
+}
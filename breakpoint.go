@@ -0,0 +1,32 @@
+package main
+
+import "strings"
+
+// What the breakpoint command is translated to: "nop", an instruction that
+// does nothing for the emulator and debugger to stop at, or "trap", a jump
+// to BREAKPOINT, which spins
+var breakpointMode string
+
+const breakpointTrap = "BREAKPOINT"
+
+func breakpoint() string {
+	if breakpointMode == "trap" {
+		return joinLines([]string{
+			"@" + breakpointTrap,
+			"0;JMP",
+		})
+	}
+
+	return joinLines([]string{"0"})
+}
+
+// Like the stack traps, spins on a well-known label
+func createBreakpointTrap() []string {
+	trap := strings.Join([]string{
+		"(" + breakpointTrap + ")",
+		"@" + breakpointTrap,
+		"0;JMP",
+	}, "\n") + "\n"
+
+	return []string{trap}
+}
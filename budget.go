@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The most instructions the output may have, so a program that won't fit
+// the ROM fails to build rather than to load. Zero means no limit
+var maxOutputInstructions = 32768
+
+// How many of the largest parts of the program an over-budget build lists
+const budgetBreakdownLength = 10
+
+// Fails a build whose output is over the budget, saying what takes the most
+// room. The asm is only read for that, once the build is known to fail.
+// Banked output isn't held to the one ROM
+func checkOutputSize(total int, asm func() (string, error)) error {
+	if maxOutputInstructions == 0 || romBankSize > 0 || total <= maxOutputInstructions {
+		return nil
+	}
+
+	contents, err := asm()
+	if err != nil {
+		return err
+	}
+
+	sizes := outputSizeBreakdown(contents)
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "output is %d instructions, over the limit of %d (-max-output-instructions) by %d; the largest parts:", total, maxOutputInstructions, total-maxOutputInstructions)
+
+	parts := sortedByCount(sizes)
+	for i, part := range parts {
+		if i == budgetBreakdownLength {
+			fmt.Fprintf(&message, "\n  ... and %d more", len(parts)-i)
+			break
+		}
+
+		fmt.Fprintf(&message, "\n  %6d  %s", sizes[part], part)
+	}
+
+	return fmt.Errorf("%s", message.String())
+}
+
+// The instructions in each function and shared routine of the asm. Those
+// outside of either, the bootstrap and any code before the first function,
+// are the top level's
+func outputSizeBreakdown(asm string) map[string]int {
+	routines := map[string]bool{}
+	for _, routine := range allRoutines() {
+		routines[routineName(routine)] = true
+	}
+
+	prefix := getFolderName() + "."
+	part := "(top level)"
+	sizes := map[string]int{}
+
+	for _, line := range strings.Split(asm, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "(") && strings.HasSuffix(line, ")") {
+			label := line[1 : len(line)-1]
+
+			switch {
+			case label == "START":
+				part = "(top level)"
+			case routines[label]:
+				part = "routine " + label
+			case strings.HasPrefix(label, prefix) && !strings.Contains(label, "$"):
+				part = strings.TrimPrefix(label, prefix)
+			}
+
+			continue
+		}
+
+		if isInstruction(line) {
+			sizes[part]++
+		}
+	}
+
+	return sizes
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Concatenates the files into a single .vm source. Statics are file scoped,
+// so each file's are moved past the previous files' to keep them apart
+func bundleSource(files []string) (string, error) {
+	var lines []string
+	staticOffset := 0
+
+	for _, file := range files {
+		commands, err := parseFile(file)
+		if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, fmt.Sprintf("// file: %s", filepath.Base(file)))
+		statics := 0
+
+		for _, command := range commands {
+			command.Kind = strings.ToLower(command.Kind)
+
+			if (command.Kind == "push" || command.Kind == "pop") && len(command.Args) == 2 {
+				command.Args = []string{strings.ToLower(command.Args[0]), command.Args[1]}
+
+				if command.Args[0] == "static" && command.StaticBase != 0 {
+					return "", fmt.Errorf("%s:%d: statics pinned by //!static-base can't be bundled", command.File, command.Line)
+				}
+
+				if command.Args[0] == "static" {
+					index, err := strconv.Atoi(command.Args[1])
+					if err != nil {
+						return "", fmt.Errorf("%s:%d: invalid static index: %s", command.File, command.Line, command.Args[1])
+					}
+
+					if index+1 > statics {
+						statics = index + 1
+					}
+
+					command.Args[1] = strconv.Itoa(index + staticOffset)
+				}
+			}
+
+			lines = append(lines, command.String())
+		}
+
+		staticOffset += statics
+		lines = append(lines, fmt.Sprintf("// end: %s", filepath.Base(file)), "")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Writes the bundle beside the folder rather than in it, so translating the
+// folder again doesn't pick it up
+func saveBundle() error {
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		return err
+	}
+
+	source, err := bundleSource(files)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(pathToTranslate), ".vm") + ".bundle.vm"
+
+	fileName := filepath.Join(filepath.Dir(filepath.Clean(pathToTranslate)), name)
+	if outputPath != "" {
+		fileName = outputPath
+	}
+
+	return os.WriteFile(fileName, []byte(source), 0644)
+}
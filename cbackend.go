@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Emits a portable C program: RAM is an array and each command a case of a
+// switch on the program counter, so jumps and returns just set it
+type cBackend struct{}
+
+func (cBackend) Extension() string {
+	return ".c"
+}
+
+const cPrelude = `#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int16_t ram[32768];
+
+#define M(address) ram[(uint16_t)(address) & 0x7fff]
+
+static void push(int16_t value) {
+	M(ram[0]) = value;
+	ram[0]++;
+}
+
+static int16_t pop(void) {
+	ram[0]--;
+	return M(ram[0]);
+}
+
+static int16_t shl(int16_t x, int16_t y) {
+	if (y <= 0) return x;
+	if (y >= 16) return 0;
+	return (int16_t)((uint16_t)x << y);
+}
+
+static int16_t shr(int16_t x, int16_t y) {
+	if (y <= 0) return x;
+	if (y >= 16) return x < 0 ? -1 : 0;
+	return (int16_t)(x >> y);
+}
+
+/* Dividing by zero gives 0, and leaves x as the remainder, as the DIV and
+   MOD routines do */
+static int16_t divide(int16_t x, int16_t y) {
+	if (y == 0) return 0;
+	return (int16_t)(x / y);
+}
+
+static int16_t modulo(int16_t x, int16_t y) {
+	if (y == 0) return x;
+	return (int16_t)(x % y);
+}
+
+/* Arguments of the form ADDRESS=VALUE set RAM before running, any other
+   ADDRESS is printed once the program halts */
+int main(int argc, char **argv) {
+	int pc = 0;
+
+	for (int i = 1; i < argc; i++) {
+		char *value = strchr(argv[i], '=');
+		if (value != NULL) M(atoi(argv[i])) = (int16_t)atoi(value + 1);
+	}
+`
+
+const cPostlude = `
+halt:
+	for (int i = 1; i < argc; i++) {
+		if (strchr(argv[i], '=') == NULL) printf("%d\n", M(atoi(argv[i])));
+	}
+
+	return 0;
+}
+`
+
+var cOperators = map[string]string{
+	"add": "x + y", "sub": "x - y", "and": "x & y", "or": "x | y",
+	"eq": "x == y ? -1 : 0", "gt": "x > y ? -1 : 0", "lt": "x < y ? -1 : 0",
+	"shl": "shl(x, y)", "shr": "shr(x, y)",
+	"mult": "x * y", "div": "divide(x, y)", "mod": "modulo(x, y)",
+	"min": "x < y ? x : y", "max": "x > y ? x : y",
+}
+
+// The RAM address a segment access refers to, as a C expression
+func cAddress(layout programLayout, command Command) (string, error) {
+	segment, index := command.Args[0], command.Args[1]
+
+	n, err := strconv.Atoi(index)
+	if err != nil {
+		return "", fmt.Errorf("invalid index: %s", index)
+	}
+
+	switch segment {
+	case "local":
+		return "ram[1] + " + index, nil
+	case "argument":
+		return "ram[2] + " + index, nil
+	case "this":
+		return "ram[3] + " + index, nil
+	case "that":
+		return "ram[4] + " + index, nil
+	case "pointer":
+		return "3 + " + index, nil
+	case "temp":
+		return fmt.Sprintf("%d + %s", memory.Temp, index), nil
+	case "global":
+		address, err := globalAddress(n)
+		return strconv.Itoa(address), err
+	case "static":
+		return strconv.Itoa(layout.statics[staticKey(command.File, index)]), nil
+	}
+
+	return "", fmt.Errorf("invalid segment: %s", segment)
+}
+
+func (cBackend) Emit(commands []Command) (string, error) {
+	layout, err := layoutCommands(commands)
+	if err != nil {
+		return "", err
+	}
+
+	// Return addresses live on the stack
+	if len(commands) > 32767 {
+		return "", fmt.Errorf("too many commands for the C backend: %d", len(commands))
+	}
+
+	lines := []string{fmt.Sprintf("/* Translated from %s */", getFolderName()), cPrelude}
+
+	if shouldStandardBootstrap || shouldSetStackPointer || shouldBootstrap {
+		lines = append(lines, fmt.Sprintf("\tram[0] = %d;", memory.Stack))
+	}
+
+	// The entry function returns, if at all, to address -1, which halts
+	if shouldStandardBootstrap || shouldBootstrap {
+		init, ok := layout.functions[entryFunction]
+		if !ok {
+			return "", fmt.Errorf("bootstrap needs a %s function", entryFunction)
+		}
+
+		for i := 0; i < entryArgs; i++ {
+			lines = append(lines, "\tpush(0);")
+		}
+
+		lines = append(lines,
+			"\tpush(-1); push(ram[1]); push(ram[2]); push(ram[3]); push(ram[4]);",
+			fmt.Sprintf("\tram[2] = ram[0] - %d; ram[1] = ram[0];", 5+entryArgs),
+			fmt.Sprintf("\tpc = %d;", init),
+		)
+	}
+
+	lines = append(lines, "", "\tfor (;;) {", "\t\tswitch (pc) {")
+
+	for i, command := range commands {
+		body, err := cCommand(layout, i, command)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
+
+		lines = append(lines, fmt.Sprintf("\t\tcase %d: /* %s */", i, command))
+
+		if body != "" {
+			lines = append(lines, "\t\t\t{ "+body+" }")
+		}
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("\t\tcase %d:", len(commands)),
+		"\t\tdefault:",
+		"\t\t\tgoto halt;",
+		"\t\t}",
+		"\t}",
+	)
+
+	return strings.Join(lines, "\n") + cPostlude, nil
+}
+
+// The statements for a single command. Cases fall through into the next
+// command unless the statements jump
+func cCommand(layout programLayout, i int, command Command) (string, error) {
+	switch command.Kind {
+	case "push":
+		if command.Args[0] == "constant" {
+			return fmt.Sprintf("push(%s);", command.Args[1]), nil
+		}
+
+		address, err := cAddress(layout, command)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("push(M(%s));", address), nil
+
+	case "pop":
+		address, err := cAddress(layout, command)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("int16_t value = pop(); M(%s) = value;", address), nil
+
+	case "neg":
+		return "push(-pop());", nil
+
+	case "not":
+		return "push(~pop());", nil
+
+	case "abs":
+		return "int16_t x = pop(); push(x < 0 ? -x : x);", nil
+
+	case "label", "breakpoint":
+		return "", nil
+
+	case "goto":
+		if layout.halts[i] {
+			return "goto halt;", nil
+		}
+
+		return fmt.Sprintf("pc = %d; continue;", layout.labels[scopedLabel(layout.scopes[i], command.Args[0])]), nil
+
+	case "if-goto":
+		return fmt.Sprintf("if (pop() != 0) { pc = %d; continue; }", layout.labels[scopedLabel(layout.scopes[i], command.Args[0])]), nil
+
+	case "function":
+		locals, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid vars for function definition (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		return strings.TrimSpace(strings.Repeat("push(0); ", locals)), nil
+
+	case "call":
+		args, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid args to function (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		return fmt.Sprintf(
+			"push(%d); push(ram[1]); push(ram[2]); push(ram[3]); push(ram[4]); ram[2] = ram[0] - %d; ram[1] = ram[0]; pc = %d; continue;",
+			i+1, 5+args, layout.functions[command.Args[0]],
+		), nil
+
+	case "return":
+		return "int16_t frame = ram[1]; int16_t address = M(frame - 5); M(ram[2]) = pop(); ram[0] = ram[2] + 1; " +
+			"ram[4] = M(frame - 1); ram[3] = M(frame - 2); ram[2] = M(frame - 3); ram[1] = M(frame - 4); " +
+			"if (address < 0) goto halt; pc = address; continue;", nil
+	}
+
+	if operator, ok := cOperators[command.Kind]; ok {
+		return fmt.Sprintf("int16_t y = pop(); int16_t x = pop(); push(%s);", operator), nil
+	}
+
+	return "", fmt.Errorf("invalid command: %s", command)
+}
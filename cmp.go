@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type asmLine struct {
+	number int
+	text   string
+}
+
+// The lines that matter to the assembler, with comments and whitespace gone
+func significantLines(source string) []asmLine {
+	var lines []asmLine
+
+	for i, line := range strings.Split(source, "\n") {
+		line = strings.Join(strings.Fields(strings.Split(line, "//")[0]), "")
+		if line != "" {
+			lines = append(lines, asmLine{i + 1, line})
+		}
+	}
+
+	return lines
+}
+
+// A label or variable name in an instruction, as opposed to a number or one of
+// the predefined symbols, which have to match exactly
+func renameableSymbol(line string) (string, bool) {
+	name := ""
+
+	if strings.HasPrefix(line, "(") {
+		name = strings.Trim(line, "()")
+	} else if strings.HasPrefix(line, "@") {
+		name = line[1:]
+	}
+
+	return name, name != "" && isSymbol(name)
+}
+
+// Compares two asm sources up to a consistent renaming of labels and
+// variables. Returns a description of the first difference, or "" if there's
+// none
+func compareAsm(a string, b string) string {
+	linesA, linesB := significantLines(a), significantLines(b)
+	labelsA, labelsB := map[string]bool{}, map[string]bool{}
+
+	for _, line := range linesA {
+		if strings.HasPrefix(line.text, "(") {
+			labelsA[strings.Trim(line.text, "()")] = true
+		}
+	}
+
+	for _, line := range linesB {
+		if strings.HasPrefix(line.text, "(") {
+			labelsB[strings.Trim(line.text, "()")] = true
+		}
+	}
+
+	forward, backward := map[string]string{}, map[string]string{}
+
+	for i := 0; i < len(linesA) && i < len(linesB); i++ {
+		lineA, lineB := linesA[i], linesB[i]
+		difference := fmt.Sprintf("line %d: %s\nline %d: %s", lineA.number, lineA.text, lineB.number, lineB.text)
+
+		nameA, symbolA := renameableSymbol(lineA.text)
+		nameB, symbolB := renameableSymbol(lineB.text)
+
+		if !symbolA || !symbolB {
+			if lineA.text != lineB.text {
+				return difference
+			}
+
+			continue
+		}
+
+		// A label has to stay a label, a variable a variable, and both
+		// lines have to be the same kind of line
+		if lineA.text[0] != lineB.text[0] || labelsA[nameA] != labelsB[nameB] {
+			return difference
+		}
+
+		if mapped, ok := forward[nameA]; ok && mapped != nameB {
+			return difference + fmt.Sprintf("\n%s was renamed to %s earlier", nameA, mapped)
+		}
+
+		if mapped, ok := backward[nameB]; ok && mapped != nameA {
+			return difference + fmt.Sprintf("\n%s was renamed from %s earlier", nameB, mapped)
+		}
+
+		forward[nameA], backward[nameB] = nameB, nameA
+	}
+
+	if len(linesA) != len(linesB) {
+		return fmt.Sprintf("lengths differ: %d lines against %d", len(linesA), len(linesB))
+	}
+
+	return ""
+}
+
+// Compares two .asm files, exiting 1 if they differ by more than label
+// renaming and comments
+func compareCommand(args []string) {
+	parseFlags(args)
+
+	if flag.NArg() != 2 {
+		log.Fatal("cmp needs two .asm files")
+	}
+
+	a, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b, err := os.ReadFile(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if difference := compareAsm(string(a), string(b)); difference != "" {
+		fmt.Printf("%s and %s differ\n%s\n", flag.Arg(0), flag.Arg(1), difference)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s and %s match up to label renaming\n", flag.Arg(0), flag.Arg(1))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The trimmed cells of a line of a compare or output file
+func cmpCells(line string) []string {
+	cells := strings.Split(strings.Trim(strings.TrimSpace(line), "|"), "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+
+	return cells
+}
+
+// Headings are cut short to fit their column, which can leave RAM[3006]
+// as RAM[3006
+func cmpColumnName(heading string) string {
+	if strings.HasPrefix(heading, "RAM[") && !strings.HasSuffix(heading, "]") {
+		return heading + "]"
+	}
+
+	return heading
+}
+
+// Lists the columns where an output line differs from the expected one, or
+// describes the whole line when they don't line up as columns or no one
+// column differs, as when only the padding does
+func describeMismatch(names []string, line string, expected string) string {
+	actualCells, expectedCells := cmpCells(line), cmpCells(expected)
+	if len(actualCells) != len(expectedCells) || len(actualCells) != len(names) {
+		return fmt.Sprintf("expected %q, got %q", expected, line)
+	}
+
+	var differences []string
+	for i, name := range names {
+		if !matchesCompareLine(actualCells[i], expectedCells[i]) {
+			differences = append(differences, fmt.Sprintf("%s expected %s, got %s", name, expectedCells[i], actualCells[i]))
+		}
+	}
+
+	if differences == nil {
+		return fmt.Sprintf("expected %q, got %q", expected, line)
+	}
+
+	return strings.Join(differences, "; ")
+}
+
+func isCmpValue(cell string) bool {
+	if strings.Trim(cell, "*") == "" {
+		return true
+	}
+
+	_, err := strconv.Atoi(cell)
+	return err == nil
+}
+
+// Checks the RAM against each row of a compare file, returning a line per
+// value that differs. Rows of headings name the columns of the rows below
+func checkCmpFile(path string, ram []int16) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var mismatches []string
+
+	for n, line := range strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		cells := cmpCells(line)
+
+		heading := false
+		for _, cell := range cells {
+			if !isCmpValue(cell) {
+				heading = true
+			}
+		}
+
+		if heading {
+			names = nil
+			for _, cell := range cells {
+				names = append(names, cmpColumnName(cell))
+			}
+
+			continue
+		}
+
+		if len(cells) != len(names) {
+			return nil, fmt.Errorf("%s:%d: expected %d columns, got %d", path, n+1, len(names), len(cells))
+		}
+
+		for i, cell := range cells {
+			if strings.Contains(cell, "*") {
+				continue
+			}
+
+			name := names[i]
+			address, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "RAM["), "]"))
+			if err != nil || !strings.HasPrefix(name, "RAM[") || address < 0 || address >= len(ram) {
+				return nil, fmt.Errorf("%s:%d: can only check RAM, not %s", path, n+1, name)
+			}
+
+			expected, _ := strconv.Atoi(cell)
+			if int(ram[address]) != expected {
+				mismatches = append(mismatches, fmt.Sprintf("%s expected %d, got %d", name, expected, ram[address]))
+			}
+		}
+	}
+
+	return mismatches, nil
+}
+
+// Reports where the RAM a run finished with differs from a compare file,
+// exiting 1 if it does anywhere
+func checkFinalRAM(path string, ram []int16, time string) {
+	mismatches, err := checkCmpFile(path, ram)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, mismatch := range mismatches {
+		fmt.Printf("%s: %s: %s\n", path, time, mismatch)
+	}
+
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: %s: comparison ended successfully\n", path, time)
+}
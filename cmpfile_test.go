@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDescribeMismatch(t *testing.T) {
+	names := []string{"RAM[0]", "RAM[256]"}
+
+	tests := []struct {
+		line, expected, want string
+	}{
+		{"|    257 |     7 |", "|    257 |     8 |", "RAM[256] expected 8, got 7"},
+		{"|    257 |", "|    257 |     8 |", `expected "|    257 |     8 |", got "|    257 |"`},
+		// Only the padding differs, so no one column does
+		{"|  257 |  8 |", "|    257 |     8 |", `expected "|    257 |     8 |", got "|  257 |  8 |"`},
+	}
+
+	for _, test := range tests {
+		if got := describeMismatch(names, test.line, test.expected); got != test.want {
+			t.Errorf("describeMismatch(%q, %q) = %q, want %q", test.line, test.expected, got, test.want)
+		}
+	}
+}
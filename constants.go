@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// A named constant in a .vm file. `const SCREEN 16384` lets the pushes and
+// pops after it in the file use SCREEN where the number would go, as in
+// `push constant SCREEN` or `pop static SLOT`. A constant can be defined as
+// another.
+//
+// An alias names a slot of one segment: after `alias COUNTER temp 3`,
+// `pop temp COUNTER` is `pop temp 3`, and using COUNTER with any other
+// segment is a mistake
+type vmConstant struct {
+	value string
+	line  int
+	// The segment an alias is for, empty for a constant
+	segment string
+}
+
+func (p *Parser) defineConstant(name string, segment string, value string, lineNumber int) error {
+	if !isName(name) || name[0] >= '0' && name[0] <= '9' {
+		return fmt.Errorf("%s:%d: invalid constant name: %s", p.file, lineNumber, name)
+	}
+
+	if previous, ok := p.constants[name]; ok {
+		return fmt.Errorf("%s:%d: %s is already defined on line %d", p.file, lineNumber, name, previous.line)
+	}
+
+	if segment != "" && !isSegment(segment) {
+		return fmt.Errorf("%s:%d: invalid segment for alias %s: %s", p.file, lineNumber, name, segment)
+	}
+
+	if other, ok := p.constants[value]; ok && other.segment == "" {
+		value = other.value
+	}
+
+	if _, err := strconv.Atoi(value); err != nil {
+		return fmt.Errorf("%s:%d: invalid value for %s: %s", p.file, lineNumber, name, value)
+	}
+
+	if p.constants == nil {
+		p.constants = map[string]vmConstant{}
+	}
+
+	p.constants[name] = vmConstant{value, lineNumber, segment}
+
+	return nil
+}
+
+// Puts the number in place of a push or pop's symbolic index
+func (p *Parser) resolveIndex(fields []string, lineNumber int) error {
+	index := fields[2]
+	if index == "" || index[0] == '-' || index[0] >= '0' && index[0] <= '9' {
+		return nil
+	}
+
+	constant, ok := p.constants[index]
+	if !ok {
+		return fmt.Errorf("%s:%d: unknown constant: %s", p.file, lineNumber, index)
+	}
+
+	if constant.segment != "" && constant.segment != fields[1] {
+		return fmt.Errorf("%s:%d: %s is an alias for %s %s, not for a %s index", p.file, lineNumber, index, constant.segment, constant.value, fields[1])
+	}
+
+	fields[2] = constant.value
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The VM translator projects of the nand2tetris course, by the name of their
+// folder in the software suite, older suites numbering them 07 and 08
+var courseProjects = [][]string{{"07", "7"}, {"08", "8"}}
+
+// The programs the course tests a VM translator with
+var coursePrograms = []string{
+	"SimpleAdd", "StackTest", "BasicTest", "PointerTest", "StaticTest",
+	"BasicLoop", "FibonacciSeries", "SimpleFunction", "NestedCall", "FibonacciElement", "StaticsTest",
+}
+
+// The project 7 and 8 folders of a copy of the software suite, which may be
+// given as the suite itself, its projects folder or the two projects' parent
+func findCourseProjects(suite string) ([]string, error) {
+	for _, base := range []string{suite, filepath.Join(suite, "projects"), filepath.Join(suite, "nand2tetris", "projects")} {
+		var folders []string
+
+		for _, names := range courseProjects {
+			for _, name := range names {
+				if info, err := os.Stat(filepath.Join(base, name)); err == nil && info.IsDir() {
+					folders = append(folders, filepath.Join(base, name))
+					break
+				}
+			}
+		}
+
+		if len(folders) > 0 {
+			return folders, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no project 07 or 08 folders found in %s", suite)
+}
+
+// Runs the course's own test scripts for projects 7 and 8 against a local copy
+// of the nand2tetris software suite, reporting any programs it couldn't find
+func corpusCommand(args []string) {
+	cycles := flag.Int("cycles", 1000000, "clock cycles to run a project checked against a bare .cmp for")
+	parseFlags(args)
+
+	suite := flag.Arg(0)
+	if suite == "" {
+		suite = pathToTranslate
+	}
+
+	if suite == "" {
+		log.Fatal("corpus needs the nand2tetris software suite's folder")
+	}
+
+	folders, err := findCourseProjects(suite)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var projects []string
+	found := map[string]bool{}
+
+	for _, folder := range folders {
+		folderProjects, err := findProjects(folder)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, project := range folderProjects {
+			projects = append(projects, project)
+			found[filepath.Base(project)] = true
+		}
+	}
+
+	passed := checkProjects(suite, projects, *cycles, nil)
+
+	var missing []string
+	for _, program := range coursePrograms {
+		if !found[program] {
+			missing = append(missing, program)
+		}
+	}
+
+	if len(missing) > 0 {
+		fmt.Printf("\nnot found: %v\n", missing)
+	}
+
+	if !passed {
+		os.Exit(1)
+	}
+}
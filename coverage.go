@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How often each VM command ran, by mapping index. A command runs when its
+// first instruction does, so commands translating to nothing, like labels,
+// count as run when the code they stand in front of is
+func commandRuns(program *programMap, romHits []int) []int {
+	runs := make([]int, len(program.mappings))
+
+	for i, mapping := range program.mappings {
+		if mapping.Start < len(romHits) {
+			runs[i] = romHits[mapping.Start]
+		}
+	}
+
+	return runs
+}
+
+// How often each line of each file ran, keyed by file and then line. Lines
+// without commands are missing
+func lineRuns(program *programMap, runs []int) map[string]map[int]int {
+	lines := map[string]map[int]int{}
+
+	for i, mapping := range program.mappings {
+		if lines[mapping.File] == nil {
+			lines[mapping.File] = map[int]int{}
+		}
+
+		lines[mapping.File][mapping.Line] += runs[i]
+	}
+
+	return lines
+}
+
+// A table of how many of each file's lines of commands ran
+func coverageSummary(files []string, lines map[string]map[int]int, markdown bool) string {
+	var rows [][]string
+	total, totalRun := 0, 0
+
+	for _, file := range files {
+		run := 0
+		for _, n := range lines[file] {
+			if n > 0 {
+				run++
+			}
+		}
+
+		rows = append(rows, []string{file, fmt.Sprint(len(lines[file])), fmt.Sprint(run), coveragePercent(run, len(lines[file]))})
+		total += len(lines[file])
+		totalRun += run
+	}
+
+	rows = append(rows, []string{"total", fmt.Sprint(total), fmt.Sprint(totalRun), coveragePercent(totalRun, total)})
+
+	return reportTable([]string{"File", "Lines", "Run", "Coverage"}, rows, markdown)
+}
+
+func coveragePercent(run int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(run)*100/float64(total))
+}
+
+// The source with how often each line ran in front of it, the way gcov
+// lists it: ##### for lines that never ran and - for lines without commands
+func coverageListing(source string, runs map[int]int) string {
+	var out strings.Builder
+
+	for i, line := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+		count := "-"
+		if n, ok := runs[i+1]; ok {
+			count = fmt.Sprint(n)
+			if n == 0 {
+				count = "#####"
+			}
+		}
+
+		fmt.Fprintf(&out, "%9s: %5d: %s\n", count, i+1, strings.TrimRight(line, "\r"))
+	}
+
+	return out.String()
+}
+
+// Runs the machine code and reports which lines of VM code ran, with a
+// listing of each file marking the lines that never did
+func coverageCommand(args []string) {
+	cycles := flag.Int("cycles", 10000000, "clock cycles to run for at most")
+	listing := flag.Bool("list", false, "print each file with how often its lines ran")
+	markdown := flag.Bool("md", false, "print the summary as Markdown")
+	parseFlags(args)
+
+	instructions, cpu, _ := loadHackCPU()
+	program := newProgramMap(instructions, len(cpu.rom))
+	romHits := make([]int, len(cpu.rom))
+
+	for !cpu.halted && cpu.cycles < *cycles {
+		if cpu.pc >= 0 && cpu.pc < len(romHits) {
+			romHits[cpu.pc]++
+		}
+
+		cpu.step()
+
+		if cpu.fault != "" {
+			log.Fatalf("%s at cycle %d", cpu.fault, cpu.cycles)
+		}
+	}
+
+	lines := lineRuns(program, commandRuns(program, romHits))
+
+	paths, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The program's own files, leaving out the ones the translator adds
+	var files, sources []string
+	for _, path := range paths {
+		if _, ok := lines[filepath.Base(path)]; ok {
+			files = append(files, filepath.Base(path))
+			sources = append(sources, path)
+		}
+	}
+
+	fmt.Print(coverageSummary(files, lines, *markdown))
+
+	if !*listing {
+		return
+	}
+
+	for i, file := range files {
+		source, err := os.ReadFile(sources[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("\n%s\n", file)
+		fmt.Print(coverageListing(string(source), lines[file]))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import "fmt"
+
+// Runs Hack machine code the way the CPU would
+type hackCPU struct {
+	rom []uint16
+	ram []int16
+	a   int16
+	d   int16
+	pc  int
+	// Set once the program sits in a loop jumping to itself, or runs off the
+	// end of the ROM
+	halted bool
+	// Why the program stopped, if it wasn't meant to
+	fault  string
+	cycles int
+}
+
+func newHackCPU(rom []uint16) *hackCPU {
+	return &hackCPU{rom: rom, ram: make([]int16, 32768)}
+}
+
+// The ALU's output for the instruction's c-bits, zx nx zy ny f no from the top
+func alu(bits uint16, x int16, y int16) int16 {
+	if bits&0b100000 != 0 {
+		x = 0
+	}
+
+	if bits&0b010000 != 0 {
+		x = ^x
+	}
+
+	if bits&0b001000 != 0 {
+		y = 0
+	}
+
+	if bits&0b000100 != 0 {
+		y = ^y
+	}
+
+	out := x & y
+	if bits&0b000010 != 0 {
+		out = x + y
+	}
+
+	if bits&0b000001 != 0 {
+		out = ^out
+	}
+
+	return out
+}
+
+// The extended ALU's shifts by one place
+func shiftALU(comp uint16, a int16, d int16, m int16) int16 {
+	value := a
+	switch comp & 0b1110000 {
+	case 0b0010000, 0b0110000:
+		value = d
+	case 0b1000000, 0b1100000:
+		value = m
+	}
+
+	if comp&0b0100000 != 0 {
+		return value << 1
+	}
+
+	return value >> 1
+}
+
+func (c *hackCPU) memory() int16 {
+	if int(uint16(c.a)) >= len(c.ram) {
+		return 0
+	}
+
+	return c.ram[uint16(c.a)]
+}
+
+// Runs one instruction
+func (c *hackCPU) step() {
+	if c.pc < 0 || c.pc >= len(c.rom) {
+		c.halted = true
+		return
+	}
+
+	instruction := c.rom[c.pc]
+	c.cycles++
+
+	if instruction&0x8000 == 0 {
+		c.a = int16(instruction)
+		c.pc++
+		return
+	}
+
+	comp := instruction >> 6 & 0b1111111
+
+	var value int16
+	if instruction>>13 == 0b101 {
+		value = shiftALU(comp, c.a, c.d, c.memory())
+	} else {
+		y := c.a
+		if comp&0b1000000 != 0 {
+			y = c.memory()
+		}
+
+		value = alu(comp&0b111111, c.d, y)
+	}
+
+	// M is written at the address A held before this instruction
+	address := int(uint16(c.a))
+
+	if instruction&0b100000 != 0 {
+		c.a = value
+	}
+
+	if instruction&0b010000 != 0 {
+		c.d = value
+	}
+
+	if instruction&0b001000 != 0 && address < len(c.ram) {
+		c.ram[address] = value
+	}
+
+	jump := instruction & 0b111
+	taken := jump&0b100 != 0 && value < 0 ||
+		jump&0b010 != 0 && value == 0 ||
+		jump&0b001 != 0 && value > 0
+
+	if !taken {
+		c.pc++
+		return
+	}
+
+	target := int(uint16(c.a))
+
+	if target >= len(c.rom) {
+		c.fault = fmt.Sprintf("jump to ROM %d, past the end of the program", target)
+		c.halted = true
+	}
+
+	// `(L) @L 0;JMP` is how Hack programs stop
+	if target == c.pc-1 && c.rom[target] == uint16(target) {
+		c.halted = true
+	}
+
+	c.pc = target
+}
+
+// Runs until the program halts
+func (c *hackCPU) run() {
+	for !c.halted {
+		c.step()
+	}
+}
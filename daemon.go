@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// What a long-running translator remembers between requests: each file's
+// commands until it changes, and each program's asm until any of its files do
+type translationCache struct {
+	files    map[string]cachedFile
+	programs map[string]cachedProgram
+}
+
+type cachedFile struct {
+	modTime  time.Time
+	size     int64
+	commands []Command
+}
+
+type cachedProgram struct {
+	// The files translating it read, as -MD lists them
+	files        []string
+	fingerprint  string
+	instructions []string
+}
+
+// The daemon's cache, nil when every translation starts from scratch. Only
+// used under the translation lock
+var cache *translationCache
+
+func newTranslationCache() *translationCache {
+	return &translationCache{files: map[string]cachedFile{}, programs: map[string]cachedProgram{}}
+}
+
+// The file's commands if it hasn't changed since it was parsed. They're
+// copied, so passes can't change what's cached
+func (c *translationCache) parsed(fileName string) ([]Command, bool) {
+	entry, ok := c.files[fileName]
+	if !ok {
+		return nil, false
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil || !info.ModTime().Equal(entry.modTime) || info.Size() != entry.size {
+		return nil, false
+	}
+
+	return copyCommands(entry.commands), true
+}
+
+func copyCommands(commands []Command) []Command {
+	copied := make([]Command, len(commands))
+	for i, command := range commands {
+		command.Args = append([]string{}, command.Args...)
+		copied[i] = command
+	}
+
+	return copied
+}
+
+func (c *translationCache) storeParsed(fileName string, commands []Command) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return
+	}
+
+	c.files[fileName] = cachedFile{modTime: info.ModTime(), size: info.Size(), commands: copyCommands(commands)}
+}
+
+// Identifies the state of every file a program was translated from, and of
+// its folder, so a file added to it is noticed too
+func programFingerprint(path string, files []string) (string, error) {
+	listed, err := vmFiles(path)
+	if err != nil {
+		return "", err
+	}
+
+	var fingerprint strings.Builder
+	fmt.Fprintf(&fingerprint, "%s\n", strings.Join(listed, "\n"))
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&fingerprint, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+	}
+
+	return fingerprint.String(), nil
+}
+
+// The program's asm if none of its files have changed since it was translated
+func (c *translationCache) translated(path string) ([]string, bool) {
+	entry, ok := c.programs[path]
+	if !ok {
+		return nil, false
+	}
+
+	fingerprint, err := programFingerprint(path, entry.files)
+	if err != nil {
+		return nil, false
+	}
+
+	return entry.instructions, fingerprint == entry.fingerprint
+}
+
+// Remembers the asm along with the files it was translated from, unless one
+// was edited since the translation started, so that edit is picked up next
+// time
+func (c *translationCache) storeTranslated(path string, files []string, started time.Time, instructions []string) {
+	for _, file := range files {
+		if info, err := os.Stat(file); err != nil || info.ModTime().After(started) {
+			return
+		}
+	}
+
+	fingerprint, err := programFingerprint(path, files)
+	if err != nil {
+		return
+	}
+
+	c.programs[path] = cachedProgram{files: files, fingerprint: fingerprint, instructions: instructions}
+}
+
+// Serves the NDJSON protocol on a Unix socket, one conversation per
+// connection, keeping what it parses and translates in memory so that
+// translating again after an edit only reparses the files that changed
+func daemonCommand(args []string) {
+	socket := flag.String("socket", "vmtranslator.sock", "Unix socket to listen on")
+	metricsAddress := flag.String("metrics", "", "address to serve Prometheus /metrics and /debug/pprof on (off by default)")
+	parseFlags(args)
+
+	cache = newTranslationCache()
+
+	// A socket left behind by a daemon that didn't shut down cleanly
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		log.Fatalf("a daemon is already listening on %s", *socket)
+	}
+	os.Remove(*socket)
+
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *metricsAddress != "" {
+		serveMetrics(*metricsAddress)
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	go func() {
+		<-interrupts
+		listener.Close()
+		os.Exit(0)
+	}()
+
+	log.Printf("listening on %s", *socket)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			defer conn.Close()
+
+			if err := serveNDJSON(conn, conn); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+}
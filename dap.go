@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A Debug Adapter Protocol request from the editor
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Source *dapSource `json:"source,omitempty"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	EvaluateName       string `json:"evaluateName,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// The CPU is the only thread there is
+const dapThread = 1
+
+// One editor's debugging session, over the debugger
+type dapSession struct {
+	out       io.Writer
+	writeLock sync.Mutex
+	seq       int
+
+	d           *debugger
+	stopOnEntry bool
+	// The full path of each .vm file by the name the source map gives it
+	sourcePaths map[string]string
+	// The variables each scope shows, by reference, until the program runs on
+	variables [][]dapVariable
+
+	running atomic.Bool
+	paused  atomic.Bool
+	runs    sync.WaitGroup
+}
+
+// Reads a message framed with a Content-Length header, as the debug adapter
+// and language server protocols send them
+func readFramedMessage(reader *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxRequestSize {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+
+	return message, err
+}
+
+func (s *dapSession) send(message interface{}) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.seq++
+	switch m := message.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *dapSession) respond(request dapRequest, body interface{}, err error) {
+	response := &dapResponse{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: err == nil, Body: body}
+	if err != nil {
+		response.Message = err.Error()
+	}
+
+	s.send(response)
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// What the debugger prints goes to the editor's debug console
+func (s *dapSession) Write(p []byte) (int, error) {
+	s.event("output", map[string]string{"category": "console", "output": string(p)})
+	return len(p), nil
+}
+
+// Translates the program to debug, as the launch configuration names it
+func (s *dapSession) launch(arguments json.RawMessage) error {
+	var launch struct {
+		Program     string   `json:"program"`
+		StopOnEntry bool     `json:"stopOnEntry"`
+		Args        []string `json:"args"`
+	}
+
+	if err := json.Unmarshal(arguments, &launch); err != nil {
+		return err
+	}
+
+	if launch.Program != "" {
+		pathToTranslate = launch.Program
+	}
+
+	resetTranslation()
+	shouldKeepCommands = true
+
+	instructions, err := loadFolder(pathToTranslate)
+	if err != nil {
+		return err
+	}
+
+	s.d, err = newDebugger(instructions, s)
+	if err != nil {
+		return err
+	}
+
+	if _, err := runArguments(launch.Args, s.d.cpu.ram); err != nil {
+		return err
+	}
+
+	s.stopOnEntry = launch.StopOnEntry
+	s.sourcePaths = map[string]string{}
+
+	for _, file := range sourceFiles {
+		if absolute, err := filepath.Abs(file); err == nil {
+			file = absolute
+		}
+
+		s.sourcePaths[filepath.Base(file)] = file
+	}
+
+	return nil
+}
+
+// Replaces the breakpoints in a file, or the function breakpoints when file
+// is empty, with those on targets. Each is verified if there's code for it
+func (s *dapSession) setBreakpoints(file string, targets []string) []map[string]interface{} {
+	for address, target := range s.d.breakpoints {
+		if inFile, _, ok := strings.Cut(target, ":"); (ok && inFile == file) || (!ok && file == "") {
+			delete(s.d.breakpoints, address)
+		}
+	}
+
+	breakpoints := []map[string]interface{}{}
+
+	for _, target := range targets {
+		address, err := s.d.resolveBreakpoint(target)
+		if err != nil {
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "message": err.Error()})
+			continue
+		}
+
+		s.d.breakpoints[address] = target
+
+		breakpoint := map[string]interface{}{"verified": true}
+		if owner := s.d.program.owner(address); owner >= 0 {
+			breakpoint["line"] = s.d.program.mappings[owner].Line
+		}
+
+		breakpoints = append(breakpoints, breakpoint)
+	}
+
+	return breakpoints
+}
+
+// Runs the program on its own until stop says to, or the editor pauses it,
+// then tells the editor why it stopped
+func (s *dapSession) resume(stop func() bool) {
+	s.variables = nil
+	s.paused.Store(false)
+	s.running.Store(true)
+	s.runs.Add(1)
+
+	go func() {
+		defer s.runs.Done()
+
+		reason := s.d.runUntil(func() bool { return s.paused.Load() || stop() })
+		if reason == "step" && s.paused.Load() {
+			reason = "pause"
+		}
+
+		s.running.Store(false)
+
+		if reason == "halted" {
+			s.event("exited", map[string]int{"exitCode": 0})
+			s.event("terminated", nil)
+			return
+		}
+
+		s.event("stopped", map[string]interface{}{"reason": reason, "threadId": dapThread, "allThreadsStopped": true})
+	}()
+}
+
+// Steps to the next command in the function, or the one it returns to. The
+// frames of functions called on the way have their locals higher up in RAM
+func (s *dapSession) stepOver(out bool) func() bool {
+	start := int(s.d.cpu.ram[1])
+
+	return func() bool {
+		lcl := int(s.d.cpu.ram[1])
+		return s.d.atCommandStart() && (lcl < start || (!out && lcl == start))
+	}
+}
+
+// The number of locals a function declares
+func (s *dapSession) localCount(function string) int {
+	for _, mapping := range s.d.program.mappings {
+		if fields := strings.Fields(mapping.Command); len(fields) == 3 && fields[0] == "function" && fields[1] == function {
+			n, _ := strconv.Atoi(fields[2])
+			return n
+		}
+	}
+
+	return 0
+}
+
+func (s *dapSession) ramVariable(name string, address int) dapVariable {
+	value := "?"
+	if address >= 0 && address < len(s.d.cpu.ram) {
+		value = strconv.Itoa(int(s.d.cpu.ram[address]))
+	}
+
+	return dapVariable{Name: name, Value: value, EvaluateName: fmt.Sprintf("RAM[%d]", address)}
+}
+
+// Hands out a reference to variables for the editor to ask for
+func (s *dapSession) reference(variables []dapVariable) int {
+	s.variables = append(s.variables, variables)
+	return len(s.variables)
+}
+
+// The scopes of a frame: its locals, arguments and working stack, its file's
+// statics, and the temp segment and registers every frame shares
+func (s *dapSession) scopes(frames []stackFrame, id int) []map[string]interface{} {
+	frame := frames[id-1]
+	ram := s.d.cpu.ram
+
+	var locals, arguments, stack, statics, temp, registers []dapVariable
+
+	if frame.function != "" {
+		nLocals := s.localCount(frame.function)
+		for i := 0; i < nLocals; i++ {
+			locals = append(locals, s.ramVariable(fmt.Sprintf("local %d", i), frame.lcl+i))
+		}
+
+		for i := range frame.args {
+			arguments = append(arguments, s.ramVariable(fmt.Sprintf("argument %d", i), frame.arg+i))
+		}
+
+		// The working stack ends at SP, or where the arguments for the call
+		// the frame is making begin
+		top := int(ram[0])
+		if id > 1 {
+			top = frames[id-2].arg
+		}
+
+		for address := top - 1; address >= frame.lcl+nLocals; address-- {
+			stack = append(stack, s.ramVariable(fmt.Sprintf("RAM[%d]", address), address))
+		}
+	}
+
+	if frame.owner >= 0 {
+		file := s.d.program.mappings[frame.owner].File
+		for _, symbol := range s.d.symbols {
+			if !symbol.Label && strings.HasPrefix(symbol.Name, file+".") {
+				statics = append(statics, s.ramVariable("static "+strings.TrimPrefix(symbol.Name, file+"."), symbol.Address))
+			}
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		temp = append(temp, s.ramVariable(fmt.Sprintf("temp %d", i), memory.Temp+i))
+	}
+
+	for i, name := range []string{"SP", "LCL", "ARG", "THIS", "THAT"} {
+		registers = append(registers, s.ramVariable(name, i))
+	}
+
+	registers = append(registers,
+		dapVariable{Name: "A", Value: strconv.Itoa(int(s.d.cpu.a))},
+		dapVariable{Name: "D", Value: strconv.Itoa(int(s.d.cpu.d))},
+		dapVariable{Name: "PC", Value: strconv.Itoa(s.d.cpu.pc)},
+	)
+
+	var scopes []map[string]interface{}
+	for _, scope := range []struct {
+		name      string
+		variables []dapVariable
+		expensive bool
+	}{
+		{"Locals", locals, false},
+		{"Arguments", arguments, false},
+		{"Stack", stack, false},
+		{"Statics", statics, false},
+		{"Temp", temp, true},
+		{"Registers", registers, true},
+	} {
+		if len(scope.variables) == 0 {
+			continue
+		}
+
+		scopes = append(scopes, map[string]interface{}{
+			"name":               scope.name,
+			"variablesReference": s.reference(scope.variables),
+			"expensive":          scope.expensive,
+		})
+	}
+
+	return scopes
+}
+
+func (s *dapSession) stackTrace() []dapStackFrame {
+	frames, _ := s.d.program.frames(s.d.cpu, s.d.watch.commandPC)
+
+	var stackFrames []dapStackFrame
+	for i, frame := range frames {
+		stackFrame := dapStackFrame{ID: i + 1, Name: frame.function}
+
+		if frame.owner < 0 {
+			stackFrame.Name = fmt.Sprintf("ROM %d (bootstrap or shared routine)", frame.pc)
+		} else {
+			mapping := s.d.program.mappings[frame.owner]
+			stackFrame.Source = &dapSource{Name: mapping.File, Path: s.sourcePaths[mapping.File]}
+			stackFrame.Line = mapping.Line
+			stackFrame.Column = 1
+
+			if stackFrame.Name == "" {
+				stackFrame.Name = mapping.Command
+			}
+
+			stackFrame.Name += mapping.origin()
+		}
+
+		stackFrames = append(stackFrames, stackFrame)
+	}
+
+	return stackFrames
+}
+
+// Answers a request, returning false once the session is over
+func (s *dapSession) handle(request dapRequest) bool {
+	// Only pausing, or leaving, makes sense while the program runs
+	if s.running.Load() && request.Command != "pause" && request.Command != "disconnect" && request.Command != "terminate" {
+		s.respond(request, nil, fmt.Errorf("the program is running"))
+		return true
+	}
+
+	if s.d == nil && request.Command != "initialize" && request.Command != "launch" && request.Command != "disconnect" {
+		s.respond(request, nil, fmt.Errorf("no program launched"))
+		return true
+	}
+
+	var arguments struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line   int    `json:"line"`
+			Name   string `json:"name"`
+			DataID string `json:"dataId"`
+		} `json:"breakpoints"`
+		FrameID            int    `json:"frameId"`
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Expression         string `json:"expression"`
+		Granularity        string `json:"granularity"`
+	}
+
+	if len(request.Arguments) > 0 {
+		if err := json.Unmarshal(request.Arguments, &arguments); err != nil {
+			s.respond(request, nil, err)
+			return true
+		}
+	}
+
+	switch request.Command {
+	case "initialize":
+		s.respond(request, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsDataBreakpoints":          true,
+			"supportsSteppingGranularity":      true,
+			"supportsTerminateRequest":         true,
+		}, nil)
+
+	case "launch":
+		err := s.launch(request.Arguments)
+		s.respond(request, nil, err)
+
+		// Breakpoints can only be placed once there's code to place them in
+		if err == nil {
+			s.event("initialized", nil)
+		}
+
+	case "setBreakpoints":
+		file := filepath.Base(arguments.Source.Path)
+
+		var targets []string
+		for _, breakpoint := range arguments.Breakpoints {
+			targets = append(targets, fmt.Sprintf("%s:%d", file, breakpoint.Line))
+		}
+
+		s.respond(request, map[string]interface{}{"breakpoints": s.setBreakpoints(file, targets)}, nil)
+
+	case "setFunctionBreakpoints":
+		var targets []string
+		for _, breakpoint := range arguments.Breakpoints {
+			targets = append(targets, breakpoint.Name)
+		}
+
+		s.respond(request, map[string]interface{}{"breakpoints": s.setBreakpoints("", targets)}, nil)
+
+	case "setExceptionBreakpoints":
+		// Faults always stop the program
+		s.respond(request, nil, nil)
+
+	case "dataBreakpointInfo":
+		body := map[string]interface{}{"dataId": nil, "description": "not in RAM"}
+
+		if arguments.VariablesReference > 0 && arguments.VariablesReference <= len(s.variables) {
+			for _, variable := range s.variables[arguments.VariablesReference-1] {
+				if variable.Name == arguments.Name && variable.EvaluateName != "" {
+					body = map[string]interface{}{"dataId": variable.EvaluateName, "description": variable.Name + " (" + variable.EvaluateName + ")", "accessTypes": []string{"write"}}
+				}
+			}
+		}
+
+		s.respond(request, body, nil)
+
+	case "setDataBreakpoints":
+		s.d.watches.remove("")
+
+		breakpoints := []map[string]interface{}{}
+		for _, breakpoint := range arguments.Breakpoints {
+			address, err := resolveWatch(breakpoint.DataID, s.d.symbols)
+			if err != nil {
+				breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "message": err.Error()})
+				continue
+			}
+
+			s.d.watches.add(address, breakpoint.DataID, s.d.cpu.ram)
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": true})
+		}
+
+		s.respond(request, map[string]interface{}{"breakpoints": breakpoints}, nil)
+
+	case "configurationDone":
+		s.respond(request, nil, nil)
+
+		if s.stopOnEntry {
+			s.event("stopped", map[string]interface{}{"reason": "entry", "threadId": dapThread, "allThreadsStopped": true})
+		} else {
+			s.resume(func() bool { return false })
+		}
+
+	case "threads":
+		s.respond(request, map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThread, "name": "Hack CPU"}}}, nil)
+
+	case "stackTrace":
+		stackFrames := s.stackTrace()
+		s.respond(request, map[string]interface{}{"stackFrames": stackFrames, "totalFrames": len(stackFrames)}, nil)
+
+	case "scopes":
+		frames, _ := s.d.program.frames(s.d.cpu, s.d.watch.commandPC)
+		if arguments.FrameID < 1 || arguments.FrameID > len(frames) {
+			s.respond(request, nil, fmt.Errorf("no frame %d", arguments.FrameID))
+			break
+		}
+
+		s.respond(request, map[string]interface{}{"scopes": s.scopes(frames, arguments.FrameID)}, nil)
+
+	case "variables":
+		if arguments.VariablesReference < 1 || arguments.VariablesReference > len(s.variables) {
+			s.respond(request, nil, fmt.Errorf("no variables %d", arguments.VariablesReference))
+			break
+		}
+
+		s.respond(request, map[string]interface{}{"variables": s.variables[arguments.VariablesReference-1]}, nil)
+
+	case "evaluate":
+		address, err := resolveWatch(strings.TrimSpace(arguments.Expression), s.d.symbols)
+		if err != nil {
+			s.respond(request, nil, err)
+			break
+		}
+
+		s.respond(request, map[string]interface{}{"result": strconv.Itoa(int(s.d.cpu.ram[address])), "variablesReference": 0}, nil)
+
+	case "continue":
+		s.respond(request, map[string]bool{"allThreadsContinued": true}, nil)
+		s.resume(func() bool { return false })
+
+	case "next", "stepIn", "stepOut":
+		s.respond(request, nil, nil)
+
+		switch {
+		case arguments.Granularity == "instruction":
+			s.resume(func() bool { return true })
+		case request.Command == "stepIn":
+			s.resume(s.d.atCommandStart)
+		default:
+			s.resume(s.stepOver(request.Command == "stepOut"))
+		}
+
+	case "pause":
+		s.paused.Store(true)
+		s.respond(request, nil, nil)
+
+	case "disconnect", "terminate":
+		s.paused.Store(true)
+		s.runs.Wait()
+		s.respond(request, nil, nil)
+
+		if request.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+
+		return false
+
+	default:
+		s.respond(request, nil, fmt.Errorf("unsupported request: %s", request.Command))
+	}
+
+	return true
+}
+
+// Speaks the Debug Adapter Protocol with an editor until it disconnects
+func serveDAP(in io.Reader, out io.Writer) error {
+	session := &dapSession{out: out}
+	reader := bufio.NewReader(in)
+
+	for {
+		message, err := readFramedMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var request dapRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+
+		if !session.handle(request) {
+			return nil
+		}
+	}
+}
+
+// A debug adapter for editors that speak the Debug Adapter Protocol, over
+// stdin and stdout or, with -listen, on a TCP address for one session at a
+// time. The launch configuration names the program, e.g.
+// {"program": "FibonacciElement", "stopOnEntry": true}, and can give RAM
+// arguments as run takes them
+func dapCommand(args []string) {
+	listen := flag.String("listen", "", "TCP address to serve sessions on, instead of stdin and stdout")
+	parseFlags(args)
+
+	if *listen == "" {
+		if err := serveDAP(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("listening on %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := serveDAP(conn, conn); err != nil {
+			log.Print(err)
+		}
+
+		conn.Close()
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Steps through the machine code with the VM commands it came from to hand
+type debugger struct {
+	cpu     *hackCPU
+	program *programMap
+	watch   *faultWatch
+	// The instruction at each ROM address, as written
+	code []string
+	// ROM addresses to stop at, with what the user asked to break on
+	breakpoints map[int]string
+	watches     *watchpoints
+	symbols     []asmSymbol
+	out         io.Writer
+}
+
+func newDebugger(instructions []string, out io.Writer) (*debugger, error) {
+	lines := asmLines(instructions)
+
+	rom, err := assemble(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &debugger{
+		cpu:         newHackCPU(rom),
+		program:     newProgramMap(instructions, len(rom)),
+		breakpoints: map[int]string{},
+		watches:     newWatchpoints(),
+		symbols:     resolveSymbols(lines),
+		out:         out,
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+		if isInstruction(line) {
+			d.code = append(d.code, line)
+		}
+	}
+
+	d.watch = newFaultWatch(d.program)
+
+	return d, nil
+}
+
+// The ROM address a breakpoint refers to: the start of a function, e.g.
+// Foo.bar, or of the command on a line, e.g. Foo.vm:17
+func (d *debugger) resolveBreakpoint(target string) (int, error) {
+	if file, line, ok := strings.Cut(target, ":"); ok {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return 0, fmt.Errorf("invalid line: %s", line)
+		}
+
+		for _, mapping := range d.program.mappings {
+			if mapping.File == file && mapping.Line >= n {
+				return mapping.Start, nil
+			}
+		}
+
+		return 0, fmt.Errorf("no code at or after %s", target)
+	}
+
+	for _, mapping := range d.program.mappings {
+		if strings.HasPrefix(mapping.Command, "function "+target+" ") {
+			return mapping.Start, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no function %s", target)
+}
+
+// Where the CPU is, in VM terms where it can be
+func (d *debugger) location() string {
+	pc := d.cpu.pc
+	if pc < 0 || pc >= len(d.code) {
+		return fmt.Sprintf("ROM %d: off the end of the program", pc)
+	}
+
+	asm := fmt.Sprintf("ROM %d: %s", pc, d.code[pc])
+
+	if owner := d.program.owner(pc); owner >= 0 {
+		mapping := d.program.mappings[owner]
+		return fmt.Sprintf("%s:%d %s%s (%s)", mapping.File, mapping.Line, mapping.Command, mapping.origin(), asm)
+	}
+
+	return asm + " (bootstrap or shared routine)"
+}
+
+// Runs instructions until stop says to, the program halts, it reaches a
+// breakpoint or a watched address changes, saying which: step, halted,
+// breakpoint, data breakpoint or, for a fault, exception. At least one
+// instruction runs
+func (d *debugger) runUntil(stop func() bool) string {
+	for {
+		// Writes in routines belong to the command that called them
+		writer := d.cpu.pc
+		if d.program.owner(writer) < 0 {
+			writer = d.watch.commandPC
+		}
+
+		d.cpu.step()
+
+		if fault := d.watch.check(d.cpu); fault != "" {
+			fmt.Fprintln(d.out, fault)
+			d.backtrace()
+			return "exception"
+		}
+
+		if d.cpu.halted {
+			fmt.Fprintln(d.out, "halted")
+			return "halted"
+		}
+
+		if changes := d.watches.changes(d.cpu.ram); len(changes) > 0 {
+			for _, change := range changes {
+				fmt.Fprintf(d.out, "watchpoint %s, written by %s\n", change, d.program.describe(writer))
+			}
+
+			return "data breakpoint"
+		}
+
+		if target, ok := d.breakpoints[d.cpu.pc]; ok {
+			fmt.Fprintf(d.out, "breakpoint at %s\n", target)
+			return "breakpoint"
+		}
+
+		if d.program.breakpoints[d.cpu.pc] {
+			fmt.Fprintf(d.out, "breakpoint command at %s\n", d.program.location(d.cpu.pc))
+			return "breakpoint"
+		}
+
+		if stop() {
+			return "step"
+		}
+	}
+}
+
+// Stops at the start of the next VM command
+func (d *debugger) atCommandStart() bool {
+	_, ok := d.program.commandAt(d.cpu.pc)
+	return ok
+}
+
+func (d *debugger) backtrace() {
+	for _, frame := range d.program.stackTrace(d.cpu, d.watch.commandPC) {
+		fmt.Fprintln(d.out, frame)
+	}
+}
+
+func (d *debugger) registers() {
+	ram := d.cpu.ram
+	fmt.Fprintf(d.out, "SP=%d LCL=%d ARG=%d THIS=%d THAT=%d\n", ram[0], ram[1], ram[2], ram[3], ram[4])
+	fmt.Fprintf(d.out, "A=%d D=%d PC=%d cycles=%d\n", d.cpu.a, d.cpu.d, d.cpu.pc, d.cpu.cycles)
+}
+
+// The stack of the current frame, from LCL (or the stack base) up to SP
+func (d *debugger) stack() {
+	ram := d.cpu.ram
+
+	bottom := int(ram[1])
+	if bottom < memory.Stack || bottom > int(ram[0]) {
+		bottom = memory.Stack
+	}
+
+	if int(ram[0]) <= bottom {
+		fmt.Fprintln(d.out, "(empty)")
+		return
+	}
+
+	for address := int(ram[0]) - 1; address >= bottom && address < len(ram); address-- {
+		fmt.Fprintf(d.out, "RAM[%d] = %d\n", address, ram[address])
+	}
+}
+
+const debuggerHelp = `break TARGET (b)    stop at a function (Foo.bar) or line (Foo.vm:17)
+delete [TARGET]     remove a breakpoint, or all of them
+watch TARGET        stop when an address (RAM[16]) or variable (Foo.vm.3) changes
+unwatch [TARGET]    remove a watchpoint, or all of them
+breakpoints         list the breakpoints and watchpoints
+step (s)            run to the next VM command
+stepi (si)          run one machine instruction
+continue (c)        run to the next breakpoint or until the program halts
+where (w)           show the current command and instruction
+regs (r)            show SP, LCL, ARG, THIS, THAT, A, D and PC
+stack               show the current frame's stack, top first
+backtrace (bt)      show the VM call stack
+print ADDRESS (p)   show RAM[ADDRESS]
+quit (q)            leave the debugger`
+
+// Runs a debugger command, returning false once the user quits
+func (d *debugger) command(line string) bool {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return true
+	}
+
+	arg := ""
+	if len(words) > 1 {
+		arg = words[1]
+	}
+
+	switch words[0] {
+	case "break", "b":
+		address, err := d.resolveBreakpoint(arg)
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			break
+		}
+
+		d.breakpoints[address] = arg
+		fmt.Fprintf(d.out, "breakpoint at %s (ROM %d)\n", arg, address)
+
+	case "delete":
+		for address, target := range d.breakpoints {
+			if arg == "" || target == arg {
+				delete(d.breakpoints, address)
+			}
+		}
+
+	case "breakpoints":
+		var addresses []int
+		for address := range d.breakpoints {
+			addresses = append(addresses, address)
+		}
+		sort.Ints(addresses)
+
+		for _, address := range addresses {
+			fmt.Fprintf(d.out, "%s (ROM %d)\n", d.breakpoints[address], address)
+		}
+
+		for _, address := range d.watches.addresses() {
+			fmt.Fprintf(d.out, "watch %s (RAM[%d])\n", d.watches.names[address], address)
+		}
+
+	case "watch":
+		address, err := resolveWatch(arg, d.symbols)
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			break
+		}
+
+		d.watches.add(address, arg, d.cpu.ram)
+		fmt.Fprintf(d.out, "watchpoint on %s (RAM[%d]) = %d\n", arg, address, d.cpu.ram[address])
+
+	case "unwatch":
+		d.watches.remove(arg)
+
+	case "step", "s":
+		d.runUntil(d.atCommandStart)
+		fmt.Fprintln(d.out, d.location())
+
+	case "stepi", "si":
+		d.runUntil(func() bool { return true })
+		fmt.Fprintln(d.out, d.location())
+
+	case "continue", "c":
+		d.runUntil(func() bool { return false })
+		fmt.Fprintln(d.out, d.location())
+
+	case "where", "w":
+		fmt.Fprintln(d.out, d.location())
+
+	case "regs", "r":
+		d.registers()
+
+	case "stack":
+		d.stack()
+
+	case "backtrace", "bt":
+		d.backtrace()
+
+	case "print", "p":
+		address, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(arg, "RAM["), "]"))
+		if err != nil || address < 0 || address >= len(d.cpu.ram) {
+			fmt.Fprintf(d.out, "invalid address: %s\n", arg)
+			break
+		}
+
+		fmt.Fprintf(d.out, "RAM[%d] = %d\n", address, d.cpu.ram[address])
+
+	case "quit", "q":
+		return false
+
+	case "help", "h":
+		fmt.Fprintln(d.out, debuggerHelp)
+
+	default:
+		fmt.Fprintf(d.out, "unknown command: %s (try help)\n", words[0])
+	}
+
+	return true
+}
+
+// Translates the program and debugs the machine code interactively, with
+// breakpoints and stepping in terms of the VM commands
+func debugCommand(args []string) {
+	parseFlags(args)
+
+	shouldKeepCommands = true
+	translation.translatedCommands = nil
+
+	instructions, err := loadFolder(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d, err := newDebugger(instructions, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := runArguments(flag.Args(), d.cpu.ram); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(d.location())
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("(debug) ")
+
+		if !scanner.Scan() || !d.command(scanner.Text()) {
+			return
+		}
+	}
+}
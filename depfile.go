@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// The files read since the last reset, in the order they were first read:
+// the program's .vm files, any included from the OS folder and, when
+// linking, the .asmobj files and libraries
+var sourceFiles []string
+var sourceFilesRead = map[string]bool{}
+
+func recordSourceFile(fileName string) {
+	if sourceFilesRead[fileName] {
+		return
+	}
+
+	sourceFilesRead[fileName] = true
+	sourceFiles = append(sourceFiles, fileName)
+}
+
+// A Makefile rule saying the target depends on the sources, as gcc -MD writes
+// them, which ninja reads too
+func dependencyRule(target string, sources []string) string {
+	escape := func(name string) string {
+		return strings.NewReplacer(" ", "\\ ", "#", "\\#", "$", "$$").Replace(name)
+	}
+
+	var rule strings.Builder
+	rule.WriteString(escape(target) + ":")
+
+	for _, source := range sources {
+		rule.WriteString(" \\\n  " + escape(source))
+	}
+
+	rule.WriteString("\n")
+
+	return rule.String()
+}
+
+// Writes a .d file beside the asm listing every file it was translated from
+func saveDependencies(asmPath string) error {
+	depPath := strings.TrimSuffix(asmPath, ".asm") + ".d"
+
+	return os.WriteFile(depPath, []byte(dependencyRule(asmPath, sourceFiles)), 0644)
+}
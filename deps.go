@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+type functionDependencies struct {
+	calls    map[string]bool
+	calledBy map[string]bool
+}
+
+// Builds the call graph, keyed by function. Functions that are called but
+// never defined get an entry too, with no calls of their own
+func dependencyGraph(commands []Command) map[string]*functionDependencies {
+	graph := map[string]*functionDependencies{}
+
+	entry := func(name string) *functionDependencies {
+		if graph[name] == nil {
+			graph[name] = &functionDependencies{calls: map[string]bool{}, calledBy: map[string]bool{}}
+		}
+
+		return graph[name]
+	}
+
+	for name, function := range collectFunctions(commands) {
+		entry(name)
+
+		for _, command := range function.body {
+			if command.Kind == "call" && len(command.Args) == 2 {
+				entry(name).calls[command.Args[0]] = true
+				entry(command.Args[0]).calledBy[name] = true
+			}
+		}
+	}
+
+	return graph
+}
+
+func describeSet(set map[string]bool) string {
+	if len(set) == 0 {
+		return "none"
+	}
+
+	return strings.Join(sortedKeys(set), ", ")
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Lists each function's callers and callees, then the functions that are
+// called but not defined along with the file that would define them
+func dependencyReport(commands []Command) string {
+	graph := dependencyGraph(commands)
+	defined := collectFunctions(commands)
+
+	var names []string
+	for name := range graph {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var lines []string
+	var undefined []string
+
+	for _, name := range names {
+		if _, ok := defined[name]; !ok {
+			undefined = append(undefined, name)
+			continue
+		}
+
+		lines = append(lines,
+			name,
+			"  calls: "+describeSet(graph[name].calls),
+			"  called by: "+describeSet(graph[name].calledBy),
+		)
+	}
+
+	if len(undefined) > 0 {
+		lines = append(lines, "", "undefined:")
+
+		for _, name := range undefined {
+			// By convention Foo.bar lives in Foo.vm
+			file := strings.Split(name, ".")[0] + ".vm"
+			lines = append(lines, fmt.Sprintf("  %s (%s), called by: %s", name, file, describeSet(graph[name].calledBy)))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func dependenciesCommand(args []string) {
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(dependencyReport(commands))
+}
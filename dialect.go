@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The VM language the source is written in: "standard", as the course
+// defines it, or "extended", which adds to it
+var dialect string
+
+// The commands the extended dialect adds besides its operations
+var extendedCommands = map[string]bool{
+	"include":    true,
+	"const":      true,
+	"alias":      true,
+	"breakpoint": true,
+}
+
+// The commands of the course's VM language
+var standardCommands = map[string]bool{
+	"push": true, "pop": true, "function": true, "call": true, "return": true,
+	"label": true, "goto": true, "if-goto": true,
+	"add": true, "sub": true, "neg": true, "eq": true, "gt": true, "lt": true,
+	"and": true, "or": true, "not": true,
+}
+
+// Holds a command to the course's VM language when the source is in the
+// standard dialect: a command it has, and a push or pop of a segment it has
+// with an index in range
+func (p *Parser) checkStandard(fields []string, lineNumber int) error {
+	if dialect == "extended" {
+		return nil
+	}
+
+	kind := fields[0]
+	if extendedOperations[kind] || extendedCommands[kind] {
+		return p.needsExtended(kind, lineNumber)
+	}
+
+	if !standardCommands[kind] {
+		return fmt.Errorf("%s:%d: invalid command: %s", p.file, lineNumber, strings.Join(fields, " "))
+	}
+
+	if kind != "push" && kind != "pop" {
+		return nil
+	}
+
+	if fields[1] == "global" {
+		return p.needsExtended("the global segment", lineNumber)
+	}
+
+	index, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fmt.Errorf("%s:%d: invalid index: %s", p.file, lineNumber, strings.Join(fields, " "))
+	}
+
+	if err := accessError(kind, fields[1], index); err != nil {
+		return fmt.Errorf("%s:%d: %v", p.file, lineNumber, err)
+	}
+
+	return nil
+}
+
+// Fails on what the course's VM language doesn't have, unless the source is
+// in the extended dialect, so a file meant for other translators can be held
+// to the standard one
+func (p *Parser) needsExtended(what string, lineNumber int) error {
+	if dialect == "extended" {
+		return nil
+	}
+
+	return fmt.Errorf("%s:%d: %s needs -dialect=extended", p.file, lineNumber, what)
+}
+
+// The address of a global, the extended dialect's segment of fixed addresses
+// from memory.Global up, for the few well-known variables files share
+func globalAddress(index int) (int, error) {
+	if dialect != "extended" {
+		return 0, fmt.Errorf("the global segment needs -dialect=extended")
+	}
+
+	if memory.Global < 0 {
+		return 0, fmt.Errorf("the global segment needs a -global-base")
+	}
+
+	if index < 0 || memory.Global+index > 32767 {
+		return 0, fmt.Errorf("global %d is outside the RAM", index)
+	}
+
+	return memory.Global + index, nil
+}
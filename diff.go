@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type diffEdit struct {
+	// ' ' for a line in both, '-' for one only in the old, '+' only in the new
+	op   byte
+	line string
+}
+
+// Past this many differing lines the diff is given as a single replacement
+// rather than searched for, which would take quadratic memory
+const maxDiffEdits = 4000
+
+// Myers' diff: the shortest list of edits turning a into b
+func diffLines(a, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return replaceAll(a, b)
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+				return backtrackDiff(a, b, trace)
+			}
+		}
+
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+	}
+
+	return nil
+}
+
+// Walks the saved frontiers back from the end to recover the edits
+func backtrackDiff(a, b []string, trace [][]int) []diffEdit {
+	var edits []diffEdit
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+
+		// trace[d] holds diagonals -d..d, trace[d-1] holds -(d-1)..d-1
+		var prevK int
+		if d > 0 {
+			previous := trace[d-1]
+			at := func(k int) int { return previous[k+d-1] }
+
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+
+			prevX := at(prevK)
+			prevY := prevX - prevK
+
+			for x > prevX && y > prevY {
+				x--
+				y--
+				edits = append(edits, diffEdit{' ', a[x]})
+			}
+
+			if x == prevX {
+				y--
+				edits = append(edits, diffEdit{'+', b[y]})
+			} else {
+				x--
+				edits = append(edits, diffEdit{'-', a[x]})
+			}
+
+			continue
+		}
+
+		for x > 0 && y > 0 {
+			x--
+			y--
+			edits = append(edits, diffEdit{' ', a[x]})
+		}
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+func replaceAll(a, b []string) []diffEdit {
+	var edits []diffEdit
+
+	for _, line := range a {
+		edits = append(edits, diffEdit{'-', line})
+	}
+
+	for _, line := range b {
+		edits = append(edits, diffEdit{'+', line})
+	}
+
+	return edits
+}
+
+// Formats the edits as a unified diff with three lines of context, or returns
+// an empty string when there's no difference
+func unifiedDiff(a, b []string, nameA, nameB string) string {
+	edits := diffLines(a, b)
+	const context = 3
+
+	var out strings.Builder
+
+	for start := 0; start < len(edits); {
+		// Find the next change, then the end of the hunk around it
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+
+		if start == len(edits) {
+			break
+		}
+
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+
+		end, unchanged := start, 0
+		for end < len(edits) && unchanged <= 2*context {
+			if edits[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+
+			end++
+		}
+
+		// Keep at most `context` unchanged lines after the last change
+		end -= unchanged
+		if unchanged > context {
+			end += context
+		} else {
+			end += unchanged
+		}
+
+		// Line numbers of the hunk's first line in each file
+		lineA, lineB := 1, 1
+		for _, edit := range edits[:from] {
+			if edit.op != '+' {
+				lineA++
+			}
+
+			if edit.op != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, edit := range edits[from:end] {
+			if edit.op != '+' {
+				countA++
+			}
+
+			if edit.op != '-' {
+				countB++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+
+		// An empty range starts at the line before
+		if countA == 0 {
+			lineA--
+		}
+
+		if countB == 0 {
+			lineB--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+
+		for _, edit := range edits[from:end] {
+			out.WriteString(string(edit.op) + edit.line + "\n")
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+// Compares the output with what's already in the file, printing any
+// differences. Returns whether there were any
+func diffOutput(instructions []string, fileName string) (bool, error) {
+	existing, err := os.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	diff := unifiedDiff(splitLines(string(existing)), splitLines(strings.Join(instructions, "")), fileName, fileName+" (new)")
+	fmt.Print(diff)
+
+	return diff != "", nil
+}
+
+// The lines of a file's contents, without the newline ending the last. Lines
+// ending in CRLF are the same as those ending in LF
+func splitLines(contents string) []string {
+	if contents == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(contents, "\r\n", "\n"), "\n"), "\n")
+}
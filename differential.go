@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runs a script, with the reference asm in place of any it loads if given,
+// returning its output and the columns of each line
+func scriptOutput(script string, reference string) ([]string, [][]string, error) {
+	source, err := os.ReadFile(script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statements, err := parseTestScript(string(source))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", script, err)
+	}
+
+	r := &testRunner{dir: filepath.Dir(script), reference: reference, ignoreCompare: true}
+	if err := r.exec(statements); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", script, err)
+	}
+
+	return r.lines, r.columns, nil
+}
+
+// Compares the output of a script run against this translator's asm with
+// the same script run against the reference
+func compareScriptRuns(script string, reference string) (string, error) {
+	ours, columns, err := scriptOutput(script, "")
+	if err != nil {
+		return "", err
+	}
+
+	theirs, _, err := scriptOutput(script, reference)
+	if err != nil {
+		return "", fmt.Errorf("with %s: %w", reference, err)
+	}
+
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if ours[i] != theirs[i] {
+			return fmt.Sprintf("output line %d: %s", i+1, describeMismatch(columns[i], ours[i], theirs[i])), nil
+		}
+	}
+
+	if len(ours) != len(theirs) {
+		return fmt.Sprintf("%d output lines, the reference has %d", len(ours), len(theirs)), nil
+	}
+
+	return "", nil
+}
+
+// Assembles the lines and runs them from the RAM the arguments set until the
+// program halts or the cycles run out
+func runAsm(lines []string, args []string, cycles int) (*hackCPU, error) {
+	rom, err := assemble(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	cpu := newHackCPU(rom)
+	if _, err := runArguments(args, cpu.ram); err != nil {
+		return nil, err
+	}
+
+	for !cpu.halted && cpu.cycles < cycles {
+		cpu.step()
+
+		if cpu.fault != "" {
+			return nil, fmt.Errorf("%s at cycle %d", cpu.fault, cpu.cycles)
+		}
+	}
+
+	return cpu, nil
+}
+
+// Checks that this translator's output behaves like a reference translator's
+// asm: the same test script gives the same output with either, or without a
+// script, both runs end with the same RAM
+func differentialCommand(args []string) {
+	reference := flag.String("reference", "", "the reference translator's .asm")
+	script := flag.String("script", "", "run this test script against each, comparing their output")
+	cycles := flag.Int("cycles", 1000000, "without a script, clock cycles to run each for at most")
+	ranges := flag.String("ranges", "pointers,temp,stack", "without a script, the RAM to compare: pointers, temp, stack, heap, FIRST-LAST or ADDRESS, separated by commas")
+	parseFlags(args)
+
+	if *reference == "" {
+		log.Fatal("differential needs a -reference .asm")
+	}
+
+	if *script != "" {
+		difference, err := compareScriptRuns(*script, *reference)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if difference != "" {
+			fmt.Printf("%s: differs from %s at %s\n", *script, *reference, difference)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: same output as %s\n", *script, *reference)
+		return
+	}
+
+	instructions, err := loadFolder(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := os.ReadFile(*reference)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ours, err := runAsm(asmLines(instructions), flag.Args(), *cycles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	theirs, err := runAsm(strings.Split(string(data), "\n"), flag.Args(), *cycles)
+	if err != nil {
+		log.Fatalf("%s: %v", *reference, err)
+	}
+
+	// Statics can be laid out differently, so they're only compared if asked
+	// for by address
+	addresses, err := dumpAddresses(*ranges, ours.ram, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The reference's stack counts too when it's deeper
+	if more, err := dumpAddresses(*ranges, theirs.ram, nil); err == nil && len(more) > len(addresses) {
+		addresses = more
+	}
+
+	differs := false
+	for _, address := range addresses {
+		if ours.ram[address] != theirs.ram[address] {
+			fmt.Printf("RAM[%d] = %d, the reference has %d\n", address, ours.ram[address], theirs.ram[address])
+			differs = true
+		}
+	}
+
+	if differs {
+		os.Exit(1)
+	}
+
+	fmt.Printf("same RAM as %s in %d addresses\n", *reference, len(addresses))
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Placeholders the translator is run with to build the patterns. Numbers have
+// to stay numbers, so they're picked well above anything emitted otherwise
+const (
+	sentinelPrefix = "QQPREFIXQQ"
+	sentinelFunc   = "QQFUNCQQ"
+	sentinelName   = "QQNAMEQQ"
+	sentinelLabel  = "QQLABELQQ"
+	sentinelFile   = "QQFILEQQ"
+	sentinelNumber = 30000
+)
+
+// Reconstructs the VM commands an .asm file was translated from. The file has
+// to have been translated with the same flags
+func disassembleCommand(args []string) {
+	parseFlags(args)
+
+	if path.Ext(pathToTranslate) != ".asm" {
+		log.Fatal("disasm needs an .asm file (-path)")
+	}
+
+	source, err := os.ReadFile(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, command := range disassemble(string(source)) {
+		fmt.Println(command)
+	}
+}
+
+func disassemble(source string) []string {
+	var lines []string
+	var annotated []string
+
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+
+		// Annotated output carries the commands themselves
+		if strings.HasPrefix(line, "//") {
+			if command, ok := annotationCommand(line); ok {
+				annotated = append(annotated, sourceCommands(command)...)
+			}
+
+			continue
+		}
+
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(annotated) > 0 {
+		return annotated
+	}
+
+	return newDisassembler().run(lines)
+}
+
+var commandKinds = map[string]bool{
+	"push": true, "pop": true, "function": true, "call": true, "return": true,
+	"label": true, "goto": true, "if-goto": true,
+	"add": true, "sub": true, "neg": true, "eq": true, "gt": true, "lt": true,
+	"and": true, "or": true, "not": true, "shl": true, "shr": true,
+	"mult": true, "div": true, "mod": true, "min": true, "max": true, "abs": true,
+	"breakpoint": true, "cached-push": true, "cached-pop": true, "return-leaf": true,
+	"inline-enter": true, "inline-return": true,
+	"if-eq-goto": true, "if-gt-goto": true, "if-lt-goto": true,
+}
+
+func annotationCommand(line string) (Command, bool) {
+	fields := strings.Fields(strings.TrimPrefix(line, "//"))
+	if len(fields) == 0 || !commandKinds[fields[0]] {
+		return Command{}, false
+	}
+
+	return Command{Kind: fields[0], Args: fields[1:]}, true
+}
+
+// Turns the translator's internal commands back into the VM commands they
+// stand for
+func sourceCommands(command Command) []string {
+	switch command.Kind {
+	case "cached-push", "cached-pop":
+		if len(command.Args) < 2 {
+			break
+		}
+
+		kind := strings.TrimPrefix(command.Kind, "cached-")
+		return []string{fmt.Sprintf("%s %s %s", kind, command.Args[0], command.Args[1])}
+
+	case "if-eq-goto", "if-gt-goto", "if-lt-goto":
+		comparison := strings.TrimSuffix(strings.TrimPrefix(command.Kind, "if-"), "-goto")
+		return []string{comparison, "if-goto " + strings.Join(command.Args, " ")}
+
+	case "return-leaf":
+		return []string{"return"}
+
+	case "inline-enter":
+		return []string{fmt.Sprintf("// inlined call (%s args)", strings.Join(command.Args, " "))}
+
+	case "inline-return":
+		return []string{"// end of inlined call"}
+	}
+
+	return []string{command.String()}
+}
+
+// A run of asm lines the translator emits for one command. Sentinels in the
+// generated lines become capture groups, keyed by the sentinel
+type asmPattern struct {
+	lines []*regexp.Regexp
+	keys  [][]string
+	emit  func(d *disassembler, values map[string]string) ([]string, bool)
+}
+
+func compilePattern(asm string, sentinels ...string) asmPattern {
+	var pattern asmPattern
+
+	sort.Slice(sentinels, func(i, j int) bool {
+		return len(sentinels[i]) > len(sentinels[j])
+	})
+
+	var quoted []string
+	for _, sentinel := range sentinels {
+		quoted = append(quoted, regexp.QuoteMeta(sentinel))
+	}
+
+	var finder *regexp.Regexp
+	if len(quoted) > 0 {
+		finder = regexp.MustCompile(strings.Join(quoted, "|"))
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(asm), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var keys []string
+		expression := regexp.QuoteMeta(line)
+
+		if finder != nil {
+			expression = finder.ReplaceAllStringFunc(expression, func(match string) string {
+				keys = append(keys, match)
+
+				if _, err := strconv.Atoi(match); err == nil {
+					return `(-?\d+)`
+				}
+
+				return `(\S+?)`
+			})
+		}
+
+		pattern.lines = append(pattern.lines, regexp.MustCompile("^"+expression+"$"))
+		pattern.keys = append(pattern.keys, keys)
+	}
+
+	return pattern
+}
+
+// Returns the captured values if the pattern matches the lines at `at`
+func (p asmPattern) match(lines []string, at int) (map[string]string, bool) {
+	if at+len(p.lines) > len(lines) {
+		return nil, false
+	}
+
+	values := map[string]string{}
+
+	for i, expression := range p.lines {
+		groups := expression.FindStringSubmatch(lines[at+i])
+		if groups == nil {
+			return nil, false
+		}
+
+		for k, key := range p.keys[i] {
+			if previous, ok := values[key]; ok && previous != groups[k+1] {
+				return nil, false
+			}
+
+			values[key] = groups[k+1]
+		}
+	}
+
+	return values, true
+}
+
+type disassembler struct {
+	patterns []asmPattern
+	// The address the locRegister holds during a run of cached accesses
+	cachedSegment string
+	cachedIndex   int
+	// The lines that zero a local, repeated after a function label
+	initLocal asmPattern
+}
+
+func number(values map[string]string, key string, offset int) string {
+	n, _ := strconv.Atoi(values[key])
+	return strconv.Itoa(n - offset)
+}
+
+func fixed(commands ...string) func(*disassembler, map[string]string) ([]string, bool) {
+	return func(*disassembler, map[string]string) ([]string, bool) {
+		return commands, true
+	}
+}
+
+func newDisassembler() *disassembler {
+	// The patterns come from a translator of their own, run on sentinels. Put
+	// back the path that touches
+	savedPath := pathToTranslate
+	defer func() { pathToTranslate = savedPath }()
+
+	pathToTranslate = sentinelPrefix
+
+	t := newTranslator()
+	t.FuncStack = Stack{Current: sentinelFunc, ReturnCounter: sentinelNumber + 1}
+	t.CurrentFile = sentinelFile
+
+	d := &disassembler{}
+	n := strconv.Itoa(sentinelNumber)
+
+	add := func(asm string, emit func(*disassembler, map[string]string) ([]string, bool), sentinels ...string) {
+		pattern := compilePattern(asm, sentinels...)
+		pattern.emit = emit
+		d.patterns = append(d.patterns, pattern)
+	}
+
+	// Blocks that don't come from commands are skipped
+	skipped := createReturnRoutine()
+	skipped = append(skipped, createCallRoutine()...)
+	skipped = append(skipped, createLtRoutine()...)
+	skipped = append(skipped, createGtRoutine()...)
+	skipped = append(skipped, createEqRoutine()...)
+	skipped = append(skipped, createLeafReturnRoutine()...)
+	skipped = append(skipped, createShlRoutine()...)
+	skipped = append(skipped, createShrRoutine()...)
+	skipped = append(skipped, createMultRoutine()...)
+	skipped = append(skipped, createDivisionRoutine("DIV")...)
+	skipped = append(skipped, createDivisionRoutine("MOD")...)
+	skipped = append(skipped, createStackTraps()...)
+	skipped = append(skipped, createPointerTrap()...)
+	skipped = append(skipped, createBreakpointTrap()...)
+	skipped = append(skipped, "(START)\n", setStackPointerInstructions(), haltLoop())
+	skipped = append(skipped, strings.Join([]string{"@START", "0;JMP"}, "\n"))
+	skipped = append(skipped, stackGuard("push"), stackGuard("pop"))
+	skipped = append(skipped, pointerGuard(Command{Kind: "push", Args: []string{"this"}}))
+	skipped = append(skipped, pointerGuard(Command{Kind: "push", Args: []string{"that"}}))
+
+	for _, mode := range []string{"loop", "jump"} {
+		savedMode := epilogueMode
+		epilogueMode = mode
+		end, _ := createEpilogue()
+		epilogueMode = savedMode
+
+		skipped = append(skipped, end...)
+	}
+
+	for _, block := range skipped {
+		add(block, fixed())
+	}
+
+	// Push and pop
+	for _, segment := range []string{"constant", "local", "argument", "this", "that", "static", "temp", "pointer"} {
+		for _, kind := range []string{"push", "pop"} {
+			handle := t.handlePush
+			if kind == "pop" {
+				handle = t.handlePop
+			}
+
+			generate := func(segment string, index int) string {
+				asm, _ := handle(segment, index)
+				return asm
+			}
+
+			if segment == "constant" && kind == "pop" {
+				continue
+			}
+
+			segment, kind := segment, kind
+
+			if segment == "pointer" {
+				for index := 0; index <= 1; index++ {
+					add(generate(segment, index), fixed(fmt.Sprintf("%s pointer %d", kind, index)))
+				}
+
+				continue
+			}
+
+			// Numbered statics can't be told apart from other addresses
+			if segment == "static" && memory.Static >= 0 {
+				continue
+			}
+
+			offset := 0
+			if segment == "temp" {
+				offset = memory.Temp
+			}
+
+			emitted := strconv.Itoa(sentinelNumber + offset)
+			add(generate(segment, sentinelNumber), func(_ *disassembler, values map[string]string) ([]string, bool) {
+				return []string{fmt.Sprintf("%s %s %s", kind, segment, number(values, emitted, offset))}, true
+			}, emitted, sentinelFile)
+
+			if _, ok := segmentPointers[segment]; ok {
+				for index := 0; index <= 3; index++ {
+					add(generate(segment, index), fixed(fmt.Sprintf("%s %s %d", kind, segment, index)))
+				}
+			}
+		}
+	}
+
+	d.addCachedPatterns(add, "push")
+	d.addCachedPatterns(add, "pop")
+
+	// Operations
+	for _, op := range []string{"add", "sub", "neg", "and", "or", "not"} {
+		asm, _ := t.operation(op)
+		add(asm, fixed(op))
+	}
+
+	t.EqCount, t.GtCount, t.LtCount, t.ShlCount, t.ShrCount = sentinelNumber, sentinelNumber, sentinelNumber, sentinelNumber, sentinelNumber
+	t.MultCount, t.DivCount, t.ModCount = sentinelNumber, sentinelNumber, sentinelNumber
+	for _, op := range []string{"eq", "gt", "lt"} {
+		asm, _ := t.operation(op)
+		add(asm, fixed(op), n)
+	}
+
+	for _, mode := range []string{"nop", "trap"} {
+		savedMode := breakpointMode
+		breakpointMode = mode
+		add(breakpoint(), fixed("breakpoint"))
+		breakpointMode = savedMode
+	}
+
+	// Whatever the dialect, as the asm says what it was written in
+	for _, op := range []string{"shl", "shr", "mult", "div", "mod", "min", "max", "abs"} {
+		// min, max and abs number their labels from the same count
+		t.BranchCount = sentinelNumber
+		add(t.extendedOperation(op), fixed(op), n)
+	}
+
+	// Branching, the labels scoped as the translation's are
+	scope := labelOwner(sentinelFunc, sentinelFile)
+
+	add(t.label(sentinelLabel), func(_ *disassembler, values map[string]string) ([]string, bool) {
+		return []string{"label " + values[sentinelLabel]}, true
+	}, scope, sentinelLabel)
+
+	add(t.gotoLabel(sentinelLabel), func(_ *disassembler, values map[string]string) ([]string, bool) {
+		return []string{"goto " + values[sentinelLabel]}, true
+	}, scope, sentinelLabel)
+
+	add(t.ifGoto(sentinelLabel), func(_ *disassembler, values map[string]string) ([]string, bool) {
+		return []string{"if-goto " + values[sentinelLabel]}, true
+	}, scope, sentinelLabel)
+
+	for jump, comparison := range map[string]string{"JEQ": "eq", "JGT": "gt", "JLT": "lt"} {
+		comparison := comparison
+		add(t.compareAndJump(jump, sentinelLabel), func(_ *disassembler, values map[string]string) ([]string, bool) {
+			return []string{comparison, "if-goto " + values[sentinelLabel]}, true
+		}, scope, sentinelLabel)
+	}
+
+	// Functions
+	definition, _ := t.function(sentinelName, "0")
+	add(definition, func(d *disassembler, values map[string]string) ([]string, bool) {
+		if strings.Contains(values[sentinelName], "$") {
+			return nil, false
+		}
+
+		return []string{"function " + values[sentinelName]}, true
+	}, sentinelPrefix, sentinelName)
+
+	withLocal, _ := t.function(sentinelName, "1")
+	d.initLocal = compilePattern(strings.SplitN(withLocal, "\n", 2)[1])
+
+	// Defining the function switched the context
+	t.FuncStack.Current = sentinelFunc
+
+	call, _ := t.callFunction(sentinelName, n)
+	add(call, func(_ *disassembler, values map[string]string) ([]string, bool) {
+		return []string{fmt.Sprintf("call %s %s", values[sentinelName], values[n])}, true
+	}, sentinelPrefix, sentinelName, sentinelFunc, n, strconv.Itoa(sentinelNumber+1))
+
+	add(returnFromFunction(), fixed("return"))
+	add(t.returnFromLeafFunction(), fixed("return"))
+
+	enter, _ := enterInlined(n)
+	add(enter, func(_ *disassembler, values map[string]string) ([]string, bool) {
+		// The enter sequence counts the saved ARG too
+		return []string{fmt.Sprintf("// inlined call (%s args)", number(values, strconv.Itoa(sentinelNumber+1), 1))}, true
+	}, strconv.Itoa(sentinelNumber+1))
+
+	leave, _ := returnFromInlined(n)
+	add(leave, fixed("// end of inlined call"), n)
+
+	return d
+}
+
+// Cached accesses start with the full address and then step it, whatever the
+// segment
+func (d *disassembler) addCachedPatterns(add func(string, func(*disassembler, map[string]string) ([]string, bool), ...string), kind string) {
+	cachedKind := "cached-" + kind
+	n := strconv.Itoa(sentinelNumber)
+
+	emit := func(step func(d *disassembler, values map[string]string) int) func(*disassembler, map[string]string) ([]string, bool) {
+		return func(d *disassembler, values map[string]string) ([]string, bool) {
+			d.cachedIndex = step(d, values)
+			return []string{fmt.Sprintf("%s %s %d", kind, d.cachedSegment, d.cachedIndex)}, true
+		}
+	}
+
+	for segment := range segmentPointers {
+		segment := segment
+		first, _ := cachedAccess(Command{Kind: cachedKind, Args: []string{segment, n}})
+		add(first, emit(func(d *disassembler, values map[string]string) int {
+			d.cachedSegment = segment
+			index, _ := strconv.Atoi(values[n])
+			return index
+		}), n)
+	}
+
+	for _, delta := range []int{0, 1, -1} {
+		delta := delta
+		asm, _ := cachedAccess(Command{Kind: cachedKind, Args: []string{"local", strconv.Itoa(5 + delta), "5"}})
+		add(asm, emit(func(d *disassembler, _ map[string]string) int {
+			return d.cachedIndex + delta
+		}))
+	}
+
+	forwards, _ := cachedAccess(Command{Kind: cachedKind, Args: []string{"local", n, "0"}})
+	add(forwards, emit(func(d *disassembler, values map[string]string) int {
+		delta, _ := strconv.Atoi(values[n])
+		return d.cachedIndex + delta
+	}), n)
+
+	backwards, _ := cachedAccess(Command{Kind: cachedKind, Args: []string{"local", "0", n}})
+	add(backwards, emit(func(d *disassembler, values map[string]string) int {
+		delta, _ := strconv.Atoi(values[n])
+		return d.cachedIndex - delta
+	}), n)
+}
+
+func (d *disassembler) run(lines []string) []string {
+	var commands []string
+
+	for i := 0; i < len(lines); {
+		best := -1
+		var bestCommands []string
+		cachedSegment, cachedIndex := d.cachedSegment, d.cachedIndex
+
+		for _, pattern := range d.patterns {
+			if len(pattern.lines) <= best {
+				continue
+			}
+
+			values, ok := pattern.match(lines, i)
+			if !ok {
+				continue
+			}
+
+			// Try it on a copy so a rejected pattern leaves no trace
+			trial := *d
+			emitted, ok := pattern.emit(&trial, values)
+			if !ok {
+				continue
+			}
+
+			best = len(pattern.lines)
+			bestCommands = emitted
+			cachedSegment, cachedIndex = trial.cachedSegment, trial.cachedIndex
+		}
+
+		d.cachedSegment, d.cachedIndex = cachedSegment, cachedIndex
+
+		if best < 0 {
+			commands = append(commands, "// unrecognised: "+lines[i])
+			i++
+			continue
+		}
+
+		i += best
+
+		// Count the locals a function zeroes
+		if len(bestCommands) == 1 && strings.HasPrefix(bestCommands[0], "function ") {
+			locals := 0
+			for {
+				if _, ok := d.initLocal.match(lines, i); !ok {
+					break
+				}
+
+				i += len(d.initLocal.lines)
+				locals++
+			}
+
+			bestCommands[0] = fmt.Sprintf("%s %d", bestCommands[0], locals)
+		}
+
+		commands = append(commands, bestCommands...)
+	}
+
+	return commands
+}
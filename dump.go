@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// How many columns a dump puts in each heading and value row
+const dumpColumns = 8
+
+// The addresses a range of a memory dump covers. Besides FIRST-LAST and
+// single addresses there are named ranges: pointers, temp, statics (the
+// addresses given), stack (up to SP) and heap (up to its last nonzero word)
+func dumpAddresses(spec string, ram []int16, statics []int) ([]int, error) {
+	var addresses []int
+	between := func(first int, last int) {
+		for address := first; address <= last && address < len(ram); address++ {
+			addresses = append(addresses, address)
+		}
+	}
+
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+
+		switch name {
+		case "":
+		case "pointers":
+			between(0, 4)
+		case "temp":
+			between(memory.Temp, memory.Temp+7)
+		case "statics":
+			addresses = append(addresses, statics...)
+		case "stack":
+			between(memory.Stack, int(ram[0])-1)
+		case "heap":
+			last := memory.Heap - 1
+			for address := memory.Heap; address < memory.Screen && address < len(ram); address++ {
+				if ram[address] != 0 {
+					last = address
+				}
+			}
+
+			between(memory.Heap, last)
+		default:
+			first, last, ok := strings.Cut(name, "-")
+			if !ok {
+				last = first
+			}
+
+			from, err := strconv.Atoi(first)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dump range: %s", name)
+			}
+
+			to, err := strconv.Atoi(last)
+			if err != nil || from < 0 || to < from || to >= len(ram) {
+				return nil, fmt.Errorf("invalid dump range: %s", name)
+			}
+
+			between(from, to)
+		}
+	}
+
+	return addresses, nil
+}
+
+// Lays the RAM out as the test tools write output files: rows of headings
+// with a row of values under each
+func formatDump(addresses []int, ram []int16) string {
+	var lines []string
+
+	for start := 0; start < len(addresses); start += dumpColumns {
+		end := start + dumpColumns
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+
+		var headings, cells []string
+		for _, address := range addresses[start:end] {
+			output := tstOutput{name: fmt.Sprintf("RAM[%d]", address), format: 'D', left: 1, width: 6, right: 1}
+			headings = append(headings, output.heading())
+			cells = append(cells, output.cell(int(ram[address])))
+		}
+
+		lines = append(lines, "|"+strings.Join(headings, "|")+"|", "|"+strings.Join(cells, "|")+"|")
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func saveDump(fileName string, spec string, ram []int16, statics []int) error {
+	addresses, err := dumpAddresses(spec, ram, statics)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, []byte(formatDump(addresses, ram)), 0644)
+}
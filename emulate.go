@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The RAM an emulation prints when it isn't told which addresses to: the
+// pointers, temp, the assembler's variables and whatever is on the stack
+func defaultCPUDump(cpu *hackCPU, symbols []asmSymbol) []int {
+	addresses := []int{0, 1, 2, 3, 4}
+
+	for i := 0; i < 8; i++ {
+		addresses = append(addresses, memory.Temp+i)
+	}
+
+	addresses = append(addresses, asmVariables(symbols)...)
+
+	for address := memory.Stack; address < int(cpu.ram[0]) && address < len(cpu.ram); address++ {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// The RAM addresses of the assembler's variables, in order
+func asmVariables(symbols []asmSymbol) []int {
+	var variables []int
+	for _, symbol := range symbols {
+		if !symbol.Label {
+			variables = append(variables, symbol.Address)
+		}
+	}
+	sort.Ints(variables)
+
+	return variables
+}
+
+// Translates the program and assembles it for the subcommands that run the
+// machine code, with the RAM the positional arguments set and the addresses
+// they ask to see
+func loadHackCPU() ([]string, *hackCPU, []int) {
+	shouldKeepCommands = true
+
+	// Single files get the routines and bootstrap too, so they can run
+	instructions, err := loadFolder(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rom, err := assemble(asmLines(instructions))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cpu := newHackCPU(rom)
+
+	dump, err := runArguments(flag.Args(), cpu.ram)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return instructions, cpu, dump
+}
+
+// Translates the program, assembles it and runs the machine code, printing
+// the RAM it finishes with
+func emulateCommand(args []string) {
+	cmpPath := flag.String("cmp", "", "check the final RAM against this .cmp file, exiting 1 on a mismatch")
+	dumpPath := flag.String("dump", "", "write the final RAM to this file in the test tools' output format")
+	dumpRanges := flag.String("dump-ranges", "pointers,temp,statics,stack", "what -dump writes: pointers, temp, statics, stack, heap, FIRST-LAST or ADDRESS, separated by commas")
+	screenPath := flag.String("screen", "", "write the final screen to this PNG file")
+	liveScreen := flag.Int("screen-live", 0, "redraw the screen in the terminal every this many cycles (0 for never)")
+	keysPath := flag.String("keys", "", "press keys from this script: lines of press KEY for N cycles or wait N cycles")
+	liveKeys := flag.Bool("keys-live", false, "press the keys typed at the terminal")
+	keyHold := flag.Int("key-hold", 20000, "cycles -keys-live holds each typed key down for")
+	tracePath := flag.String("trace", "", "log each VM command run, with its function, SP and top of stack, to this file")
+	traceEvery := flag.Int("trace-every", 1, "log only every this many commands to -trace")
+	watchList := flag.String("watch", "", "report each change to these addresses or variables (e.g. RAM[16],Foo.vm.3), separated by commas")
+	maxCycles := flag.Int("max-cycles", 0, "stop with an error after this many clock cycles (0 for no limit)")
+	timeout := flag.Duration("timeout", 0, "stop with an error after running this long (0 for no limit)")
+	parseFlags(args)
+
+	instructions, cpu, dump := loadHackCPU()
+	lines := asmLines(instructions)
+	program := newProgramMap(instructions, len(cpu.rom))
+	watch := newFaultWatch(program)
+	symbols := resolveSymbols(lines)
+	watches := newWatchpoints()
+
+	if *watchList != "" {
+		for _, target := range strings.Split(*watchList, ",") {
+			address, err := resolveWatch(target, symbols)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			watches.add(address, target, cpu.ram)
+		}
+	}
+
+	var keys keyFeed
+	var live *liveKeyboard
+	var err error
+
+	switch {
+	case *keysPath != "" && *liveKeys:
+		log.Fatal("-keys and -keys-live can't be used together")
+
+	case *keysPath != "":
+		if keys, err = loadKeyScript(*keysPath); err != nil {
+			log.Fatal(err)
+		}
+
+	case *liveKeys:
+		if live, err = newLiveKeyboard(*keyHold); err != nil {
+			log.Fatal(err)
+		}
+
+		keys = live
+	}
+
+	var trace *bufio.Writer
+	traced := 0
+
+	if *tracePath != "" {
+		file, err := os.Create(*tracePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		trace = bufio.NewWriter(file)
+		defer trace.Flush()
+
+		fmt.Fprintln(trace, "cycle\tfunction\tsource\tcommand\tSP\ttop")
+	}
+
+	// Stops the run with an error and the VM call stack
+	stop := func(reason string) {
+		if live != nil {
+			live.reset()
+		}
+
+		if trace != nil {
+			trace.Flush()
+		}
+
+		fmt.Fprintln(os.Stderr, reason)
+		for _, frame := range program.stackTrace(cpu, watch.commandPC) {
+			fmt.Fprintln(os.Stderr, frame)
+		}
+
+		os.Exit(1)
+	}
+
+	limits := newRunLimits(*maxCycles, *timeout)
+
+	if *liveScreen > 0 {
+		fmt.Print("\x1b[2J")
+	}
+
+	for !cpu.halted {
+		if keys != nil {
+			keys.update(cpu)
+		}
+
+		if trace != nil && *traceEvery > 0 {
+			if owner, ok := program.commandAt(cpu.pc); ok {
+				if traced%*traceEvery == 0 {
+					fmt.Fprintln(trace, program.traceLine(cpu, owner))
+				}
+
+				traced++
+			}
+		}
+
+		writer := cpu.pc
+		if program.owner(writer) < 0 {
+			writer = watch.commandPC
+		}
+
+		cpu.step()
+
+		for _, change := range watches.changes(cpu.ram) {
+			fmt.Fprintf(os.Stderr, "cycle %d: %s, written by %s\n", cpu.cycles, change, program.describe(writer))
+		}
+
+		// The program's own breakpoints are reported, and the run goes on
+		if program.breakpoints[cpu.pc] {
+			fmt.Fprintf(os.Stderr, "cycle %d: breakpoint at %s\n", cpu.cycles, program.location(cpu.pc))
+			for _, frame := range program.stackTrace(cpu, cpu.pc) {
+				fmt.Fprintln(os.Stderr, frame)
+			}
+		}
+
+		if *liveScreen > 0 && cpu.cycles%*liveScreen == 0 {
+			fmt.Print("\x1b[H" + terminalScreen(cpu.ram))
+		}
+
+		if fault := watch.check(cpu); fault != "" {
+			stop(fmt.Sprintf("%s at cycle %d", fault, cpu.cycles))
+		}
+
+		if limit := limits.exceeded(cpu.cycles); limit != "" {
+			stop(fmt.Sprintf("%s at %s, cycle %d", limit, program.location(watch.commandPC), cpu.cycles))
+		}
+	}
+
+	if live != nil {
+		live.reset()
+	}
+
+	if len(dump) == 0 {
+		dump = defaultCPUDump(cpu, symbols)
+	}
+
+	if *liveScreen > 0 {
+		fmt.Print("\x1b[H" + terminalScreen(cpu.ram))
+	}
+
+	if *screenPath != "" {
+		if err := saveScreen(*screenPath, cpu.ram); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *dumpPath != "" {
+		if err := saveDump(*dumpPath, *dumpRanges, cpu.ram, asmVariables(symbols)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *cmpPath != "" {
+		checkFinalRAM(*cmpPath, cpu.ram, fmt.Sprintf("cycle %d", cpu.cycles))
+		return
+	}
+
+	for _, address := range dump {
+		fmt.Printf("RAM[%d] = %d\n", address, cpu.ram[address])
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var segmentBases = map[string]string{
+	"local":    "LCL",
+	"argument": "ARG",
+	"this":     "THIS",
+	"that":     "THAT",
+}
+
+// Where a segment entry lives, in words
+func (t *translator) describeSegment(segment string, index string) string {
+	if base, ok := segmentBases[segment]; ok {
+		return fmt.Sprintf("RAM[%s+%s]", base, index)
+	}
+
+	n, _ := strconv.Atoi(index)
+
+	switch segment {
+	case "constant":
+		return index
+	case "pointer":
+		if n == 1 {
+			return "THAT"
+		}
+
+		return "THIS"
+	case "temp":
+		return fmt.Sprintf("RAM[%d]", memory.Temp+n)
+	case "global":
+		return fmt.Sprintf("RAM[%d]", memory.Global+n)
+	case "static":
+		return fmt.Sprintf("the static %s.%s", t.CurrentFile, index)
+	}
+
+	return segment + " " + index
+}
+
+var binaryExplanations = map[string]string{
+	"add":  "push x+y",
+	"sub":  "push x-y",
+	"and":  "push x&y",
+	"or":   "push x|y",
+	"eq":   "push x==y ? -1 : 0, via the shared EQ routine",
+	"gt":   "push x>y ? -1 : 0, via the shared GT routine",
+	"lt":   "push x<y ? -1 : 0, via the shared LT routine",
+	"shl":  "push x<<y, via the shared SHL routine",
+	"shr":  "push x>>y (keeping the sign), via the shared SHR routine",
+	"mult": "push x*y, via the shared MULT routine",
+	"div":  "push x/y (rounding towards zero), via the shared DIV routine",
+	"mod":  "push x%y (taking the sign of x), via the shared MOD routine",
+	"min":  "push x<y ? x : y",
+	"max":  "push x>y ? x : y",
+}
+
+var fusedExplanations = map[string]string{
+	"if-eq-goto": "==",
+	"if-gt-goto": ">",
+	"if-lt-goto": "<",
+}
+
+// Plain-English commentary on what a command's asm does, one comment line
+// each
+func (t *translator) explanation(c Command) []string {
+	arg := func(i int) string {
+		if i < len(c.Args) {
+			return c.Args[i]
+		}
+
+		return ""
+	}
+
+	label := t.scopedLabel(arg(0))
+
+	switch c.Kind {
+	case "push":
+		return []string{fmt.Sprintf("D = %s, RAM[SP] = D, SP++", t.describeSegment(arg(0), arg(1)))}
+
+	case "pop":
+		index, _ := strconv.Atoi(arg(1))
+		_, small := smallIndexAddress(arg(0), index)
+
+		// Index 0 and small indices can be reached without a spare register
+		if _, ok := segmentBases[arg(0)]; ok && index != 0 && !small {
+			return []string{
+				fmt.Sprintf("work out the address of %s and park it in R13", t.describeSegment(arg(0), arg(1))),
+				"SP--, D = RAM[SP], RAM[R13] = D",
+			}
+		}
+
+		return []string{fmt.Sprintf("SP--, %s = RAM[SP]", t.describeSegment(arg(0), arg(1)))}
+
+	case "cached-push", "cached-pop":
+		direction := "push it"
+		if c.Kind == "cached-pop" {
+			direction = "pop into it"
+		}
+
+		if len(c.Args) == 2 {
+			return []string{fmt.Sprintf("work out the address of %s, keep it in R13 for the accesses after, and %s", t.describeSegment(arg(0), arg(1)), direction)}
+		}
+
+		return []string{fmt.Sprintf("move the address in R13 from %s to %s, then %s", t.describeSegment(arg(0), arg(2)), t.describeSegment(arg(0), arg(1)), direction)}
+
+	case "shared-push", "shared-pop":
+		shape := strings.TrimPrefix(c.Kind, "shared-") + " " + arg(0) + " " + arg(1)
+		return []string{fmt.Sprintf("jump to the %s routine shared by every %s, with D = where to come back to", sharedRoutineName(shape), shape)}
+
+	case "asm":
+		return []string{"hand-written asm, as it is in the source"}
+
+	case "neg":
+		return []string{"replace x on top of the stack with -x"}
+
+	case "not":
+		return []string{"replace x on top of the stack with !x"}
+
+	case "abs":
+		return []string{"replace x on top of the stack with -x if it's negative"}
+
+	case "breakpoint":
+		if breakpointMode == "trap" {
+			return []string{"jump to BREAKPOINT and spin there, a trap for a CPU emulator to show"}
+		}
+
+		return []string{"do nothing, an instruction for the emulator and debugger to stop at"}
+
+	case "label":
+		return []string{fmt.Sprintf("mark this point as %s, no code", label)}
+
+	case "goto":
+		return []string{fmt.Sprintf("jump to %s", label)}
+
+	case "if-goto":
+		return []string{fmt.Sprintf("pop x, jump to %s if x != 0", label)}
+
+	case "if-eq-goto", "if-gt-goto", "if-lt-goto":
+		return []string{fmt.Sprintf("pop y, pop x, jump to %s if x%sy (a comparison fused with its if-goto)", label, fusedExplanations[c.Kind])}
+
+	case "function":
+		return []string{fmt.Sprintf("entry point of %s, then push %s locals set to 0", arg(0), arg(1))}
+
+	case "call":
+		return []string{
+			fmt.Sprintf("put the address of %s in R13 and the argument count (%s) in R14,", arg(0), arg(1)),
+			"then jump to the shared CALL routine with the return address in D. It",
+			"pushes the return address, LCL, ARG, THIS and THAT, sets ARG = SP-5-args,",
+			"sets LCL = SP and jumps to the function",
+		}
+
+	case "return":
+		return []string{
+			"jump to the shared RETURN routine. It takes the return address from",
+			"LCL-5, puts the return value in RAM[ARG], sets SP = ARG+1, restores",
+			"THAT, THIS, ARG and LCL from the frame, then jumps to the return address",
+		}
+
+	case "return-leaf":
+		return []string{"return from a function that makes no calls, via the shared RETURN_LEAF routine"}
+
+	case "inline-enter":
+		return []string{fmt.Sprintf("start of an inlined call: push the caller's ARG, point ARG at the %s arguments below it", arg(0))}
+
+	case "inline-return":
+		return []string{"end of an inlined call: put the return value where the first argument was, drop the rest and restore the caller's ARG"}
+	}
+
+	if operation, ok := binaryExplanations[c.Kind]; ok {
+		return []string{"pop y, pop x, " + operation}
+	}
+
+	return nil
+}
+
+var jumpExplanations = map[string]string{
+	"JGT": "> 0", "JEQ": "== 0", "JGE": ">= 0", "JLT": "< 0", "JNE": "!= 0", "JLE": "<= 0",
+}
+
+// What a single Hack instruction does, e.g. `AM=M-1` is "A = RAM[A] = RAM[A]-1"
+func describeInstruction(line string) string {
+	if strings.HasPrefix(line, "(") {
+		return "label " + strings.Trim(line, "()")
+	}
+
+	if strings.HasPrefix(line, "@") {
+		value := line[1:]
+		if address, ok := predefinedSymbols[value]; ok {
+			return fmt.Sprintf("A = %d (%s)", address, value)
+		}
+
+		if isSymbol(value) {
+			return "A = the address of " + value
+		}
+
+		return "A = " + value
+	}
+
+	dest, comp, jump := "", line, ""
+	if i := strings.Index(comp, "="); i >= 0 {
+		dest, comp = comp[:i], comp[i+1:]
+	}
+
+	if i := strings.Index(comp, ";"); i >= 0 {
+		comp, jump = comp[:i], comp[i+1:]
+	}
+
+	value := strings.NewReplacer("M", "RAM[A]").Replace(comp)
+
+	var parts []string
+
+	if dest != "" {
+		var targets []string
+		for _, register := range dest {
+			if register == 'M' {
+				targets = append(targets, "RAM[A]")
+			} else {
+				targets = append(targets, string(register))
+			}
+		}
+
+		parts = append(parts, strings.Join(targets, " = ")+" = "+value)
+	}
+
+	switch {
+	case jump == "JMP":
+		parts = append(parts, "jump to the address in A")
+	case jump != "":
+		parts = append(parts, fmt.Sprintf("jump to the address in A if %s %s", value, jumpExplanations[jump]))
+	}
+
+	return strings.Join(parts, ", then ")
+}
+
+// Prints the asm a VM command translates to, with commentary on the command
+// and on each instruction
+func explainCommand(args []string) {
+	parseFlags(args)
+
+	source := strings.Join(flag.Args(), " ")
+	if source == "" {
+		log.Fatal(`explain needs a VM command, e.g. explain "pop that 5"`)
+	}
+
+	parser := NewParser("Explain.vm")
+	commands, err := parser.Parse(bufio.NewScanner(strings.NewReader(source)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, command := range commands {
+		translation.CurrentFile = command.File
+
+		asm, err := translation.translateCommand(command)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("// " + command.String())
+		for _, line := range translation.explanation(command) {
+			fmt.Println("// " + line)
+		}
+
+		lines := asmLines([]string{asm})
+
+		width := 0
+		for _, line := range lines {
+			if len(line) > width {
+				width = len(line)
+			}
+		}
+
+		for _, line := range lines {
+			fmt.Printf("%-*s  // %s\n", width, line, describeInstruction(line))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Canonicalises .vm files, like gofmt does for Go
+func formatCommand(args []string) {
+	write := flag.Bool("w", false, "write the result back to the file instead of stdout")
+	list := flag.Bool("l", false, "only list the files whose formatting differs")
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		formatted := formatSource(string(source))
+		changed := !bytes.Equal(source, []byte(formatted))
+
+		if *list {
+			if changed {
+				fmt.Println(file)
+			}
+
+			continue
+		}
+
+		if *write {
+			if changed {
+				if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			continue
+		}
+
+		fmt.Print(formatted)
+	}
+}
+
+// How many args each command takes, so run-together commands can be split
+var commandArity = map[string]int{
+	"push": 2, "pop": 2, "function": 2, "call": 2,
+	"label": 1, "goto": 1, "if-goto": 1, "include": 1, "const": 2, "alias": 3,
+	"breakpoint": 0, "return": 0, "add": 0, "sub": 0, "neg": 0, "eq": 0, "gt": 0, "lt": 0,
+	"and": 0, "or": 0, "not": 0, "shl": 0, "shr": 0,
+	"mult": 0, "div": 0, "mod": 0, "min": 0, "max": 0, "abs": 0,
+}
+
+// Keywords are lowercased, names (labels, functions) are kept as written
+func formatCommands(code string) []string {
+	fields := strings.Fields(code)
+	var commands []string
+
+	for len(fields) > 0 {
+		kind := strings.ToLower(fields[0])
+
+		arity, ok := commandArity[kind]
+		if !ok || len(fields) < arity+1 {
+			// Leave anything unrecognised as it is, bar the spacing
+			commands = append(commands, strings.Join(fields, " "))
+			break
+		}
+
+		command := []string{kind}
+		command = append(command, fields[1:arity+1]...)
+
+		if kind == "push" || kind == "pop" {
+			command[1] = strings.ToLower(command[1])
+		}
+
+		commands = append(commands, strings.Join(command, " "))
+		fields = fields[arity+1:]
+	}
+
+	return commands
+}
+
+type formattedLine struct {
+	code    string
+	comment string
+}
+
+func formatSource(source string) string {
+	var lines []formattedLine
+	blank := false
+
+	inBlockComment := false
+	inAsm := false
+
+	for _, line := range strings.Split(source, "\n") {
+		raw := strings.TrimRight(line, " \t\r")
+		line = strings.TrimSpace(raw)
+
+		// Asm is left as it's written
+		if inAsm {
+			inAsm = line != "}"
+			lines = append(lines, formattedLine{comment: raw})
+			blank = false
+			continue
+		}
+
+		// What's inside a block comment is left as it was written
+		wasInBlockComment := inBlockComment
+		var blanked string
+		blanked, inBlockComment = blankComments(line, inBlockComment)
+
+		if wasInBlockComment && strings.TrimSpace(blanked) == "" {
+			lines = append(lines, formattedLine{comment: raw})
+			blank = false
+			continue
+		}
+
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if opensAsmBlock(blanked) {
+			inAsm = true
+		}
+
+		// Runs of blank lines collapse to one
+		if blank {
+			lines = append(lines, formattedLine{})
+			blank = false
+		}
+
+		code, comment := line, ""
+		if i := len(blanked); i < len(line) {
+			code, comment = strings.TrimSpace(line[:i]), line[i:]
+		}
+
+		if wasInBlockComment || strings.Contains(line, "/*") {
+			// Only a comment after the code can be moved along with it
+			end := len(strings.TrimRight(blanked, " "))
+			if line[:end] != blanked[:end] {
+				lines = append(lines, formattedLine{comment: line})
+				continue
+			}
+
+			code, comment = line[:end], strings.TrimSpace(line[end:])
+		}
+
+		if code == "" {
+			lines = append(lines, formattedLine{comment: comment})
+			continue
+		}
+
+		commands := formatCommands(code)
+		if literal, ok := pushedString(code); ok {
+			commands = []string{"push string " + literal}
+		}
+
+		for _, command := range commands[:len(commands)-1] {
+			lines = append(lines, formattedLine{code: command})
+		}
+
+		// A trailing comment stays with the last command on its line
+		lines = append(lines, formattedLine{code: commands[len(commands)-1], comment: comment})
+	}
+
+	var out strings.Builder
+
+	// Trailing comments line up within each block of consecutive commands
+	for start := 0; start < len(lines); {
+		end := start
+		width := 0
+
+		for end < len(lines) && lines[end].code != "" {
+			if lines[end].comment != "" && len(lines[end].code) > width {
+				width = len(lines[end].code)
+			}
+
+			end++
+		}
+
+		if end == start {
+			out.WriteString(lines[start].comment + "\n")
+			start++
+			continue
+		}
+
+		for _, line := range lines[start:end] {
+			if line.comment == "" {
+				out.WriteString(line.code + "\n")
+				continue
+			}
+
+			padding := strings.Repeat(" ", width-len(line.code)+1)
+			out.WriteString(line.code + padding + line.comment + "\n")
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
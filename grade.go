@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How one submission fared
+type submissionResult struct {
+	Submission string       `json:"submission"`
+	Translated bool         `json:"translated"`
+	Errors     []diagnostic `json:"errors"`
+	Warnings   []string     `json:"warnings"`
+	ROMSize    int          `json:"romSize"`
+	Tests      []testResult `json:"tests"`
+}
+
+type testResult struct {
+	Script  string `json:"script"`
+	Passed  bool   `json:"passed"`
+	Failure string `json:"failure,omitempty"`
+}
+
+func (r submissionResult) testsPassed() int {
+	passed := 0
+	for _, test := range r.Tests {
+		if test.Passed {
+			passed++
+		}
+	}
+
+	return passed
+}
+
+// The folder of .vm files in a submission: the submission itself, or the one
+// folder under it that has any
+func submissionProgram(submission string) (string, error) {
+	if _, err := vmFiles(submission); err == nil {
+		return submission, nil
+	}
+
+	projects, err := findProjects(submission)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(projects) {
+	case 0:
+		return "", fmt.Errorf("no .vm files found")
+	case 1:
+		return projects[0], nil
+	}
+
+	return "", fmt.Errorf("several folders of .vm files: %s", strings.Join(projects, ", "))
+}
+
+// Things worth a look that don't stop a program translating: functions
+// nothing calls, other than where programs start
+func submissionWarnings(commands []Command) []string {
+	var warnings []string
+
+	var names []string
+	for name := range collectFunctions(commands) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	graph := dependencyGraph(commands)
+	for _, name := range names {
+		if len(graph[name].calledBy) == 0 && name != "Sys.init" && name != "Main.main" {
+			warnings = append(warnings, fmt.Sprintf("function %s is never called", name))
+		}
+	}
+
+	return warnings
+}
+
+// Runs each test script against the program, in a folder of its own with the
+// scripts' files, so the submission is left as it was
+func runSubmissionTests(program string, tests string) ([]testResult, error) {
+	scripts, err := filepath.Glob(filepath.Join(tests, "*.tst"))
+	if err != nil || len(scripts) == 0 {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "vmtranslator-grade")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// Scripts load the program by the name of the folder they're in
+	work := filepath.Join(dir, filepath.Base(tests))
+	if err := os.Mkdir(work, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, folder := range []string{tests, program} {
+		entries, err := os.ReadDir(folder)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			// The program from the submission, everything else from the tests
+			if entry.IsDir() || (folder == program) != (filepath.Ext(entry.Name()) == ".vm") {
+				continue
+			}
+
+			contents, err := os.ReadFile(filepath.Join(folder, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			if err := os.WriteFile(filepath.Join(work, entry.Name()), contents, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var results []testResult
+	for _, script := range scripts {
+		result := testResult{Script: filepath.Base(script), Passed: true}
+
+		if err := runTestScript(filepath.Join(work, filepath.Base(script))); err != nil {
+			result.Passed = false
+			result.Failure = strings.TrimPrefix(err.Error(), filepath.Join(work, result.Script)+": ")
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Translates a submission and runs the tests on it. Programs with a Sys.init
+// get the standard bootstrap unless the flags ask for one already
+func gradeSubmission(submission string, tests string) submissionResult {
+	result := submissionResult{Submission: filepath.Base(submission), Errors: []diagnostic{}, Warnings: []string{}, Tests: []testResult{}}
+
+	fail := func(err error) submissionResult {
+		result.Errors = append(result.Errors, newDiagnostic(err))
+		return result
+	}
+
+	program, err := submissionProgram(submission)
+	if err != nil {
+		return fail(err)
+	}
+
+	files, err := vmFiles(program)
+	if err != nil {
+		return fail(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err == nil {
+		result.Warnings = append(result.Warnings, submissionWarnings(commands)...)
+	}
+
+	standard := shouldStandardBootstrap
+	defer func() { shouldStandardBootstrap = standard }()
+
+	if _, ok := collectFunctions(commands)["Sys.init"]; ok && !shouldBootstrap {
+		shouldStandardBootstrap = true
+	}
+
+	service := translationService{}
+	err = service.Check(program, func(d diagnostic) error {
+		result.Errors = append(result.Errors, d)
+		return nil
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(result.Errors) > 0 {
+		return result
+	}
+
+	instructions, err := service.Translate(program)
+	if err != nil {
+		return fail(err)
+	}
+
+	rom, err := assemble(asmLines(instructions))
+	if err != nil {
+		return fail(err)
+	}
+
+	result.Translated = true
+	result.ROMSize = len(rom)
+
+	if tests != "" {
+		results, err := runSubmissionTests(program, tests)
+		if err != nil {
+			return fail(err)
+		}
+
+		result.Tests = append(result.Tests, results...)
+	}
+
+	return result
+}
+
+// What went wrong with a submission, and what passed, as a student would read it
+func submissionReport(result submissionResult) string {
+	var report strings.Builder
+
+	fmt.Fprintf(&report, "%s\n\n", result.Submission)
+
+	if result.Translated {
+		fmt.Fprintf(&report, "translated to %d instructions\n", result.ROMSize)
+	} else {
+		report.WriteString("did not translate\n")
+	}
+
+	for _, e := range result.Errors {
+		if e.File != "" {
+			fmt.Fprintf(&report, "error: %s:%d: %s\n", e.File, e.Line, e.Message)
+		} else {
+			fmt.Fprintf(&report, "error: %s\n", e.Message)
+		}
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&report, "warning: %s\n", warning)
+	}
+
+	for _, test := range result.Tests {
+		if test.Passed {
+			fmt.Fprintf(&report, "pass: %s\n", test.Script)
+		} else {
+			fmt.Fprintf(&report, "FAIL: %s: %s\n", test.Script, test.Failure)
+		}
+	}
+
+	return report.String()
+}
+
+// Translates each submission in a folder, one student's program per folder,
+// runs the test scripts in -tests against it and summarises them all as a
+// table, CSV or JSON, optionally writing a report for each submission
+func gradeCommand(args []string) {
+	submissions := flag.String("submissions", "", "folder holding a folder per submission")
+	tests := flag.String("tests", "", "folder of .tst scripts and the files they use, run against each submission")
+	format := flag.String("format", "table", "summary format: table, csv or json")
+	reports := flag.String("reports", "", "folder to write a .txt report per submission to")
+	parseFlags(args)
+
+	if *submissions == "" {
+		log.Fatal("grade needs -submissions")
+	}
+
+	if *format != "table" && *format != "csv" && *format != "json" {
+		log.Fatalf("invalid format: %s", *format)
+	}
+
+	entries, err := os.ReadDir(*submissions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *reports != "" {
+		if err := os.MkdirAll(*reports, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var results []submissionResult
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		result := gradeSubmission(filepath.Join(*submissions, entry.Name()), *tests)
+		results = append(results, result)
+
+		if *reports != "" {
+			if err := os.WriteFile(filepath.Join(*reports, entry.Name()+".txt"), []byte(submissionReport(result)), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	header := []string{"submission", "translated", "errors", "warnings", "rom", "tests passed", "tests"}
+	var rows [][]string
+
+	for _, result := range results {
+		translated := "no"
+		if result.Translated {
+			translated = "yes"
+		}
+
+		rows = append(rows, []string{
+			result.Submission,
+			translated,
+			strconv.Itoa(len(result.Errors)),
+			strconv.Itoa(len(result.Warnings)),
+			strconv.Itoa(result.ROMSize),
+			strconv.Itoa(result.testsPassed()),
+			strconv.Itoa(len(result.Tests)),
+		})
+	}
+
+	if *format == "table" {
+		fmt.Print(reportTable(header, rows, false))
+		return
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write(header)
+	writer.WriteAll(rows)
+
+	if err := writer.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"liggi-go-hack-vm-translator/translatorpb"
+)
+
+// The Translator service of translator.proto, answered as serve answers HTTP
+// requests
+type grpcTranslator struct {
+	translatorpb.UnimplementedTranslatorServer
+}
+
+func newDiagnosticMessage(d diagnostic) *translatorpb.Diagnostic {
+	return &translatorpb.Diagnostic{File: d.File, Line: int32(d.Line), Message: d.Message}
+}
+
+func newCommandMessage(c Command) *translatorpb.Command {
+	return &translatorpb.Command{
+		Kind:       c.Kind,
+		Args:       c.Args,
+		File:       c.File,
+		Line:       int32(c.Line),
+		Source:     c.Source,
+		StaticBase: int32(c.StaticBase),
+		Synthetic:  c.Synthetic,
+	}
+}
+
+// The asm, or a diagnostic saying why there's none
+func (grpcTranslator) Translate(ctx context.Context, source *translatorpb.Source) (*translatorpb.Translation, error) {
+	var instructions []string
+	err := withRequestSource(source.Name, source.Contents, func(path string) error {
+		var err error
+		instructions, err = translationService{confined: true}.Translate(path)
+		return err
+	})
+
+	if err != nil {
+		return &translatorpb.Translation{Diagnostics: []*translatorpb.Diagnostic{newDiagnosticMessage(newDiagnostic(err))}}, nil
+	}
+
+	return &translatorpb.Translation{Asm: strings.Join(instructions, "")}, nil
+}
+
+// Sends each problem as it's found, then any that stopped the check
+func (grpcTranslator) Check(source *translatorpb.Source, stream translatorpb.Translator_CheckServer) error {
+	err := withRequestSource(source.Name, source.Contents, func(path string) error {
+		return translationService{confined: true}.Check(path, func(d diagnostic) error {
+			return stream.Send(newDiagnosticMessage(d))
+		})
+	})
+
+	if err != nil && stream.Context().Err() == nil {
+		return stream.Send(newDiagnosticMessage(newDiagnostic(err)))
+	}
+
+	return err
+}
+
+// Sends the commands one by one. Source that doesn't parse is an invalid
+// argument
+func (grpcTranslator) EmitIR(source *translatorpb.Source, stream translatorpb.Translator_EmitIRServer) error {
+	err := withRequestSource(source.Name, source.Contents, func(path string) error {
+		return translationService{confined: true}.EmitIR(path, func(command Command) error {
+			return stream.Send(newCommandMessage(command))
+		})
+	})
+
+	if err != nil && stream.Context().Err() == nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return err
+}
+
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxRequestSize))
+	translatorpb.RegisterTranslatorServer(server, grpcTranslator{})
+
+	return server
+}
+
+// Serves the Translator service on address in the background
+func serveGRPC(address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("serving gRPC on %s", address)
+
+	go func() {
+		log.Fatal(newGRPCServer().Serve(listener))
+	}()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"liggi-go-hack-vm-translator/translatorpb"
+)
+
+// A client of the Translator service, served in memory for the test
+func newTestTranslatorClient(t *testing.T) translatorpb.TranslatorClient {
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dial := func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}
+
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return translatorpb.NewTranslatorClient(conn)
+}
+
+func TestGRPCTranslate(t *testing.T) {
+	client := newTestTranslatorClient(t)
+
+	translated, err := client.Translate(context.Background(), &translatorpb.Source{Name: "Main.vm", Contents: []byte("push constant 7\npush constant 8\nadd\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(translated.Diagnostics) != 0 || !strings.Contains(translated.Asm, "@8\n") {
+		t.Errorf("got %v, want the asm of the source", translated)
+	}
+
+	translated, err = client.Translate(context.Background(), &translatorpb.Source{Name: "Main.vm", Contents: []byte("push constant 7\npop constant 8\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(translated.Diagnostics) != 1 || translated.Diagnostics[0].Line != 2 || translated.Asm != "" {
+		t.Errorf("got %v, want a diagnostic for line 2", translated)
+	}
+}
+
+func TestGRPCCheck(t *testing.T) {
+	client := newTestTranslatorClient(t)
+
+	stream, err := client.Check(context.Background(), &translatorpb.Source{Name: "Main.vm", Contents: []byte("push constant 7\nfoo\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diagnostics []*translatorpb.Diagnostic
+	for {
+		diagnostic, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	if len(diagnostics) != 1 || diagnostics[0].File != "Main.vm" || diagnostics[0].Line != 2 {
+		t.Errorf("got %v, want a diagnostic for Main.vm:2", diagnostics)
+	}
+}
+
+func TestGRPCEmitIR(t *testing.T) {
+	client := newTestTranslatorClient(t)
+
+	stream, err := client.EmitIR(context.Background(), &translatorpb.Source{Name: "Main.vm", Contents: []byte("push constant 7\npop local 0\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commands []string
+	for {
+		command, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commands = append(commands, command.Kind+" "+strings.Join(command.Args, " "))
+	}
+
+	if strings.Join(commands, "\n") != "push constant 7\npop local 0" {
+		t.Errorf("got %q", commands)
+	}
+
+	stream, err = client.EmitIR(context.Background(), &translatorpb.Source{Name: "Main.vm", Contents: []byte("push\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultPointerGuardHandler = "POINTER_FAULT"
+
+// Checks SP stays between the stack base and the heap after commands that
+// grow or shrink the stack
+func stackGuard(kind string) string {
+	var lines []string
+
+	switch kind {
+	case "push", "cached-push", "shared-push", "function":
+		lines = []string{
+			"@SP",
+			"D=M",
+			fmt.Sprintf("@%d", memory.Heap),
+			"D=D-A",
+			"@STACK_OVERFLOW",
+			"D;JGE",
+		}
+
+	case "pop", "cached-pop", "shared-pop", "add", "sub", "and", "or", "eq", "gt", "lt", "shl", "shr",
+		"mult", "div", "mod", "min", "max", "if-goto", "if-eq-goto", "if-gt-goto", "if-lt-goto":
+		lines = []string{
+			"@SP",
+			"D=M",
+			fmt.Sprintf("@%d", memory.Stack),
+			"D=D-A",
+			"@STACK_UNDERFLOW",
+			"D;JLT",
+		}
+
+	default:
+		return ""
+	}
+
+	return joinLines(lines)
+}
+
+// The guards trap by spinning on a well-known label, which is easy to spot
+// in the emulator
+func createStackTraps() []string {
+	traps := []string{}
+
+	for _, trap := range []string{"STACK_OVERFLOW", "STACK_UNDERFLOW"} {
+		traps = append(traps, strings.Join([]string{
+			fmt.Sprintf("(%s)", trap),
+			fmt.Sprintf("@%s", trap),
+			"0;JMP",
+		}, "\n")+"\n")
+	}
+
+	return traps
+}
+
+// Checks THIS/THAT holds a usable address before a this/that access. Zero
+// (null) and anything past the keyboard traps
+func pointerGuard(command Command) string {
+	if len(command.Args) == 0 {
+		return ""
+	}
+
+	var pointer string
+
+	switch command.Kind {
+	case "push", "pop", "cached-push", "cached-pop", "shared-push", "shared-pop":
+		switch command.Args[0] {
+		case "this":
+			pointer = "@THIS"
+		case "that":
+			pointer = "@THAT"
+		default:
+			return ""
+		}
+
+	default:
+		return ""
+	}
+
+	lines := []string{
+		pointer,
+		"D=M",
+		fmt.Sprintf("@%s", pointerGuardHandler),
+		"D;JLE",
+		fmt.Sprintf("@%d", memory.Keyboard),
+		"D=D-A",
+		fmt.Sprintf("@%s", pointerGuardHandler),
+		"D;JGT",
+	}
+
+	return joinLines(lines)
+}
+
+func createPointerTrap() []string {
+	trap := strings.Join([]string{
+		fmt.Sprintf("(%s)", defaultPointerGuardHandler),
+		fmt.Sprintf("@%s", defaultPointerGuardHandler),
+		"0;JMP",
+	}, "\n") + "\n"
+
+	return []string{trap}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What a project's checks came to, by check, plus why any failed
+type projectResult struct {
+	name     string
+	checks   map[string]string
+	failures []string
+}
+
+var harnessChecks = []string{"tst", "cmp", "golden"}
+
+// Asks before rewriting golden files that don't match the translation, or
+// rewrites them all when told to
+type goldenUpdate struct {
+	all bool
+	in  *bufio.Reader
+}
+
+func (u *goldenUpdate) approve(fileName string, diff string) bool {
+	fmt.Print(diff)
+
+	if u.all {
+		return true
+	}
+
+	fmt.Printf("update %s? [y/N] ", fileName)
+
+	answer, _ := u.in.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// Every folder under root holding .vm files
+func findProjects(root string) ([]string, error) {
+	var projects []string
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if files, err := vmFiles(path); err == nil && len(files) > 0 {
+				projects = append(projects, path)
+			}
+		}
+
+		return nil
+	})
+
+	return projects, err
+}
+
+// Translates, assembles and runs the project with the stack and segment bases
+// a test would set, checking the RAM against its .cmp
+func checkProjectCmp(folder string, name string, cycles int) ([]string, error) {
+	pathToTranslate = folder
+
+	instructions, err := loadFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	rom, err := assemble(asmLines(instructions))
+	if err != nil {
+		return nil, err
+	}
+
+	cpu := newHackCPU(rom)
+	cpu.ram[0] = int16(memory.Stack)
+
+	if !shouldStandardBootstrap && !shouldBootstrap {
+		for i, segment := range []string{"local", "argument", "this", "that"} {
+			cpu.ram[i+1] = int16(testSegmentBases[segment])
+		}
+	}
+
+	for !cpu.halted && cpu.cycles < cycles {
+		cpu.step()
+	}
+
+	return checkCmpFile(filepath.Join(folder, name+".cmp"), cpu.ram)
+}
+
+// Runs every check the project has files for. Projects with a Sys.init get
+// the standard bootstrap unless the flags ask for one already. With an
+// update, golden files that are missing or out of date can be rewritten
+func checkProject(folder string, cycles int, update *goldenUpdate) projectResult {
+	name := filepath.Base(folder)
+	result := projectResult{name: folder, checks: map[string]string{}}
+
+	fail := func(check string, reason string) {
+		result.checks[check] = "FAIL"
+		result.failures = append(result.failures, fmt.Sprintf("%s %s: %s", folder, check, reason))
+	}
+
+	files, _ := vmFiles(folder)
+	commands, err := parseFiles(files)
+	if err != nil {
+		for _, check := range harnessChecks {
+			fail(check, err.Error())
+		}
+
+		return result
+	}
+
+	standard := shouldStandardBootstrap
+	defer func() { shouldStandardBootstrap = standard }()
+
+	if _, ok := collectFunctions(commands)["Sys.init"]; ok && !shouldBootstrap {
+		shouldStandardBootstrap = true
+	}
+
+	scripts, _ := filepath.Glob(filepath.Join(folder, "*.tst"))
+	if len(scripts) > 0 {
+		result.checks["tst"] = "ok"
+	}
+
+	for _, script := range scripts {
+		if err := runTestScript(script); err != nil {
+			fail("tst", err.Error())
+		}
+	}
+
+	// A .cmp the scripts don't already use
+	if _, err := os.Stat(filepath.Join(folder, name+".cmp")); err == nil && len(scripts) == 0 {
+		result.checks["cmp"] = "ok"
+
+		mismatches, err := checkProjectCmp(folder, name, cycles)
+		if err != nil {
+			fail("cmp", err.Error())
+		}
+
+		for _, mismatch := range mismatches {
+			fail("cmp", mismatch)
+		}
+	}
+
+	goldenPath := filepath.Join(folder, name+".golden.asm")
+	golden, err := os.ReadFile(goldenPath)
+	if err == nil || update != nil {
+		result.checks["golden"] = "ok"
+		pathToTranslate = folder
+
+		instructions, err := loadFolder(folder)
+		if err != nil {
+			fail("golden", err.Error())
+			return result
+		}
+
+		asm := strings.Join(instructions, "")
+		difference := compareAsm(string(golden), strings.Join(asmLines(instructions), "\n"))
+		if difference == "" {
+			return result
+		}
+
+		diff := unifiedDiff(splitLines(string(golden)), splitLines(asm), goldenPath, "translation")
+
+		if update == nil || !update.approve(goldenPath, diff) {
+			fail("golden", difference+"\n"+diff)
+			return result
+		}
+
+		if err := os.WriteFile(goldenPath, []byte(withLineEndings(asm)), 0644); err != nil {
+			fail("golden", err.Error())
+			return result
+		}
+
+		result.checks["golden"] = "updated"
+	}
+
+	return result
+}
+
+// Checks each project, printing a pass/fail matrix with the projects named
+// relative to root, then why any failed. Returns whether they all passed
+func checkProjects(root string, projects []string, cycles int, update *goldenUpdate) bool {
+	header := append([]string{"project"}, harnessChecks...)
+	var rows [][]string
+	var failures []string
+
+	for _, project := range projects {
+		result := checkProject(project, cycles, update)
+
+		name, err := filepath.Rel(root, result.name)
+		if err != nil {
+			name = result.name
+		}
+
+		row := []string{name}
+		for _, check := range harnessChecks {
+			status := result.checks[check]
+			if status == "" {
+				status = "-"
+			}
+
+			row = append(row, status)
+		}
+
+		rows = append(rows, row)
+		failures = append(failures, result.failures...)
+	}
+
+	fmt.Print(reportTable(header, rows, false))
+
+	if len(failures) > 0 {
+		fmt.Println()
+		for _, failure := range failures {
+			fmt.Println(failure)
+		}
+	}
+
+	return len(failures) == 0
+}
+
+// Translates and runs every project under a folder, checking each against its
+// test scripts, .cmp and golden .asm, and prints a pass/fail matrix
+func testCommand(args []string) {
+	cycles := flag.Int("cycles", 1000000, "clock cycles to run a project checked against a bare .cmp for")
+	updateGolden := flag.Bool("update-golden", false, "offer to rewrite golden files that are missing or don't match, showing the diff")
+	approveAll := flag.Bool("yes", false, "with -update-golden, rewrite them all without asking")
+	parseFlags(args)
+
+	var update *goldenUpdate
+	if *updateGolden {
+		update = &goldenUpdate{all: *approveAll, in: bufio.NewReader(os.Stdin)}
+	}
+
+	root := flag.Arg(0)
+	if root == "" {
+		root = pathToTranslate
+	}
+
+	if root == "" {
+		log.Fatal("test needs a folder of projects")
+	}
+
+	projects, err := findProjects(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(projects) == 0 {
+		log.Fatalf("no projects found under %s", root)
+	}
+
+	if !checkProjects(root, projects, *cycles, update) {
+		os.Exit(1)
+	}
+}
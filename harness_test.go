@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden .asm under testdata that's missing or doesn't match, showing the diff")
+
+// Checks each project under testdata against its .cmp and its golden .asm,
+// as the test subcommand would
+func TestProjects(t *testing.T) {
+	projects, err := findProjects("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var update *goldenUpdate
+	if *updateGolden {
+		update = &goldenUpdate{all: true}
+	}
+
+	for _, project := range projects {
+		project := project
+
+		t.Run(filepath.Base(project), func(t *testing.T) {
+			resetTranslation()
+
+			result := checkProject(project, 1000000, update)
+			for _, failure := range result.failures {
+				t.Error(failure)
+			}
+
+			if result.checks["golden"] == "" {
+				t.Errorf("%s has no golden .asm, go test -update-golden writes one", project)
+			}
+		})
+	}
+}
+
+// The optimizer settings the projects' .cmp is checked at as well, so what
+// its rewrites translate to is run. The golden .asm is of the defaults only
+var optimizedChecks = []struct {
+	name  string
+	level int
+	favor string
+}{
+	{"O1", 1, "speed"},
+	{"O2", 2, "speed"},
+	{"size", 0, "size"},
+}
+
+func TestProjectsOptimized(t *testing.T) {
+	projects, err := findProjects("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level, favor := optimizationLevel, favorMode
+	defer func() { optimizationLevel, favorMode = level, favor }()
+
+	for _, check := range optimizedChecks {
+		optimizationLevel, favorMode = check.level, check.favor
+
+		for _, project := range projects {
+			project := project
+
+			t.Run(check.name+"/"+filepath.Base(project), func(t *testing.T) {
+				resetTranslation()
+
+				result := checkProject(project, 1000000, nil)
+				for _, failure := range result.failures {
+					if !strings.HasPrefix(failure, fmt.Sprintf("%s golden: ", project)) {
+						t.Error(failure)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Shell commands run before a translation, e.g. a Jack compiler, and after
+// it, e.g. the assembler
+var preHooks []string
+var postHooks []string
+
+// Runs each hook in turn, stopping at the first that fails. Hooks see the
+// path being translated as $VM_PATH and the asm written for it as $VM_ASM
+func runHooks(hooks []string, asmPath string) error {
+	for _, hook := range hooks {
+		cmd := exec.Command("sh", "-c", hook)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), "VM_PATH="+pathToTranslate, "VM_ASM="+asmPath)
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("hook %s: %w", hook, err)
+		}
+	}
+
+	return nil
+}
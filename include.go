@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The files in a program, by absolute path: those it was given and those
+// they've included. A file is only ever in a program once, so a library any
+// number of files include is only pulled in the first time
+type includeSet map[string]bool
+
+// The folder includes must stay within, or "" for anywhere
+var includeRoot string
+
+func absolutePath(fileName string) string {
+	if abs, err := filepath.Abs(fileName); err == nil {
+		return abs
+	}
+
+	return filepath.Clean(fileName)
+}
+
+// Whether the file is in the folder, or a folder under it
+func isWithin(folder string, file string) bool {
+	rel, err := filepath.Rel(absolutePath(folder), file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func newIncludeSet(files []string) includeSet {
+	included := includeSet{}
+	for _, file := range files {
+		included[absolutePath(file)] = true
+	}
+
+	return included
+}
+
+func (included includeSet) parseFiles(files []string) ([]Command, error) {
+	commands := []Command{}
+
+	for _, file := range files {
+		fileCommands, err := included.parseFile(file, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		commands = append(commands, fileCommands...)
+	}
+
+	return commands, nil
+}
+
+// Parses a file, with `include "Path/File.vm"` replaced by the commands of
+// the file it names, relative to the including file. The commands keep the
+// name of the file they're from, so an included file's statics are its own.
+// including is the chain of files that led to this one, for finding cycles
+func (included includeSet) parseFile(fileName string, including []string) ([]Command, error) {
+	commands, err := parseSourceFile(fileName)
+	if err != nil || !hasIncludes(commands) {
+		return commands, err
+	}
+
+	including = append(including[:len(including):len(including)], absolutePath(fileName))
+
+	var expanded []Command
+
+	for _, command := range commands {
+		if command.Kind != "include" {
+			expanded = append(expanded, command)
+			continue
+		}
+
+		target := filepath.Join(filepath.Dir(fileName), filepath.FromSlash(strings.Trim(command.Args[0], `"`)))
+		abs := absolutePath(target)
+
+		if includeRoot != "" && !isWithin(includeRoot, abs) {
+			return nil, fmt.Errorf("%s:%d: include outside the folder translated: %s", command.File, command.Line, command.Args[0])
+		}
+
+		for i, file := range including {
+			if file == abs {
+				var chain []string
+				for _, file := range append(including[i:], abs) {
+					chain = append(chain, filepath.Base(file))
+				}
+
+				return nil, fmt.Errorf("%s:%d: include cycle: %s", command.File, command.Line, strings.Join(chain, " -> "))
+			}
+		}
+
+		if included[abs] {
+			continue
+		}
+
+		included[abs] = true
+
+		includedCommands, err := included.parseFile(target, including)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
+
+		expanded = append(expanded, includedCommands...)
+	}
+
+	return expanded, nil
+}
+
+func hasIncludes(commands []Command) bool {
+	for _, command := range commands {
+		if command.Kind == "include" {
+			return true
+		}
+	}
+
+	return false
+}
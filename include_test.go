@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes the files, by name, into a new folder under the test's, returning
+// the folder
+func writeFiles(t *testing.T, files map[string]string) string {
+	folder := filepath.Join(t.TempDir(), "Program")
+
+	for name, contents := range files {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return folder
+}
+
+// Parses the files given, in the extended dialect
+func parseIncluding(t *testing.T, folder string, names ...string) ([]Command, error) {
+	language := dialect
+	dialect = "extended"
+	defer func() { dialect = language }()
+
+	var files []string
+	for _, name := range names {
+		files = append(files, filepath.Join(folder, name))
+	}
+
+	return parseFiles(files)
+}
+
+func TestIncludeCycle(t *testing.T) {
+	folder := writeFiles(t, map[string]string{
+		"Main.vm":  "include \"Lib/A.vm\"\n",
+		"Lib/A.vm": "include \"B.vm\"\npush constant 1\n",
+		"Lib/B.vm": "include \"A.vm\"\npush constant 2\n",
+	})
+
+	_, err := parseIncluding(t, folder, "Main.vm")
+	if err == nil || !strings.Contains(err.Error(), "include cycle: A.vm -> B.vm -> A.vm") {
+		t.Errorf("got %v, want an include cycle", err)
+	}
+}
+
+// A file included by more than one, or given as well, is only in once, and
+// its commands keep its name for its statics
+func TestIncludeOnce(t *testing.T) {
+	folder := writeFiles(t, map[string]string{
+		"Main.vm":     "include \"Lib/Math.vm\"\ninclude \"Lib/Math.vm\"\npush static 0\n",
+		"Other.vm":    "include \"Lib/Math.vm\"\npush static 0\n",
+		"Lib/Math.vm": "push static 0\n",
+	})
+
+	commands, err := parseIncluding(t, folder, "Main.vm", "Other.vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	translator := newTranslator()
+	var statics []string
+
+	for _, command := range commands {
+		translator.CurrentFile = command.File
+
+		asm, err := translator.translateCommand(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		statics = append(statics, strings.SplitN(asm, "\n", 2)[0])
+	}
+
+	if got, want := strings.Join(statics, " "), "@Math.vm.0 @Main.vm.0 @Other.vm.0"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// Source sent with a request can't include files outside its folder. Given
+// on disk, it can
+func TestIncludeConfined(t *testing.T) {
+	folder := writeFiles(t, map[string]string{
+		"Main.vm": "include \"../Outside.vm\"\npush constant 1\n",
+	})
+
+	if err := os.WriteFile(filepath.Join(filepath.Dir(folder), "Outside.vm"), []byte("push constant 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	language := dialect
+	dialect = "extended"
+	defer func() { dialect = language }()
+
+	_, err := translationService{confined: true}.Translate(folder)
+	if err == nil || !strings.Contains(err.Error(), "Main.vm:1: include outside the folder translated") {
+		t.Errorf("got %v, want the include refused", err)
+	}
+
+	if _, err := (translationService{}).Translate(folder); err != nil {
+		t.Error(err)
+	}
+}
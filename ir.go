@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// Prints the command stream as JSON for external tools. With -O the stream is
+// the one after the optimisation passes, internal kinds included
+func irCommand(args []string) {
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err = optimize(commands)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(commands); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Adds the OS classes (Math.vm, Memory.vm, ...) from the folder whose
+// functions the program calls without defining, along with the ones those
+// call in turn. Classes the program defines itself are left alone
+func includeOS(commands []Command, folder string) ([]Command, error) {
+	included := map[string]bool{}
+	for _, command := range commands {
+		included[filepath.Base(command.File)] = true
+	}
+
+	for {
+		var missing []string
+		defined := definedFunctions(commands)
+
+		for name, dependencies := range dependencyGraph(commands) {
+			if len(dependencies.calledBy) > 0 && !defined[name] {
+				missing = append(missing, name)
+			}
+		}
+
+		// The bootstrap calls the entry function without any command doing so
+		if (shouldBootstrap || shouldStandardBootstrap) && !defined[entryFunction] {
+			missing = append(missing, entryFunction)
+		}
+
+		// Map order would make the output differ from run to run
+		sort.Strings(missing)
+
+		added := false
+
+		for _, name := range missing {
+			file := strings.Split(name, ".")[0] + ".vm"
+			if included[file] {
+				continue
+			}
+
+			included[file] = true
+
+			if _, err := os.Stat(filepath.Join(folder, file)); err != nil {
+				continue
+			}
+
+			classCommands, err := parseFile(filepath.Join(folder, file))
+			if err != nil {
+				return nil, err
+			}
+
+			commands = append(commands, classCommands...)
+			added = true
+		}
+
+		if !added {
+			return commands, nil
+		}
+	}
+}
+
+// The functions the commands define
+func definedFunctions(commands []Command) map[string]bool {
+	defined := map[string]bool{}
+
+	for _, command := range commands {
+		if command.Kind == "function" && len(command.Args) > 0 {
+			defined[command.Args[0]] = true
+		}
+	}
+
+	return defined
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// How many chunks of a program, e.g. its files or functions, are worked on at
+// once
+var translationJobs = 1
+
+// Splits commands where the file they come from changes
+func fileChunks(commands []Command) [][]Command {
+	var chunks [][]Command
+
+	start := 0
+	for i := 1; i <= len(commands); i++ {
+		if i == len(commands) || commands[i].File != commands[start].File {
+			chunks = append(chunks, commands[start:i])
+			start = i
+		}
+	}
+
+	return chunks
+}
+
+// Splits commands where functions begin. Those before the first function
+// are a chunk of their own
+func functionChunks(commands []Command) [][]Command {
+	var chunks [][]Command
+
+	start := 0
+	for i := 1; i <= len(commands); i++ {
+		if i == len(commands) || commands[i].Kind == "function" {
+			chunks = append(chunks, commands[start:i])
+			start = i
+		}
+	}
+
+	return chunks
+}
+
+// Runs passes that only look within a function over each function in turn,
+// the functions on up to translationJobs goroutines. The results are put
+// back together in program order
+func runPerFunction(commands []Command, passes ...func([]Command) []Command) []Command {
+	run := func(chunk []Command) []Command {
+		for _, pass := range passes {
+			chunk = pass(chunk)
+		}
+
+		return chunk
+	}
+
+	if translationJobs == 1 {
+		return run(commands)
+	}
+
+	chunks := functionChunks(commands)
+	results := make([][]Command, len(chunks))
+
+	next := make(chan int)
+	var workers sync.WaitGroup
+
+	for i := 0; i < translationJobs; i++ {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+
+			for chunk := range next {
+				results[chunk] = run(chunks[chunk])
+			}
+		}()
+	}
+
+	for chunk := range chunks {
+		next <- chunk
+	}
+	close(next)
+	workers.Wait()
+
+	joined := make([]Command, 0, len(commands))
+	for _, result := range results {
+		joined = append(joined, result...)
+	}
+
+	return joined
+}
+
+// A translator to go on from here, apart from this one
+func (t *translator) fork() *translator {
+	return &translator{translatorState: t.translatorState.clone()}
+}
+
+// Moves on past a command as translating it would, without the asm. Invalid
+// commands are passed over, as their translation fails anyway
+func (t *translator) skip(c Command) {
+	t.CurrentFile = c.File
+	t.StaticBase = c.StaticBase
+	t.PrettyFile = c.File
+	t.Source = c.Source
+
+	switch c.Kind {
+	case "function":
+		if len(c.Args) == 2 {
+			t.FuncStack.Current = c.Args[0]
+		}
+
+	case "call":
+		t.FuncStack.ReturnCounter++
+
+	case "return-leaf":
+		t.LeafReturnCount++
+
+	case "eq":
+		t.EqCount++
+
+	case "gt":
+		t.GtCount++
+
+	case "lt":
+		t.LtCount++
+
+	case "shl":
+		t.ShlCount++
+
+	case "shr":
+		t.ShrCount++
+
+	case "mult":
+		t.MultCount++
+
+	case "div":
+		t.DivCount++
+
+	case "mod":
+		t.ModCount++
+
+	case "min", "max", "abs":
+		t.BranchCount++
+
+	case "shared-push", "shared-pop":
+		t.SharedRoutines[Command{Kind: strings.TrimPrefix(c.Kind, "shared-"), Args: c.Args}.String()] = true
+		t.SharedCount++
+
+	case "push", "pop":
+		if len(c.Args) == 2 && c.Args[0] == "string" {
+			t.pushString(c.Args[1])
+		}
+
+		if len(c.Args) == 2 && c.Args[0] == "static" {
+			if index, err := strconv.Atoi(c.Args[1]); err == nil {
+				t.staticSymbol(index)
+			}
+		}
+	}
+}
+
+type chunkTranslation struct {
+	asm *asmSink
+	err error
+}
+
+// Translates the chunks on up to translationJobs goroutines, each with a
+// translator of its own. Each starts from where the chunks before it leave
+// off, found by skipping through them, so the asm comes out as it would a
+// chunk at a time. It's written in order, with no more chunks held back
+// than there are jobs
+func translateConcurrently(out *asmSink, chunks [][]Command) error {
+	if len(chunks) < 2 {
+		for _, chunk := range chunks {
+			if err := translation.translateTo(out, chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	translators := make([]*translator, len(chunks))
+	for i, chunk := range chunks {
+		translators[i] = translation.fork()
+
+		for _, command := range chunk {
+			translation.skip(command)
+		}
+	}
+
+	results := make([]chan chunkTranslation, len(chunks))
+	for i := range results {
+		results[i] = make(chan chunkTranslation, 1)
+	}
+
+	slots := make(chan struct{}, translationJobs)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i, chunk := range chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(i int, chunk []Command) {
+				asm := collectAsm()
+				err := translators[i].translateTo(asm, chunk)
+				results[i] <- chunkTranslation{asm, err}
+			}(i, chunk)
+		}
+	}()
+
+	for i, result := range results {
+		translated := <-result
+		<-slots
+
+		if translated.err != nil {
+			return translated.err
+		}
+
+		translation.translatedCommands = append(translation.translatedCommands, translators[i].translatedCommands...)
+
+		if err := out.release(translated.asm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// The codes the Hack keyboard gives the keys that aren't characters
+var keyNames = map[string]int16{
+	"newline": 128, "backspace": 129, "left": 130, "up": 131, "right": 132, "down": 133,
+	"home": 134, "end": 135, "pageup": 136, "pagedown": 137, "insert": 138, "delete": 139,
+	"esc": 140, "space": 32,
+	"f1": 141, "f2": 142, "f3": 143, "f4": 144, "f5": 145, "f6": 146,
+	"f7": 147, "f8": 148, "f9": 149, "f10": 150, "f11": 151, "f12": 152,
+}
+
+// A key code, a key name such as left or a single character
+func parseKey(key string) (int16, error) {
+	if code, ok := keyNames[strings.ToLower(key)]; ok {
+		return code, nil
+	}
+
+	if code, err := strconv.Atoi(key); err == nil && code >= 0 && code <= 32767 {
+		return int16(code), nil
+	}
+
+	if runes := []rune(key); len(runes) == 1 && runes[0] < 128 {
+		return int16(runes[0]), nil
+	}
+
+	return 0, fmt.Errorf("invalid key: %s", key)
+}
+
+// A key held down, or none for 0, for a number of cycles
+type keyEvent struct {
+	key    int16
+	cycles int
+}
+
+// Parses a keyboard script, a line per event:
+//
+//	press KEY for N cycles
+//	wait N cycles
+//
+// where the word cycles is optional and // starts a comment
+func parseKeyScript(fileName string, text string) ([]keyEvent, error) {
+	var events []keyEvent
+
+	for i, line := range strings.Split(text, "\n") {
+		words := strings.Fields(strings.Split(line, "//")[0])
+		if len(words) == 0 {
+			continue
+		}
+
+		if words[len(words)-1] == "cycles" || words[len(words)-1] == "cycle" {
+			words = words[:len(words)-1]
+		}
+
+		fail := func(reason string) error {
+			return fmt.Errorf("%s:%d: %s", fileName, i+1, reason)
+		}
+
+		var event keyEvent
+		var duration string
+
+		switch {
+		case words[0] == "press" && len(words) == 4 && words[2] == "for":
+			key, err := parseKey(words[1])
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+
+			event.key, duration = key, words[3]
+
+		case words[0] == "wait" && len(words) == 2:
+			duration = words[1]
+
+		default:
+			return nil, fail("expected press KEY for N cycles or wait N cycles")
+		}
+
+		cycles, err := strconv.Atoi(duration)
+		if err != nil || cycles < 0 {
+			return nil, fail("invalid cycle count: " + duration)
+		}
+
+		event.cycles = cycles
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Something pressing keys on the keyboard memory map as the CPU runs
+type keyFeed interface {
+	update(cpu *hackCPU)
+}
+
+// Plays a keyboard script into the keyboard memory map as the CPU runs
+type keyScript struct {
+	events  []keyEvent
+	current int
+	// The cycle the current event ends on
+	ends int
+}
+
+func loadKeyScript(fileName string) (*keyScript, error) {
+	text, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := parseKeyScript(fileName, string(text))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &keyScript{events: events}
+	if len(events) > 0 {
+		s.ends = events[0].cycles
+	}
+
+	return s, nil
+}
+
+// Sets the keyboard to whatever the script has pressed at the CPU's cycle.
+// Once the script runs out no key is pressed
+func (s *keyScript) update(cpu *hackCPU) {
+	for s.current < len(s.events) && cpu.cycles >= s.ends {
+		s.current++
+
+		if s.current < len(s.events) {
+			s.ends += s.events[s.current].cycles
+		}
+	}
+
+	key := int16(0)
+	if s.current < len(s.events) {
+		key = s.events[s.current].key
+	}
+
+	cpu.ram[memory.Keyboard] = key
+}
+
+// Feeds the keys typed at the terminal into the keyboard memory map, each
+// held for a number of cycles as a terminal only says when a key goes down
+type liveKeyboard struct {
+	keys  chan int16
+	hold  int
+	key   int16
+	ends  int
+	reset func()
+}
+
+// Puts the terminal into cbreak mode, so keys arrive as they're typed, and
+// starts reading them
+func newLiveKeyboard(hold int) (*liveKeyboard, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("can't read keys from the terminal: %w", err)
+	}
+
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return nil, fmt.Errorf("can't read keys from the terminal: %w", err)
+	}
+
+	k := &liveKeyboard{keys: make(chan int16, 16), hold: hold}
+	k.reset = func() { stty(strings.TrimSpace(saved)) }
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	go func() {
+		<-interrupts
+		k.reset()
+		os.Exit(130)
+	}()
+
+	go k.read(bufio.NewReader(os.Stdin))
+
+	return k, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// The codes of the arrow keys' escape sequences, by their last byte
+var arrowKeys = map[byte]int16{'A': 131, 'B': 133, 'C': 132, 'D': 130, 'H': 134, 'F': 135}
+
+func (k *liveKeyboard) read(in *bufio.Reader) {
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			close(k.keys)
+			return
+		}
+
+		switch {
+		case b == '\n' || b == '\r':
+			k.keys <- 128
+
+		case b == 127 || b == 8:
+			k.keys <- 129
+
+		// An escape on its own, or the start of an arrow key's sequence
+		case b == 27:
+			if in.Buffered() == 0 {
+				k.keys <- 140
+				continue
+			}
+
+			sequence, _ := in.Peek(2)
+			if len(sequence) == 2 && sequence[0] == '[' {
+				if key, ok := arrowKeys[sequence[1]]; ok {
+					in.Discard(2)
+					k.keys <- key
+				}
+			}
+
+		case b < 128:
+			k.keys <- int16(b)
+		}
+	}
+}
+
+// Presses the last key typed until it's been held long enough
+func (k *liveKeyboard) update(cpu *hackCPU) {
+	select {
+	case key, ok := <-k.keys:
+		if ok {
+			k.key, k.ends = key, cpu.cycles+k.hold
+		}
+
+	default:
+	}
+
+	if cpu.cycles >= k.ends {
+		k.key = 0
+	}
+
+	cpu.ram[memory.Keyboard] = k.key
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A flag that can be given more than once, e.g. `-l Math -l Memory`
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Looks for the library in each folder of the path in turn. Libraries in .vm
+// form are translated on the way
+func findLibrary(name string, libraryPath []string) ([]asmObject, error) {
+	for _, folder := range libraryPath {
+		base := filepath.Join(folder, name)
+
+		if _, err := os.Stat(base + ".asmobj"); err == nil {
+			return loadObjects([]string{base + ".asmobj"})
+		}
+
+		if _, err := os.Stat(base + ".vm"); err == nil {
+			object, err := translateObject(base + ".vm")
+			return []asmObject{object}, err
+		}
+
+		info, err := os.Stat(base)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		objects, err := filepath.Glob(filepath.Join(base, "*.asmobj"))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(objects) > 0 {
+			return loadObjects(objects)
+		}
+
+		sources, err := filepath.Glob(filepath.Join(base, "*.vm"))
+		if err != nil {
+			return nil, err
+		}
+
+		var translated []asmObject
+
+		for _, source := range sources {
+			object, err := translateObject(source)
+			if err != nil {
+				return nil, err
+			}
+
+			translated = append(translated, object)
+		}
+
+		if len(translated) > 0 {
+			return translated, nil
+		}
+	}
+
+	return nil, fmt.Errorf("library not found: %s", name)
+}
+
+// Function labels carry the name of the folder the object was translated in.
+// Renames them to carry the program's instead, so calls into a library built
+// elsewhere resolve
+func relocate(object asmObject, prefix string) asmObject {
+	if object.Prefix == "" || object.Prefix == prefix {
+		return object
+	}
+
+	renamed := map[string]string{}
+	for _, label := range append(append([]string{}, object.Functions...), object.External...) {
+		if strings.HasPrefix(label, object.Prefix+".") {
+			renamed[label] = prefix + "." + strings.TrimPrefix(label, object.Prefix+".")
+		}
+	}
+
+	rename := func(labels []string) []string {
+		result := make([]string, len(labels))
+		for i, label := range labels {
+			result[i] = label
+			if to, ok := renamed[label]; ok {
+				result[i] = to
+			}
+		}
+
+		return result
+	}
+
+	object.Functions = rename(object.Functions)
+	object.External = rename(object.External)
+	object.Instructions = append([]string{}, object.Instructions...)
+
+	for i, line := range object.Instructions {
+		if to, ok := renamed[strings.TrimPrefix(line, "@")]; ok && strings.HasPrefix(line, "@") {
+			object.Instructions[i] = "@" + to
+		} else if to, ok := renamed[strings.Trim(line, "()")]; ok && strings.HasPrefix(line, "(") {
+			object.Instructions[i] = "(" + to + ")"
+		}
+	}
+
+	object.Prefix = prefix
+
+	return object
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// How far a run may go before it's stopped, so a program stuck in a loop
+// can't hang whoever is running it. Zero means no limit
+type runLimits struct {
+	maxCycles int
+	timeout   time.Duration
+	deadline  time.Time
+}
+
+func newRunLimits(maxCycles int, timeout time.Duration) *runLimits {
+	l := &runLimits{maxCycles: maxCycles, timeout: timeout}
+	if timeout > 0 {
+		l.deadline = time.Now().Add(timeout)
+	}
+
+	return l
+}
+
+// Describes the limit a run has gone past after this many cycles or steps,
+// if any. The clock is only read every so often, as it's slow next to a step
+func (l *runLimits) exceeded(cycles int) string {
+	if l.maxCycles > 0 && cycles >= l.maxCycles {
+		return "cycle budget exceeded"
+	}
+
+	if l.timeout > 0 && cycles%4096 == 0 && time.Now().After(l.deadline) {
+		return fmt.Sprintf("timeout of %s exceeded", l.timeout)
+	}
+
+	return ""
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single .vm file's translation, without bootstrap, epilogue or shared
+// routines, waiting to be linked with the rest of the program
+type asmObject struct {
+	File string `json:"file"`
+	// The name of the folder it was translated in, which function labels start
+	// with
+	Prefix string `json:"prefix"`
+	// Function labels other objects may call
+	Functions []string `json:"functions"`
+	// Labels used here but defined elsewhere: other objects' functions and the
+	// shared routines
+	External     []string `json:"external"`
+	Instructions []string `json:"instructions"`
+}
+
+// Translates each file into its own .asmobj beside it. Labels other than the
+// function entries are prefixed with the file's name, so objects translated
+// at different times never clash
+func saveObjects() error {
+	if memory.Static >= 0 {
+		return fmt.Errorf("-static-base can't be used with -emit=asmobj, statics are numbered per translation")
+	}
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		return err
+	}
+
+	// Function labels carry the folder's name, even when a single file is
+	// rebuilt or the folder is given as `.`
+	folder := pathToTranslate
+	if filepath.Ext(folder) == ".vm" {
+		folder = filepath.Dir(folder)
+	}
+
+	folder, err = filepath.Abs(folder)
+	if err != nil {
+		return err
+	}
+
+	defer func(path string) { pathToTranslate = path }(pathToTranslate)
+	pathToTranslate = folder
+
+	for _, file := range files {
+		object, err := translateObject(file)
+		if err != nil {
+			return err
+		}
+
+		contents, err := json.MarshalIndent(object, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(file, ".vm") + ".asmobj"
+		if err := os.WriteFile(name, append(contents, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func translateObject(file string) (asmObject, error) {
+	commands, err := parseFile(file)
+	if err != nil {
+		return asmObject{}, err
+	}
+
+	commands, err = optimize(commands)
+	if err != nil {
+		return asmObject{}, err
+	}
+
+	translated, err := translate(commands)
+	if err != nil {
+		return asmObject{}, err
+	}
+
+	object := asmObject{
+		File:      filepath.Base(file),
+		Prefix:    getFolderName(),
+		Functions: []string{},
+		External:  []string{},
+	}
+
+	exported := map[string]bool{}
+	for _, command := range commands {
+		if command.Kind == "function" && len(command.Args) == 2 {
+			label := getFolderName() + "." + command.Args[0]
+			exported[label] = true
+			object.Functions = append(object.Functions, label)
+		}
+	}
+
+	lines := asmLines(translated)
+
+	defined := map[string]bool{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "(") {
+			defined[strings.Trim(line, "()")] = true
+		}
+	}
+
+	prefix := strings.TrimSuffix(object.File, ".vm") + "$"
+	statics := regexp.MustCompile("^" + regexp.QuoteMeta(object.File) + `\.(string\d+\.)?\d+$`)
+	external := map[string]bool{}
+
+	for _, line := range lines {
+		var label string
+		var format string
+
+		switch {
+		case strings.HasPrefix(line, "("):
+			label, format = strings.Trim(line, "()"), "(%s)"
+		case strings.HasPrefix(line, "@"):
+			label, format = line[1:], "@%s"
+		default:
+			object.Instructions = append(object.Instructions, line)
+			continue
+		}
+
+		if defined[label] && !exported[label] {
+			label = prefix + label
+		} else if !defined[label] && isSymbol(label) && !statics.MatchString(label) {
+			external[label] = true
+		}
+
+		object.Instructions = append(object.Instructions, fmt.Sprintf(format, label))
+	}
+
+	object.External = sortedKeys(external)
+
+	return object, nil
+}
+
+// Whether an A-instruction's operand is a symbol rather than a number or one
+// of the assembler's own
+func isSymbol(name string) bool {
+	if _, err := strconv.Atoi(name); err == nil {
+		return false
+	}
+
+	_, predefined := predefinedSymbols[name]
+
+	return !predefined
+}
+
+// Links the folder's .asmobj files into a single .asm, with one copy of the
+// bootstrap, epilogue and shared routines. The flags should match the ones the
+// objects were translated with
+func linkCommand(args []string) {
+	var libraryPath, libraries stringList
+	flag.Var(&libraryPath, "L", "folder to search for libraries (repeatable)")
+	flag.Var(&libraries, "l", "library to link against, found as NAME.asmobj, NAME.vm or a NAME folder of either in the -L folders (repeatable)")
+	parseFlags(args)
+
+	if pathToTranslate == "" {
+		log.Fatal("no folder specified")
+	}
+
+	// The folder's name is part of every function label
+	folder, err := filepath.Abs(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pathToTranslate = folder
+
+	files, err := filepath.Glob(filepath.Join(pathToTranslate, "*.asmobj"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		log.Fatal("no .asmobj files found in folder")
+	}
+
+	objects, err := loadObjects(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var libraryObjects []asmObject
+
+	for _, library := range libraries {
+		found, err := findLibrary(library, libraryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		libraryObjects = append(libraryObjects, found...)
+	}
+
+	instructions, err := link(objects, libraryObjects)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	save(instructions, asmOutputPath())
+
+	if shouldWriteDependencies {
+		if err := saveDependencies(asmOutputPath()); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func loadObjects(files []string) ([]asmObject, error) {
+	var objects []asmObject
+
+	for _, file := range files {
+		recordSourceFile(file)
+
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var object asmObject
+		if err := json.Unmarshal(contents, &object); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// Part of an object that's linked in or left out as a whole: a function, or
+// the code before the first one
+type linkChunk struct {
+	file     string
+	function string
+	external []string
+	lines    []string
+}
+
+// Splits an object at its function labels. Labels local to the object are
+// only ever used within the function that defines them
+func splitObject(object asmObject) []linkChunk {
+	functions := map[string]bool{}
+	for _, function := range object.Functions {
+		functions[function] = true
+	}
+
+	// Calls between the object's own functions are external to the chunks
+	outside := map[string]bool{}
+	for _, label := range append(object.External, object.Functions...) {
+		outside[label] = true
+	}
+
+	chunks := []linkChunk{{file: object.File}}
+
+	for _, line := range object.Instructions {
+		if label := strings.Trim(line, "()"); strings.HasPrefix(line, "(") && functions[label] {
+			chunks = append(chunks, linkChunk{file: object.File, function: label})
+		}
+
+		chunk := &chunks[len(chunks)-1]
+		chunk.lines = append(chunk.lines, line)
+	}
+
+	for i := range chunks {
+		external := map[string]bool{}
+
+		for _, line := range chunks[i].lines {
+			if strings.HasPrefix(line, "@") && outside[line[1:]] && line[1:] != chunks[i].function {
+				external[line[1:]] = true
+			}
+		}
+
+		chunks[i].external = sortedKeys(external)
+	}
+
+	if len(chunks[0].lines) == 0 {
+		chunks = chunks[1:]
+	}
+
+	return chunks
+}
+
+// Links every object, then only the library functions they end up needing
+func link(objects []asmObject, libraries []asmObject) ([]string, error) {
+	definedBy := map[string]string{}
+	var included []linkChunk
+
+	for _, object := range objects {
+		for _, chunk := range splitObject(relocate(object, getFolderName())) {
+			if other, ok := definedBy[chunk.function]; ok && chunk.function != "" {
+				return nil, fmt.Errorf("%s is defined in both %s and %s", chunk.function, other, object.File)
+			}
+
+			definedBy[chunk.function] = object.File
+			included = append(included, chunk)
+		}
+	}
+
+	// The first library to define a function wins
+	available := map[string]linkChunk{}
+	for _, library := range libraries {
+		for _, chunk := range splitObject(relocate(library, getFolderName())) {
+			if _, ok := available[chunk.function]; !ok && chunk.function != "" {
+				available[chunk.function] = chunk
+			}
+		}
+	}
+
+	neededBy := map[string]string{}
+
+	for i := 0; i < len(included); i++ {
+		for _, label := range included[i].external {
+			if _, ok := neededBy[label]; !ok {
+				neededBy[label] = included[i].file
+			}
+
+			if _, ok := definedBy[label]; ok {
+				continue
+			}
+
+			if chunk, ok := available[label]; ok {
+				definedBy[label] = chunk.file
+				included = append(included, chunk)
+			}
+		}
+	}
+
+	// The shift, arithmetic and leaf return routines are only generated when
+	// something used them
+	if _, ok := neededBy["SHL"]; ok {
+		translation.ShlCount++
+	}
+
+	if _, ok := neededBy["SHR"]; ok {
+		translation.ShrCount++
+	}
+
+	if _, ok := neededBy["MULT"]; ok {
+		translation.MultCount++
+	}
+
+	if _, ok := neededBy["DIV"]; ok {
+		translation.DivCount++
+	}
+
+	if _, ok := neededBy["MOD"]; ok {
+		translation.ModCount++
+	}
+
+	if _, ok := neededBy["RETURN_LEAF"]; ok {
+		translation.LeafReturnCount++
+	}
+
+	// A custom pointer guard handler is expected to be linked in elsewhere
+	routines := map[string]bool{pointerGuardHandler: true}
+	for _, routine := range allRoutines() {
+		routines[routineName(routine)] = true
+	}
+
+	var unresolved []string
+	for label, file := range neededBy {
+		if _, ok := definedBy[label]; !ok && !routines[label] {
+			unresolved = append(unresolved, fmt.Sprintf("%s (needed by %s)", label, file))
+		}
+	}
+
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return nil, fmt.Errorf("unresolved symbols: %s", strings.Join(unresolved, ", "))
+	}
+
+	return layoutProgram(func() ([]string, error) {
+		var program []string
+
+		for _, chunk := range included {
+			program = append(program, strings.Join(chunk.lines, "\n")+"\n")
+		}
+
+		return program, nil
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// How `push string "..."` is translated: "data" keeps the characters in words
+// of their own beside the statics, "os" builds a String the way the Jack
+// compiler does, with String.new and String.appendChar
+var stringMode string
+
+// The literal a `push string "..."` line pushes, as written
+func pushedString(line string) (string, bool) {
+	if !strings.HasPrefix(line, "push") || strings.IndexByte(line, '"') < 0 {
+		return "", false
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "push" || fields[1] != "string" {
+		return "", false
+	}
+
+	literal := strings.TrimSpace(line[strings.Index(line, "string")+len("string"):])
+
+	return literal, strings.HasPrefix(literal, `"`)
+}
+
+// The characters of a literal, quoted and escaped as a Go string is. Each
+// has to fit in a word
+func decodeString(literal string) ([]rune, error) {
+	text, err := strconv.Unquote(literal)
+	if err != nil || !strings.HasPrefix(literal, `"`) {
+		return nil, fmt.Errorf("invalid string: %s", literal)
+	}
+
+	chars := []rune(text)
+	for _, c := range chars {
+		if c > 32767 {
+			return nil, fmt.Errorf("character %q in %s doesn't fit in a word", c, literal)
+		}
+	}
+
+	return chars, nil
+}
+
+// Adds a string push, or with -strings=os the calls that build the string
+func (p *Parser) pushString(commands []Command, literal string, lineNumber int) ([]Command, error) {
+	if err := p.needsExtended("push string", lineNumber); err != nil {
+		return nil, err
+	}
+
+	chars, err := decodeString(literal)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%d: %v", p.file, lineNumber, err)
+	}
+
+	command := func(kind string, args ...string) Command {
+		return Command{Kind: kind, Args: args, File: p.file, Line: lineNumber}
+	}
+
+	if stringMode != "os" {
+		return append(commands, command("push", "string", literal)), nil
+	}
+
+	commands = append(commands,
+		command("push", "constant", strconv.Itoa(len(chars))),
+		command("call", "String.new", "1"),
+	)
+
+	for _, c := range chars {
+		commands = append(commands,
+			command("push", "constant", strconv.Itoa(int(c))),
+			command("call", "String.appendChar", "2"),
+		)
+	}
+
+	return commands, nil
+}
+
+// Pushes the address of a string's data: its length, then its characters.
+// RAM starts out zeroed, so the first push of a string finds its length 0
+// and writes the characters and length, and later pushes skip over that
+func (t *translator) pushString(literal string) (string, error) {
+	chars, err := decodeString(literal)
+	if err != nil {
+		return "", err
+	}
+
+	n := t.StringCount
+	t.StringCount++
+
+	// The words come one after the other, the length first
+	words := make([]string, len(chars)+1)
+	for i := range words {
+		if words[i], err = t.dataSymbol(fmt.Sprintf("%s.string%d.%d", t.CurrentFile, n, i)); err != nil {
+			return "", err
+		}
+	}
+
+	written := "STRING_WRITTEN" + strconv.Itoa(n)
+
+	lines := []string{
+		words[0],
+		"D=M",
+		"@" + written,
+		"D;JNE",
+	}
+
+	for i, c := range chars {
+		lines = append(lines,
+			fmt.Sprintf("@%d", c),
+			"D=A",
+			words[i+1],
+			"M=D",
+		)
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("@%d", len(chars)),
+		"D=A",
+		words[0],
+		"M=D",
+
+		"("+written+")",
+		words[0],
+		"D=A",
+		"@SP",
+		"AM=M+1",
+		"A=A-1",
+		"M=D",
+	)
+
+	return joinLines(lines), nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Emits LLVM IR (opaque pointers) for the program. Each command is a basic
+// block falling through to the next; returns go through a switch on the
+// return address. Experimental: meant for comparing against the Hack output
+type llvmBackend struct{}
+
+func (llvmBackend) Extension() string {
+	return ".ll"
+}
+
+const llvmPrelude = `@ram = internal global [32768 x i16] zeroinitializer
+@format = private constant [4 x i8] c"%d\0A\00"
+
+declare i32 @printf(ptr, ...)
+declare i32 @atoi(ptr)
+declare ptr @strchr(ptr, i32)
+declare i16 @llvm.smin.i16(i16, i16)
+declare i16 @llvm.smax.i16(i16, i16)
+declare i16 @llvm.abs.i16(i16, i1)
+
+define internal ptr @address(i16 %a) {
+  %masked = and i16 %a, 32767
+  %index = zext i16 %masked to i64
+  %p = getelementptr [32768 x i16], ptr @ram, i64 0, i64 %index
+  ret ptr %p
+}
+
+define internal i16 @peek(i16 %a) {
+  %p = call ptr @address(i16 %a)
+  %v = load i16, ptr %p
+  ret i16 %v
+}
+
+define internal void @poke(i16 %a, i16 %v) {
+  %p = call ptr @address(i16 %a)
+  store i16 %v, ptr %p
+  ret void
+}
+
+define internal void @push(i16 %v) {
+  %sp = call i16 @peek(i16 0)
+  call void @poke(i16 %sp, i16 %v)
+  %next = add i16 %sp, 1
+  call void @poke(i16 0, i16 %next)
+  ret void
+}
+
+define internal i16 @pop() {
+  %sp = call i16 @peek(i16 0)
+  %next = sub i16 %sp, 1
+  call void @poke(i16 0, i16 %next)
+  %v = call i16 @peek(i16 %next)
+  ret i16 %v
+}
+
+define internal i16 @shl(i16 %x, i16 %y) {
+  %positive = icmp sgt i16 %y, 0
+  %big = icmp sge i16 %y, 16
+  %shifted = shl i16 %x, %y
+  %clamped = select i1 %big, i16 0, i16 %shifted
+  %r = select i1 %positive, i16 %clamped, i16 %x
+  ret i16 %r
+}
+
+define internal i16 @shr(i16 %x, i16 %y) {
+  %positive = icmp sgt i16 %y, 0
+  %big = icmp sge i16 %y, 16
+  %shifted = ashr i16 %x, %y
+  %sign = ashr i16 %x, 15
+  %clamped = select i1 %big, i16 %sign, i16 %shifted
+  %r = select i1 %positive, i16 %clamped, i16 %x
+  ret i16 %r
+}
+
+; sdiv and srem are undefined for a divisor of 0 and for -32768 / -1, so
+; those divide by 1 instead. Dividing by zero gives 0 and leaves x as the
+; remainder, as the DIV and MOD routines do
+define internal i16 @div(i16 %x, i16 %y) {
+  %zero = icmp eq i16 %y, 0
+  %minus = icmp eq i16 %y, -1
+  %special = or i1 %zero, %minus
+  %divisor = select i1 %special, i16 1, i16 %y
+  %q = sdiv i16 %x, %divisor
+  %negated = sub i16 0, %x
+  %signed = select i1 %minus, i16 %negated, i16 %q
+  %r = select i1 %zero, i16 0, i16 %signed
+  ret i16 %r
+}
+
+define internal i16 @mod(i16 %x, i16 %y) {
+  %zero = icmp eq i16 %y, 0
+  %minus = icmp eq i16 %y, -1
+  %special = or i1 %zero, %minus
+  %divisor = select i1 %special, i16 1, i16 %y
+  %m = srem i16 %x, %divisor
+  %r = select i1 %zero, i16 %x, i16 %m
+  ret i16 %r
+}
+
+; Arguments of the form ADDRESS=VALUE set RAM before running, any other
+; ADDRESS is printed once the program halts
+define internal void @arguments(i32 %argc, ptr %argv, i1 %set) {
+entry:
+  br label %loop
+loop:
+  %i = phi i32 [1, %entry], [%next, %continue]
+  %done = icmp sge i32 %i, %argc
+  br i1 %done, label %end, label %body
+body:
+  %slot = getelementptr ptr, ptr %argv, i32 %i
+  %arg = load ptr, ptr %slot
+  %equals = call ptr @strchr(ptr %arg, i32 61)
+  %assignment = icmp ne ptr %equals, null
+  %a = call i32 @atoi(ptr %arg)
+  %a16 = trunc i32 %a to i16
+  br i1 %set, label %setting, label %printing
+setting:
+  br i1 %assignment, label %assign, label %continue
+assign:
+  %text = getelementptr i8, ptr %equals, i32 1
+  %v = call i32 @atoi(ptr %text)
+  %v16 = trunc i32 %v to i16
+  call void @poke(i16 %a16, i16 %v16)
+  br label %continue
+printing:
+  br i1 %assignment, label %continue, label %print
+print:
+  %value = call i16 @peek(i16 %a16)
+  %wide = sext i16 %value to i32
+  call i32 (ptr, ...) @printf(ptr @format, i32 %wide)
+  br label %continue
+continue:
+  %next = add i32 %i, 1
+  br label %loop
+end:
+  ret void
+}
+`
+
+var llvmOperators = map[string]string{
+	"add": "add i16 %x, %y", "sub": "sub i16 %x, %y", "and": "and i16 %x, %y", "or": "or i16 %x, %y",
+	"shl": "call i16 @shl(i16 %x, i16 %y)", "shr": "call i16 @shr(i16 %x, i16 %y)",
+	"mult": "mul i16 %x, %y", "div": "call i16 @div(i16 %x, i16 %y)", "mod": "call i16 @mod(i16 %x, i16 %y)",
+	"min": "call i16 @llvm.smin.i16(i16 %x, i16 %y)", "max": "call i16 @llvm.smax.i16(i16 %x, i16 %y)",
+}
+
+var llvmComparisons = map[string]string{
+	"eq": "eq", "gt": "sgt", "lt": "slt",
+}
+
+// Builds up a block's instructions, naming each value after the command so
+// names stay unique across the function
+type llvmBlock struct {
+	prefix string
+	count  int
+	lines  []string
+}
+
+func (b *llvmBlock) value(format string, args ...interface{}) string {
+	b.count++
+	name := fmt.Sprintf("%%%s.%d", b.prefix, b.count)
+	b.lines = append(b.lines, fmt.Sprintf("  %s = %s", name, fmt.Sprintf(format, args...)))
+
+	return name
+}
+
+func (b *llvmBlock) do(format string, args ...interface{}) {
+	b.lines = append(b.lines, "  "+fmt.Sprintf(format, args...))
+}
+
+// The address a segment access refers to
+func (b *llvmBlock) address(layout programLayout, command Command) (string, error) {
+	segment, index := command.Args[0], command.Args[1]
+
+	n, err := strconv.Atoi(index)
+	if err != nil {
+		return "", fmt.Errorf("invalid index: %s", index)
+	}
+
+	pointers := map[string]int{"local": 1, "argument": 2, "this": 3, "that": 4}
+
+	switch segment {
+	case "local", "argument", "this", "that":
+		base := b.value("call i16 @peek(i16 %d)", pointers[segment])
+		return b.value("add i16 %s, %s", base, index), nil
+	case "pointer":
+		return b.value("add i16 3, %s", index), nil
+	case "temp":
+		return b.value("add i16 %d, %s", memory.Temp, index), nil
+	case "global":
+		address, err := globalAddress(n)
+		return strconv.Itoa(address), err
+	case "static":
+		return strconv.Itoa(layout.statics[staticKey(command.File, index)]), nil
+	}
+
+	return "", fmt.Errorf("invalid segment: %s", segment)
+}
+
+func (llvmBackend) Emit(commands []Command) (string, error) {
+	layout, err := layoutCommands(commands)
+	if err != nil {
+		return "", err
+	}
+
+	if len(commands) > 32767 {
+		return "", fmt.Errorf("too many commands for the LLVM backend: %d", len(commands))
+	}
+
+	lines := []string{
+		fmt.Sprintf("; Translated from %s", getFolderName()),
+		llvmPrelude,
+		"define i32 @main(i32 %argc, ptr %argv) {",
+		"entry:",
+		"  %pc = alloca i16",
+		"  call void @arguments(i32 %argc, ptr %argv, i1 1)",
+	}
+
+	if shouldStandardBootstrap || shouldSetStackPointer || shouldBootstrap {
+		lines = append(lines, fmt.Sprintf("  call void @poke(i16 0, i16 %d)", memory.Stack))
+	}
+
+	// The entry function returns, if at all, to address -1, which halts
+	start := 0
+	if shouldStandardBootstrap || shouldBootstrap {
+		init, ok := layout.functions[entryFunction]
+		if !ok {
+			return "", fmt.Errorf("bootstrap needs a %s function", entryFunction)
+		}
+
+		entry := &llvmBlock{prefix: "boot"}
+		for i := 0; i < entryArgs; i++ {
+			entry.do("call void @push(i16 0)")
+		}
+
+		entry.call(-1, entryArgs)
+		lines = append(lines, entry.lines...)
+		start = init
+	}
+
+	lines = append(lines, fmt.Sprintf("  br label %%c%d", start))
+
+	var returnPoints []int
+
+	for i, command := range commands {
+		block := &llvmBlock{prefix: fmt.Sprintf("c%d", i)}
+
+		if err := block.command(layout, i, command); err != nil {
+			return "", fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
+
+		if command.Kind == "call" {
+			returnPoints = append(returnPoints, i+1)
+		}
+
+		lines = append(lines, "", fmt.Sprintf("c%d: ; %s", i, command))
+		lines = append(lines, block.lines...)
+	}
+
+	lines = append(lines,
+		"",
+		fmt.Sprintf("c%d:", len(commands)),
+		"  br label %halt",
+		"",
+		"dispatch:",
+		"  %address = load i16, ptr %pc",
+		"  switch i16 %address, label %halt [",
+	)
+
+	for _, point := range returnPoints {
+		lines = append(lines, fmt.Sprintf("    i16 %d, label %%c%d", point, point))
+	}
+
+	lines = append(lines,
+		"  ]",
+		"",
+		"halt:",
+		"  call void @arguments(i32 %argc, ptr %argv, i1 0)",
+		"  ret i32 0",
+		"}",
+	)
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// Pushes the frame and sets ARG and LCL for a call returning to `address`
+func (b *llvmBlock) call(address int, args int) {
+	b.do("call void @push(i16 %d)", address)
+
+	for pointer := 1; pointer <= 4; pointer++ {
+		saved := b.value("call i16 @peek(i16 %d)", pointer)
+		b.do("call void @push(i16 %s)", saved)
+	}
+
+	sp := b.value("call i16 @peek(i16 0)")
+	arg := b.value("sub i16 %s, %d", sp, 5+args)
+	b.do("call void @poke(i16 2, i16 %s)", arg)
+	b.do("call void @poke(i16 1, i16 %s)", sp)
+}
+
+func (b *llvmBlock) command(layout programLayout, i int, command Command) error {
+	next := fmt.Sprintf("br label %%c%d", i+1)
+
+	switch command.Kind {
+	case "push":
+		value := command.Args[1]
+
+		if command.Args[0] != "constant" {
+			address, err := b.address(layout, command)
+			if err != nil {
+				return err
+			}
+
+			value = b.value("call i16 @peek(i16 %s)", address)
+		}
+
+		b.do("call void @push(i16 %s)", value)
+
+	case "pop":
+		value := b.value("call i16 @pop()")
+
+		address, err := b.address(layout, command)
+		if err != nil {
+			return err
+		}
+
+		b.do("call void @poke(i16 %s, i16 %s)", address, value)
+
+	case "neg":
+		x := b.value("call i16 @pop()")
+		b.do("call void @push(i16 %s)", b.value("sub i16 0, %s", x))
+
+	case "not":
+		x := b.value("call i16 @pop()")
+		b.do("call void @push(i16 %s)", b.value("xor i16 %s, -1", x))
+
+	case "abs":
+		x := b.value("call i16 @pop()")
+		b.do("call void @push(i16 %s)", b.value("call i16 @llvm.abs.i16(i16 %s, i1 false)", x))
+
+	case "label", "breakpoint":
+
+	case "goto":
+		if layout.halts[i] {
+			next = "br label %halt"
+		} else {
+			next = fmt.Sprintf("br label %%c%d", layout.labels[scopedLabel(layout.scopes[i], command.Args[0])])
+		}
+
+	case "if-goto":
+		value := b.value("call i16 @pop()")
+		condition := b.value("icmp ne i16 %s, 0", value)
+		next = fmt.Sprintf("br i1 %s, label %%c%d, label %%c%d", condition, layout.labels[scopedLabel(layout.scopes[i], command.Args[0])], i+1)
+
+	case "function":
+		locals, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return fmt.Errorf("invalid vars for function definition (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		for n := 0; n < locals; n++ {
+			b.do("call void @push(i16 0)")
+		}
+
+	case "call":
+		args, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return fmt.Errorf("invalid args to function (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		b.call(i+1, args)
+		next = fmt.Sprintf("br label %%c%d", layout.functions[command.Args[0]])
+
+	case "return":
+		frame := b.value("call i16 @peek(i16 1)")
+		address := b.value("call i16 @peek(i16 %s)", b.value("sub i16 %s, 5", frame))
+		value := b.value("call i16 @pop()")
+		arg := b.value("call i16 @peek(i16 2)")
+		b.do("call void @poke(i16 %s, i16 %s)", arg, value)
+		b.do("call void @poke(i16 0, i16 %s)", b.value("add i16 %s, 1", arg))
+
+		for pointer := 4; pointer >= 1; pointer-- {
+			saved := b.value("call i16 @peek(i16 %s)", b.value("sub i16 %s, %d", frame, 5-pointer))
+			b.do("call void @poke(i16 %d, i16 %s)", pointer, saved)
+		}
+
+		b.do("store i16 %s, ptr %%pc", address)
+		halting := b.value("icmp slt i16 %s, 0", address)
+		next = fmt.Sprintf("br i1 %s, label %%halt, label %%dispatch", halting)
+
+	default:
+		y := b.value("call i16 @pop()")
+		x := b.value("call i16 @pop()")
+
+		var result string
+
+		if operator, ok := llvmOperators[command.Kind]; ok {
+			result = b.value("%s", strings.NewReplacer("%x", x, "%y", y).Replace(operator))
+		} else if comparison, ok := llvmComparisons[command.Kind]; ok {
+			result = b.value("sext i1 %s to i16", b.value("icmp %s i16 %s, %s", comparison, x, y))
+		} else {
+			return fmt.Errorf("invalid command: %s", command)
+		}
+
+		b.do("call void @push(i16 %s)", result)
+	}
+
+	b.do("%s", next)
+
+	return nil
+}
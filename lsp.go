@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var vmSegments = []string{"constant", "local", "argument", "this", "that", "static", "temp", "pointer"}
+
+// The most a segment's index may be, for those with a fixed size
+var segmentLimits = map[string]int{"constant": 32767, "pointer": 1, "temp": 7}
+
+// Something wrong with a line of a .vm file, with the change that puts it
+// right when there's an obvious one
+type sourceProblem struct {
+	// Zero-based, as editors count, as are the columns of the part at fault
+	line       int
+	start, end int
+	message    string
+	warning    bool
+	fix        *sourceEdit
+}
+
+// Replaces the columns start to end of a line with text, inserting it when
+// they're the same
+type sourceEdit struct {
+	title      string
+	line       int
+	start, end int
+	text       string
+}
+
+type sourceField struct {
+	text       string
+	start, end int
+}
+
+// The words of a line's code, where they are
+func sourceFields(line string) []sourceField {
+	code := strings.Split(line, "//")[0]
+
+	var fields []sourceField
+	for i := 0; i < len(code); {
+		if code[i] == ' ' || code[i] == '\t' || code[i] == '\r' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(code) && code[i] != ' ' && code[i] != '\t' && code[i] != '\r' {
+			i++
+		}
+
+		fields = append(fields, sourceField{code[start:i], start, i})
+	}
+
+	return fields
+}
+
+// The number of single-letter edits that turn a into b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// The word a mistyped one was most likely meant to be, if any is close
+func closestWord(word string, words []string) (string, bool) {
+	best, bestDistance := "", 3
+
+	for _, candidate := range words {
+		if distance := editDistance(strings.ToLower(word), candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	return best, best != ""
+}
+
+// Finds the problems in a .vm file's source: commands that don't exist or
+// have the wrong arguments, unknown segments, indexes out of range, functions
+// that don't end by returning and labels declared twice in a function
+func checkSource(text string) []sourceProblem {
+	lines := strings.Split(text, "\n")
+	var problems []sourceProblem
+
+	var commandNames []string
+	for name := range commandArity {
+		commandNames = append(commandNames, name)
+	}
+	sort.Strings(commandNames)
+
+	function := ""
+	functionLine := 0
+	lastKind := ""
+	lastLine := 0
+	labels := map[string]int{}
+
+	// Functions fall through into whatever follows unless they return, or
+	// jump away for good like Sys.init's closing loop
+	endFunction := func() {
+		if function == "" || lastKind == "return" || lastKind == "goto" {
+			return
+		}
+
+		last := lines[lastLine]
+		indent := last[:len(last)-len(strings.TrimLeft(last, " \t"))]
+		end := len(strings.TrimRight(last, "\r"))
+
+		problems = append(problems, sourceProblem{
+			line:    functionLine,
+			start:   0,
+			end:     len(strings.TrimRight(lines[functionLine], "\r")),
+			message: fmt.Sprintf("function %s doesn't end with return", function),
+			warning: true,
+			fix:     &sourceEdit{title: "Insert return", line: lastLine, start: end, end: end, text: "\n" + indent + "return"},
+		})
+	}
+
+	inBlockComment := false
+	inMacro := false
+	inAsm := false
+	constants := map[string]string{}
+	macros := map[string]bool{}
+
+	for i, line := range lines {
+		// Asm is the assembler's to check
+		if inAsm {
+			inAsm = strings.TrimSpace(line) != "}"
+			continue
+		}
+
+		// Comments are blanked rather than cut, keeping the columns
+		var code string
+		code, inBlockComment = blankComments(line, inBlockComment)
+
+		if opensAsmBlock(code) {
+			inAsm = true
+			continue
+		}
+
+		// A string is checked whole, as it may hold spaces or //
+		if literal, ok := pushedString(strings.TrimSpace(code)); ok {
+			start := strings.LastIndex(code, literal)
+			problem := sourceProblem{line: i, start: start, end: start + len(literal)}
+
+			if _, err := decodeString(literal); err != nil {
+				problem.message = err.Error()
+				problems = append(problems, problem)
+			} else if dialect != "extended" {
+				problem.message = "push string needs -dialect=extended"
+				problems = append(problems, problem)
+			}
+
+			continue
+		}
+
+		fields := sourceFields(code)
+		if len(fields) == 0 {
+			continue
+		}
+
+		kind := fields[0]
+		problem := sourceProblem{line: i, start: kind.start, end: fields[len(fields)-1].end}
+
+		// What macros expand to is checked where they're used, by the parser
+		switch {
+		case kind.text == "macro":
+			if name, _, ok := parseMacroCall(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(code), "macro"))); ok {
+				macros[name] = true
+			}
+
+			inMacro = true
+			continue
+
+		case kind.text == "endmacro":
+			inMacro = false
+			continue
+
+		case inMacro:
+			continue
+		}
+
+		if name, _, ok := parseMacroCall(strings.TrimSpace(code)); ok && macros[name] {
+			continue
+		}
+
+		arity, ok := commandArity[kind.text]
+		if !ok {
+			problem.message = fmt.Sprintf("unknown command: %s", kind.text)
+			problem.end = kind.end
+
+			if fixed, ok := closestWord(kind.text, commandNames); ok {
+				problem.fix = &sourceEdit{title: fmt.Sprintf("Change to %s", fixed), line: i, start: kind.start, end: kind.end, text: fixed}
+			}
+
+			problems = append(problems, problem)
+			continue
+		}
+
+		if len(fields)-1 != arity {
+			problem.message = fmt.Sprintf("%s takes %d arguments, not %d", kind.text, arity, len(fields)-1)
+			problems = append(problems, problem)
+			continue
+		}
+
+		if (extendedOperations[kind.text] || extendedCommands[kind.text]) && dialect != "extended" {
+			problem.message = fmt.Sprintf("%s needs -dialect=extended", kind.text)
+			problems = append(problems, problem)
+			continue
+		}
+
+		switch kind.text {
+		case "function":
+			endFunction()
+
+			function, functionLine = fields[1].text, i
+			labels = map[string]int{}
+
+			if n, err := strconv.Atoi(fields[2].text); err != nil || n < 0 {
+				problem.message = fmt.Sprintf("invalid number of locals: %s", fields[2].text)
+				problems = append(problems, problem)
+			}
+
+		case "const", "alias":
+			name, value := fields[1], fields[len(fields)-1]
+			if constant, ok := constants[value.text]; ok {
+				value.text = constant
+			}
+
+			if _, err := strconv.Atoi(value.text); err != nil {
+				problems = append(problems, sourceProblem{line: i, start: value.start, end: value.end, message: fmt.Sprintf("invalid value for %s: %s", name.text, value.text)})
+				break
+			}
+
+			constants[name.text] = value.text
+
+		case "call":
+			if n, err := strconv.Atoi(fields[2].text); err != nil || n < 0 {
+				problem.message = fmt.Sprintf("invalid number of arguments: %s", fields[2].text)
+				problems = append(problems, problem)
+			}
+
+		case "label":
+			name := fields[1]
+
+			if first, ok := labels[name.text]; ok {
+				renamed := name.text
+				for n := 2; labels[renamed] != 0 || renamed == name.text; n++ {
+					renamed = fmt.Sprintf("%s_%d", name.text, n)
+				}
+
+				problems = append(problems, sourceProblem{
+					line: i, start: name.start, end: name.end,
+					message: fmt.Sprintf("label %s is already declared on line %d", name.text, first),
+					fix:     &sourceEdit{title: fmt.Sprintf("Rename to %s", renamed), line: i, start: name.start, end: name.end, text: renamed},
+				})
+			} else {
+				labels[name.text] = i + 1
+			}
+
+		case "push", "pop":
+			segment, index := fields[1], fields[2]
+
+			if !isSegment(segment.text) {
+				unknown := sourceProblem{line: i, start: segment.start, end: segment.end, message: fmt.Sprintf("unknown segment: %s", segment.text)}
+				if fixed, ok := closestWord(segment.text, vmSegments); ok {
+					unknown.fix = &sourceEdit{title: fmt.Sprintf("Change to %s", fixed), line: i, start: segment.start, end: segment.end, text: fixed}
+				}
+
+				problems = append(problems, unknown)
+				break
+			}
+
+			value := index.text
+			if constant, ok := constants[value]; ok {
+				value = constant
+			}
+
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, sourceProblem{line: i, start: index.start, end: index.end, message: fmt.Sprintf("index must be a number: %s", index.text)})
+				break
+			}
+
+			limit, limited := segmentLimits[segment.text]
+			if n < 0 || (limited && n > limit) {
+				clamped := 0
+				if n > 0 {
+					clamped = limit
+				}
+
+				problems = append(problems, sourceProblem{
+					line: i, start: index.start, end: index.end,
+					message: fmt.Sprintf("%s index out of range: %d", segment.text, n),
+					fix:     &sourceEdit{title: fmt.Sprintf("Clamp to %d", clamped), line: i, start: index.start, end: index.end, text: strconv.Itoa(clamped)},
+				})
+			}
+
+			if kind.text == "pop" && segment.text == "constant" {
+				problems = append(problems, sourceProblem{line: i, start: segment.start, end: segment.end, message: "can't pop to constant"})
+			}
+		}
+
+		lastKind, lastLine = kind.text, i
+	}
+
+	endFunction()
+
+	return problems
+}
+
+func isSegment(name string) bool {
+	if name == "global" && dialect == "extended" {
+		return true
+	}
+
+	for _, segment := range vmSegments {
+		if name == segment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A JSON-RPC message from the editor: a request when it has an ID, a
+// notification otherwise
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+func (p sourceProblem) diagnostic() lspDiagnostic {
+	severity := 1
+	if p.warning {
+		severity = 2
+	}
+
+	return lspDiagnostic{
+		Range:    lspRange{lspPosition{p.line, p.start}, lspPosition{p.line, p.end}},
+		Severity: severity,
+		Source:   "vmtranslator",
+		Message:  p.message,
+	}
+}
+
+// A language server's view of the open .vm files
+type lspServer struct {
+	out       io.Writer
+	documents map[string]string
+}
+
+func (s *lspServer) send(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *lspServer) respond(id json.RawMessage, result interface{}) {
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *lspServer) publishDiagnostics(uri string) {
+	diagnostics := []lspDiagnostic{}
+	for _, problem := range checkSource(s.documents[uri]) {
+		diagnostics = append(diagnostics, problem.diagnostic())
+	}
+
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+// The quick fixes for the problems on the lines in a range
+func (s *lspServer) codeActions(uri string, lines lspRange) []map[string]interface{} {
+	actions := []map[string]interface{}{}
+
+	for _, problem := range checkSource(s.documents[uri]) {
+		if problem.fix == nil || problem.line < lines.Start.Line || problem.line > lines.End.Line {
+			continue
+		}
+
+		fix := problem.fix
+		edit := lspTextEdit{Range: lspRange{lspPosition{fix.line, fix.start}, lspPosition{fix.line, fix.end}}, NewText: fix.text}
+
+		actions = append(actions, map[string]interface{}{
+			"title":       fix.title,
+			"kind":        "quickfix",
+			"diagnostics": []lspDiagnostic{problem.diagnostic()},
+			"isPreferred": true,
+			"edit":        map[string]interface{}{"changes": map[string][]lspTextEdit{uri: {edit}}},
+		})
+	}
+
+	return actions
+}
+
+// Answers a message, returning false once the editor says to exit
+func (s *lspServer) handle(message lspMessage) bool {
+	var params struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Range lspRange `json:"range"`
+	}
+
+	if len(message.Params) > 0 {
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			log.Print(err)
+			return true
+		}
+	}
+
+	uri := params.TextDocument.URI
+
+	switch message.Method {
+	case "initialize":
+		s.respond(message.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Whole documents are sent on each change
+				"textDocumentSync":   1,
+				"codeActionProvider": map[string][]string{"codeActionKinds": {"quickfix"}},
+			},
+			"serverInfo": map[string]string{"name": "vmtranslator"},
+		})
+
+	case "textDocument/didOpen":
+		s.documents[uri] = params.TextDocument.Text
+		s.publishDiagnostics(uri)
+
+	case "textDocument/didChange":
+		if len(params.ContentChanges) > 0 {
+			s.documents[uri] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		}
+
+		s.publishDiagnostics(uri)
+
+	case "textDocument/didClose":
+		delete(s.documents, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []lspDiagnostic{}})
+
+	case "textDocument/codeAction":
+		s.respond(message.ID, s.codeActions(uri, params.Range))
+
+	case "shutdown":
+		s.respond(message.ID, nil)
+
+	case "exit":
+		return false
+
+	default:
+		// Requests need an answer, notifications don't
+		if len(message.ID) > 0 {
+			s.send(map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "error": map[string]interface{}{"code": -32601, "message": "method not found: " + message.Method}})
+		}
+	}
+
+	return true
+}
+
+// Speaks the Language Server Protocol with an editor until it exits
+func serveLSP(in io.Reader, out io.Writer) error {
+	server := &lspServer{out: out, documents: map[string]string{}}
+	reader := bufio.NewReader(in)
+
+	for {
+		data, err := readFramedMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var message lspMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+
+		if !server.handle(message) {
+			return nil
+		}
+	}
+}
+
+// A language server for .vm files over stdin and stdout, reporting problems
+// as they're typed and offering quick fixes for those with an obvious one
+func lspCommand(args []string) {
+	parseFlags(args)
+
+	if err := serveLSP(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A macro defined in a .vm file, e.g.
+//
+//	macro pushcall(a, b, f)
+//	push constant a
+//	push constant b
+//	call f 2
+//	endmacro
+//
+// after which `pushcall(1, 2, Math.multiply)` stands for the body with the
+// arguments in place of the parameters. A macro can be used anywhere in the
+// file after its definition, including in later macros
+type vmMacro struct {
+	name   string
+	params []string
+	line   int
+	body   []macroLine
+}
+
+// A line of a macro's body, comments taken out, and where it was written
+type macroLine struct {
+	text string
+	line int
+}
+
+// Splits `name(a, b)` into the name and the arguments
+func parseMacroCall(text string) (string, []string, bool) {
+	open := strings.IndexByte(text, '(')
+	if open < 1 || !strings.HasSuffix(text, ")") {
+		return "", nil, false
+	}
+
+	name := strings.TrimSpace(text[:open])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", nil, false
+	}
+
+	var args []string
+	if inner := strings.TrimSpace(text[open+1 : len(text)-1]); inner != "" {
+		for _, arg := range strings.Split(inner, ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	}
+
+	return name, args, true
+}
+
+// Whether a byte can be part of a name in VM code
+func isNameByte(b byte) bool {
+	return b == '_' || b == '.' || b == '$' || b == ':' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func isName(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if !isNameByte(text[i]) {
+			return false
+		}
+	}
+
+	return text != ""
+}
+
+// Puts the arguments in place of the parameters, wherever one is a whole
+// name rather than part of one
+func substituteParams(text string, values map[string]string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(text); {
+		if !isNameByte(text[i]) {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(text) && isNameByte(text[i]) {
+			i++
+		}
+
+		if value, ok := values[text[start:i]]; ok {
+			out.WriteString(value)
+		} else {
+			out.WriteString(text[start:i])
+		}
+	}
+
+	return out.String()
+}
+
+// Handles the lines to do with macros: their definitions, and uses of them,
+// which add the commands they expand to. Says whether the line was one
+func (p *Parser) macroLine(commands []Command, line string, lineNumber int) ([]Command, bool, error) {
+	keyword := line
+	if end := strings.IndexAny(line, " \t"); end >= 0 {
+		keyword = line[:end]
+	}
+
+	if p.defining != nil {
+		switch keyword {
+		case "endmacro":
+			if line != keyword {
+				return nil, true, fmt.Errorf("%s:%d: endmacro takes no arguments", p.file, lineNumber)
+			}
+
+			if p.macros == nil {
+				p.macros = map[string]*vmMacro{}
+			}
+
+			p.macros[p.defining.name] = p.defining
+			p.defining = nil
+
+		case "macro":
+			return nil, true, fmt.Errorf("%s:%d: macro inside macro %s", p.file, lineNumber, p.defining.name)
+
+		default:
+			p.defining.body = append(p.defining.body, macroLine{line, lineNumber})
+		}
+
+		return commands, true, nil
+	}
+
+	switch keyword {
+	case "macro":
+		if err := p.needsExtended("macro", lineNumber); err != nil {
+			return nil, true, err
+		}
+
+		name, params, ok := parseMacroCall(strings.TrimSpace(strings.TrimPrefix(line, "macro")))
+		if !ok {
+			return nil, true, fmt.Errorf("%s:%d: invalid macro definition, expected macro name(params): %s", p.file, lineNumber, line)
+		}
+
+		if _, ok := commandArity[name]; ok {
+			return nil, true, fmt.Errorf("%s:%d: macro can't be named after the command %s", p.file, lineNumber, name)
+		}
+
+		if previous, ok := p.macros[name]; ok {
+			return nil, true, fmt.Errorf("%s:%d: macro %s is already defined on line %d", p.file, lineNumber, name, previous.line)
+		}
+
+		seen := map[string]bool{}
+		for _, param := range params {
+			if !isName(param) || seen[param] {
+				return nil, true, fmt.Errorf("%s:%d: invalid parameters for macro %s: %s", p.file, lineNumber, name, strings.Join(params, ", "))
+			}
+
+			seen[param] = true
+		}
+
+		p.defining = &vmMacro{name: name, params: params, line: lineNumber}
+		return commands, true, nil
+
+	case "endmacro":
+		return nil, true, fmt.Errorf("%s:%d: endmacro without macro", p.file, lineNumber)
+	}
+
+	name, args, ok := parseMacroCall(line)
+	if !ok {
+		return commands, false, nil
+	}
+
+	m, ok := p.macros[name]
+	if !ok {
+		return nil, true, fmt.Errorf("%s:%d: unknown macro: %s", p.file, lineNumber, name)
+	}
+
+	commands, err := p.expandMacro(commands, m, args, lineNumber)
+	return commands, true, err
+}
+
+// The most lines of macro bodies a file's macro uses may expand to, so that
+// macros nested many deep fail rather than run out of memory
+const maxMacroExpansion = 1 << 16
+
+// Adds the commands a use of a macro expands to. They're placed at the line
+// of the outermost use, and errors in them say where in the macro they are
+func (p *Parser) expandMacro(commands []Command, m *vmMacro, args []string, lineNumber int) ([]Command, error) {
+	if len(args) != len(m.params) {
+		return nil, fmt.Errorf("%s:%d: macro %s takes %d arguments, not %d", p.file, lineNumber, m.name, len(m.params), len(args))
+	}
+
+	for _, name := range p.expanding {
+		if name == m.name {
+			return nil, fmt.Errorf("%s:%d: macro %s expands into itself", p.file, lineNumber, m.name)
+		}
+	}
+
+	p.expanding = append(p.expanding, m.name)
+	defer func() { p.expanding = p.expanding[:len(p.expanding)-1] }()
+
+	values := map[string]string{}
+	for i, param := range m.params {
+		values[param] = args[i]
+	}
+
+	for _, body := range m.body {
+		p.expanded++
+		if p.expanded > maxMacroExpansion {
+			return nil, fmt.Errorf("%s:%d: macro expansion too large", p.file, lineNumber)
+		}
+
+		text := substituteParams(body.text, values)
+
+		var err error
+		commands, err = p.parseCommand(commands, text, lineNumber)
+		if err != nil {
+			return nil, fmt.Errorf("%w (in macro %s, line %d)", err, m.name, body.line)
+		}
+	}
+
+	return commands, nil
+}
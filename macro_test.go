@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMacroExpansion(t *testing.T) {
+	commands, err := parseAs("extended", "macro pushtwo(a, b)\npush constant a\npush constant b\nendmacro\nmacro sum(a, b)\npushtwo(a, b)\nadd\nendmacro\nsum(1, 2)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, command := range commands {
+		got = append(got, fmt.Sprintf("%s:%d", command, command.Line))
+	}
+
+	if want := "push constant 1:9 push constant 2:9 add:9"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Each macro uses the one before twice, so the last expands to 2^20 lines
+func TestMacroExpansionTooLarge(t *testing.T) {
+	var source strings.Builder
+	source.WriteString("macro m0()\npush constant 0\nendmacro\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&source, "macro m%d()\nm%d()\nm%[2]d()\nendmacro\n", i, i-1)
+	}
+	source.WriteString("m20()\n")
+
+	line := strings.Count(source.String(), "\n")
+
+	_, err := parseAs("extended", source.String())
+	if want := fmt.Sprintf("Main.vm:%d: macro expansion too large", line); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
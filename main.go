@@ -8,94 +8,621 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 var shouldBootstrap bool
-var shouldEndWithLoop bool
+var shouldStandardBootstrap bool
+var epilogueMode string
+var epilogueLabel string
+var haltAddress int
+var haltValue int
 var shouldSetStackPointer bool
 
+// The function the bootstrap calls, and how many arguments, each 0, it's
+// called with
+var entryFunction string
+var entryArgs int
+var useExtendedALU bool
+var optimizationLevel int
+var inlineThreshold int
+var routineLayout string
+var shouldAnnotate bool
+var shouldEmitSymbols bool
+var emitMode string
+var reportPath string
+var osFolder string
+var shouldDiff bool
+var prettyOutput bool
+var shouldStrip bool
+var backendName string
+var shouldExplain bool
+var useStackGuards bool
+var usePointerGuards bool
+var romBankSize int
+var pointerGuardHandler string
+var inlineMaxCalls int
+var shouldWriteDependencies bool
+var shouldMarkGenerated bool
+var outputPath string
+
+// What's done about a .vm file whose name doesn't start with an uppercase
+// letter, as the course asks: "warn" or "allow"
+var lowercaseFiles string
+
+// How the lines of the asm written end: "lf", or "crlf" as Windows tools
+// expect
+var lineEndings string
+
+// What labels are scoped to: "function", as the course has it, or "file", for
+// hand-written files with control flow outside any function
+var labelScope string
+
 var pathToTranslate string
 
 const locRegister = "@R13"
 const valueRegister = "@R14"
 
-type Parser struct{}
+type Parser struct {
+	file string
+
+	macros map[string]*vmMacro
+	// The macro whose body is being read, if any
+	defining *vmMacro
+	// The macros being expanded, innermost last
+	expanding []string
+	// How many lines of macro bodies the file's macro uses have expanded to
+	expanded int
+	// The asm block being read, if any
+	asmBlock *Command
+
+	constants map[string]vmConstant
+
+	// Where the last `// source:` comment said the commands came from
+	source string
+
+	// Where the file's statics are pinned from, or 0
+	staticBase int
+}
+
+// A single VM command as read from the source, e.g. `push local 2` has the
+// kind "push" and the args ["local", "2"]
+type Command struct {
+	Kind string   `json:"kind"`
+	Args []string `json:"args"`
+	File string   `json:"file"`
+	Line int      `json:"line"`
+	// Where a `// source:` comment says the command came from, e.g.
+	// Main.jack:37
+	Source string `json:"source,omitempty"`
+	// Where a `//!static-base` directive pins the file's statics from, or 0
+	StaticBase int `json:"staticBase,omitempty"`
+	// Written by the optimizer rather than read from the source
+	Synthetic bool `json:"synthetic,omitempty"`
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Kind}, c.Args...), " ")
+}
 
 type Stack struct {
-	current       string
-	returnCounter int
+	Current       string `json:"function"`
+	ReturnCounter int    `json:"returnCounter"`
+}
+
+// What translating commands keeps track of as it goes: the function it's in,
+// the file statics belong to, the numbers for labels and the statics given
+// addresses. It's copied and restored whole, for a translator to go on from
+// where another leaves off
+type translatorState struct {
+	FuncStack   Stack  `json:"funcStack"`
+	CurrentFile string `json:"currentFile"`
+	// Where the current file's statics are pinned from, or 0
+	StaticBase int `json:"staticBase,omitempty"`
+
+	EqCount         int `json:"eqCount"`
+	GtCount         int `json:"gtCount"`
+	LtCount         int `json:"ltCount"`
+	ShlCount        int `json:"shlCount"`
+	ShrCount        int `json:"shrCount"`
+	LeafReturnCount int `json:"leafReturnCount"`
+	MultCount       int `json:"multCount"`
+	DivCount        int `json:"divCount"`
+	ModCount        int `json:"modCount"`
+
+	// The number for the next label of the branches min, max and abs take
+	BranchCount int `json:"branchCount"`
+
+	// The number of the next string pushed, for its data and labels
+	StringCount int `json:"stringCount"`
+
+	StaticAddresses map[string]int `json:"staticAddresses"`
+
+	// The shapes of the pushes and pops sent through shared routines, and
+	// the number for the next one's return label
+	SharedRoutines map[string]bool `json:"sharedRoutines,omitempty"`
+	SharedCount    int             `json:"sharedCount,omitempty"`
+
+	// The file the last -pretty banner was written for
+	PrettyFile string `json:"prettyFile"`
+
+	// Where the last `// source:` comment written said the asm came from
+	Source string `json:"source,omitempty"`
+}
+
+// A copy that shares nothing with the state it's taken from
+func (s translatorState) clone() translatorState {
+	staticAddresses := make(map[string]int, len(s.StaticAddresses))
+	for symbol, address := range s.StaticAddresses {
+		staticAddresses[symbol] = address
+	}
+
+	sharedRoutines := make(map[string]bool, len(s.SharedRoutines))
+	for shape := range s.SharedRoutines {
+		sharedRoutines[shape] = true
+	}
+
+	s.StaticAddresses = staticAddresses
+	s.SharedRoutines = sharedRoutines
+
+	return s
+}
+
+// Translates commands, keeping track of where it's got to. Files translated
+// at the same time each have their own
+type translator struct {
+	translatorState
+
+	// The commands translated and their asm, when something needs them
+	translatedCommands []translatedCommand
+}
+
+func newTranslator() *translator {
+	return &translator{
+		translatorState: translatorState{
+			FuncStack:       Stack{Current: "Sys.init"},
+			StaticAddresses: map[string]int{},
+			SharedRoutines:  map[string]bool{},
+		},
+	}
 }
 
-var funcStack = Stack{
-	current:       "Sys.init",
-	returnCounter: 0,
+// The translation in progress
+var translation = newTranslator()
+
+// Forgets everything a translation has counted and collected, so the next
+// one in the same process comes out as if it were the first
+func resetTranslation() {
+	translation = newTranslator()
+	sourceFiles = nil
+	sourceFilesRead = map[string]bool{}
+}
+
+// Subcommands take the same flags as a plain translation, plus their own
+var subcommands = map[string]func(args []string){
+	"disasm":       disassembleCommand,
+	"fmt":          formatCommand,
+	"ir":           irCommand,
+	"deps":         dependenciesCommand,
+	"link":         linkCommand,
+	"cmp":          compareCommand,
+	"explain":      explainCommand,
+	"scaffold":     scaffoldCommand,
+	"table":        tableCommand,
+	"run":          runCommand,
+	"emulate":      emulateCommand,
+	"tst":          testScriptCommand,
+	"test":         testCommand,
+	"debug":        debugCommand,
+	"profile":      profileCommand,
+	"coverage":     coverageCommand,
+	"bench":        benchCommand,
+	"corpus":       corpusCommand,
+	"differential": differentialCommand,
+	"grade":        gradeCommand,
+	"serve":        serveCommand,
+	"stdio":        stdioCommand,
+	"daemon":       daemonCommand,
+	"dap":          dapCommand,
+	"lsp":          lspCommand,
 }
 
 func main() {
 	var instructions []string
-	var filename string
 	var err error
 
-	bootstrap := flag.Bool("bootstrap", false, "include bootstrapping instructions")
-	setStackPointer := flag.Bool("setStackPointer", false, "set the stack pointer to 256")
-	endWithLoop := flag.Bool("endWithLoop", false, "end with infinite loop")
-	passedPath := flag.String("path", "", "path to folder or file to translate")
-	flag.Parse()
+	args := os.Args[1:]
 
-	shouldBootstrap = *bootstrap
-	shouldSetStackPointer = *setStackPointer
-	shouldEndWithLoop = *endWithLoop
-	pathToTranslate = *passedPath
+	if len(args) > 0 {
+		if subcommand, ok := subcommands[args[0]]; ok {
+			subcommand(args[1:])
+			return
+		}
+	}
+
+	parseFlags(args)
 
 	if pathToTranslate == "" {
 		log.Fatal("no file or folder specified")
 	}
 
+	if err := runHooks(preHooks, asmOutputPath()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Whatever is written, once it has been; a failed translation exits first
+	defer func() {
+		if err := runHooks(postHooks, asmOutputPath()); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// Profiles cover the translation, not the hooks around it
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer func() {
+		if err := stopProfiling(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	if emitMode == "vm-bundle" {
+		if err := saveBundle(); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if emitMode == "asmobj" {
+		if err := saveObjects(); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if backend, ok := backends[backendName]; ok {
+		if err := emitBackend(backend); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	ext := path.Ext(pathToTranslate)
 
-	if ext == ".vm" {
-		instructions, err = parseFile(pathToTranslate)
+	if ext != ".vm" && ext != "" {
+		log.Fatal("invalid file extension")
+	}
+
+	// Unless the asm is needed as a whole, it's written as it's translated
+	if canStreamOutput() {
+		if err := streamTranslation(asmOutputPath()); err != nil {
+			log.Fatal(err)
+		}
+	} else if ext == ".vm" {
+		commands, err := parseFile(pathToTranslate)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		filename = strings.TrimSuffix(pathToTranslate, ext) + ".asm"
-	} else if ext == "" {
-		instructions, err = loadFolder(pathToTranslate)
+		if osFolder != "" {
+			commands, err = includeOS(commands, osFolder)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		commands, err = optimize(commands)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		instructions, err = translate(commands)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		filename = getFolderName() + ".asm"
+		save(instructions, asmOutputPath())
 	} else {
-		log.Fatal("invalid file extension")
+		instructions, err = loadFolder(pathToTranslate)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		save(instructions, asmOutputPath())
 	}
 
-	save(instructions, filename)
+	if shouldWriteDependencies {
+		if err := saveDependencies(asmOutputPath()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if reportPath != "" {
+		if err := saveReport(instructions); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
-func save(instructions []string, fileName string) {
-	var saveToFolderPath string
+// Registers the translation flags, parses the args and applies them
+func parseFlags(args []string) {
+	bootstrap := flag.Bool("bootstrap", false, "include bootstrapping instructions")
+	setStackPointer := flag.Bool("setStackPointer", false, "set the stack pointer to 256")
+	standardBootstrap := flag.Bool("standard-bootstrap", false, "emit the canonical SP=256 / call Sys.init bootstrap, or a call to -entry (overrides -bootstrap and -setStackPointer)")
+	entry := flag.String("entry", "Sys.init", "the function the bootstrap calls, e.g. Main.main for a program without an OS")
+	entryArguments := flag.Int("entry-args", 0, "how many arguments the bootstrap calls -entry with, each 0")
+	extendedALU := flag.Bool("extended-alu", false, "target a Hack CPU with native << / >> compute instructions")
+	optLevel := flag.Int("O", 0, "optimization level (0 = none, 1 = cheaper addressing, 2 = IR passes)")
+	inlineSize := flag.Int("inline-threshold", 8, "inline functions with at most this many commands (-O=2)")
+	inlineCalls := flag.Int("inline-max-calls", 3, "inline functions called from at most this many places (-O=2)")
+	layout := flag.String("routines", "before", "where to put the shared routines: before (behind a trampoline), after, or used (after, only those referenced); after and used imply an epilogue")
+	stackGuards := flag.Bool("stack-guards", false, "trap to STACK_OVERFLOW / STACK_UNDERFLOW when SP leaves 256-2047")
+	pointerGuards := flag.Bool("pointer-guards", false, "check THIS/THAT point into RAM before this/that accesses")
+	pointerHandler := flag.String("pointer-guard-handler", defaultPointerGuardHandler, "label the pointer guards jump to (generated unless overridden)")
+	tempBase := flag.Int("temp-base", memory.Temp, "RAM address of the temp segment")
+	staticBase := flag.Int("static-base", -1, "first RAM address for statics (-1 leaves them to the assembler)")
+	globalBase := flag.Int("global-base", -1, "RAM address of the global segment (-dialect=extended)")
+	stackBase := flag.Int("stack-base", memory.Stack, "RAM address the stack starts at")
+	heapBase := flag.Int("heap-base", memory.Heap, "RAM address the heap starts at (the stack ends just below)")
+	screenBase := flag.Int("screen-base", memory.Screen, "RAM address of the screen memory map")
+	keyboardAddress := flag.Int("keyboard", memory.Keyboard, "RAM address of the keyboard memory map")
+	bankSize := flag.Int("bank-size", 0, "split the output into .bankN.asm files of at most this many instructions, plus a .banks.json manifest")
+	annotate := flag.Bool("annotate", false, "precede each command's asm with a comment holding the command")
+	emit := flag.String("emit", "asm", "what to write: asm, vm-bundle (every .vm file normalized into one, beside the folder) asmobj (a .asmobj per .vm file, for the link subcommand) or archive (a .zip of the asm, source map, symbols and statistics)")
+	withOS := flag.String("with-os", "", "folder of OS .vm files (Math.vm, Memory.vm, ...) to include when the program calls into them")
+	diff := flag.Bool("diff", false, "print a unified diff against the existing .asm instead of writing it, exiting 1 if they differ")
+	pretty := flag.Bool("pretty", false, "lay the asm out for reading: banners per file, function and routine, indented bodies and blank lines between commands")
+	strip := flag.Bool("strip", false, "leave out comments, blank lines and labels nothing jumps to")
+	backend := flag.String("backend", "hack", "what to translate to: hack (asm), c (a C program using switch dispatch) llvm (experimental LLVM IR) or wat (a WebAssembly text module)")
+	explainOutput := flag.Bool("explain-output", false, "precede each command's asm with plain-English commentary on what it does")
+	report := flag.String("report", "", "also write a report to this file; .html shows each command beside its asm, .md or .txt give statistics")
+	writeDependencies := flag.Bool("MD", false, "also write a .d file beside the asm listing every file it came from, for make and ninja")
+	emitSymbols := flag.Bool("sym", false, "also write a .sym file giving the ROM address of every label and the RAM address of every variable")
+	configPath := flag.String("config", "", "JSON file of flag values, overridden by flags given on the command line")
+	endWithLoop := flag.Bool("endWithLoop", false, "end with infinite loop (same as -epilogue=loop)")
+	epilogue := flag.String("epilogue", "none", "how to end the program: loop, jump, halt, sentinel or none")
+	epilogueTarget := flag.String("epilogue-label", "INFINITE_LOOP", "label used by the loop and jump epilogues")
+	haltAt := flag.Int("halt-address", -1, "RAM address the sentinel epilogue writes to")
+	haltWith := flag.Int("halt-value", -1, "value the sentinel epilogue writes")
+	passedPath := flag.String("path", "", "path to folder or file to translate")
+	output := flag.String("o", "", "file to write the output to, instead of beside the file or in the folder translated")
+	generated := flag.Bool("generated", false, "begin the asm with a comment marking it as generated, for go:generate")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "translate up to this many files at once")
+	lineLength := flag.Int("max-line-length", maxLineLength, "longest line, in bytes, read from a .vm file")
+	maxInstructions := flag.Int("max-output-instructions", maxOutputInstructions, "fail the build, listing the largest functions, if the output has more instructions than this (0 for no limit)")
+	language := flag.String("dialect", "standard", "the VM language: standard, as the course defines it, or extended (adds the global segment, push string, shl, shr, mult, div, mod, min, max and abs, breakpoint, macros, include, const and alias, asm, //!static-base and block comments)")
+	breakpoints := flag.String("breakpoint", "nop", "what the breakpoint command is translated to: nop (an instruction the emulator and debugger stop at) or trap (a jump to BREAKPOINT, which spins)")
+	labels := flag.String("labels", "function", "what labels are scoped to: function, or file (for control flow outside any function)")
+	endings := flag.String("line-endings", "lf", "how the lines of the asm written end: lf, or crlf for Windows tools")
+	lowercase := flag.String("lowercase-files", "", "what to do about a .vm file whose name doesn't start with an uppercase letter: warn, or allow (warn with -dialect=standard, allow with -dialect=extended)")
+	stringLiterals := flag.String("strings", "data", "how push string is translated (-dialect=extended): data (the characters kept beside the statics) or os (String.new and String.appendChar calls)")
+	favor := flag.String("favor", "speed", "what to favor where they pull apart: speed, or size (pushes and pops of common shapes go through shared routines)")
+	cpuProfile := flag.String("cpuprofile", "", "write a pprof CPU profile of the translation to this file")
+	memProfile := flag.String("memprofile", "", "write a pprof heap profile to this file once the translation is done")
+	vmcache := flag.Bool("vmcache", false, "keep each file's translation in a .vmcache folder beside the .vm files, and reuse it while the file, flags and translator are unchanged (-O=0 and -O=1)")
+	var passes stringList
+	flag.Var(&passes, "pass", "shell command the IR goes through before translation, reading and writing it as JSON (repeatable)")
+	var pre, post stringList
+	flag.Var(&pre, "pre", "shell command to run before translating, e.g. a Jack compiler (repeatable)")
+	flag.Var(&post, "post", "shell command to run after translating, e.g. the assembler; $VM_ASM is the asm written (repeatable)")
+	flag.CommandLine.Parse(args)
+
+	if *configPath != "" {
+		if err := loadConfig(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	shouldBootstrap = *bootstrap
+	shouldSetStackPointer = *setStackPointer
+	shouldStandardBootstrap = *standardBootstrap
+	entryFunction = *entry
+	entryArgs = *entryArguments
+	epilogueMode = *epilogue
+	epilogueLabel = *epilogueTarget
+	haltAddress = *haltAt
+	haltValue = *haltWith
+	useExtendedALU = *extendedALU
+	optimizationLevel = *optLevel
+	inlineThreshold = *inlineSize
+	inlineMaxCalls = *inlineCalls
+	routineLayout = *layout
+	useStackGuards = *stackGuards
+	usePointerGuards = *pointerGuards
+	pointerGuardHandler = *pointerHandler
+	romBankSize = *bankSize
+	shouldAnnotate = *annotate
+	shouldEmitSymbols = *emitSymbols
+	shouldWriteDependencies = *writeDependencies
+	emitMode = *emit
+	reportPath = *report
+	osFolder = *withOS
+	shouldDiff = *diff
+	prettyOutput = *pretty
+	shouldStrip = *strip
+	backendName = *backend
+	shouldExplain = *explainOutput
+	externalPasses = passes
+	preHooks = pre
+	postHooks = post
+	memory = MemoryMap{
+		Temp:     *tempBase,
+		Static:   *staticBase,
+		Stack:    *stackBase,
+		Heap:     *heapBase,
+		Screen:   *screenBase,
+		Keyboard: *keyboardAddress,
+		Global:   *globalBase,
+	}
+	pathToTranslate = *passedPath
+	outputPath = *output
+	shouldMarkGenerated = *generated
+	translationJobs = *jobs
+	useBuildCache = *vmcache
+	favorMode = *favor
+	dialect = *language
+	lowercaseFiles = *lowercase
+	lineEndings = *endings
+	labelScope = *labels
+	stringMode = *stringLiterals
+	breakpointMode = *breakpoints
+	maxLineLength = *lineLength
+	maxOutputInstructions = *maxInstructions
+	cpuProfilePath = *cpuProfile
+	memProfilePath = *memProfile
+
+	if *endWithLoop {
+		epilogueMode = "loop"
+	}
+
+	if epilogueLabel == "" {
+		log.Fatal("epilogue label cannot be empty")
+	}
+
+	if maxLineLength < 1 {
+		log.Fatalf("invalid max line length: %d", maxLineLength)
+	}
+
+	if maxOutputInstructions < 0 {
+		log.Fatalf("invalid max output instructions: %d", maxOutputInstructions)
+	}
+
+	if dialect != "standard" && dialect != "extended" {
+		log.Fatalf("invalid dialect: %s", dialect)
+	}
+
+	if lowercaseFiles == "" {
+		lowercaseFiles = "allow"
+		if dialect == "standard" {
+			lowercaseFiles = "warn"
+		}
+	}
+
+	if entryFunction == "" {
+		log.Fatal("entry function cannot be empty")
+	}
+
+	if entryArgs < 0 || entryArgs > 255 {
+		log.Fatalf("invalid entry args: %d", entryArgs)
+	}
+
+	if labelScope != "function" && labelScope != "file" {
+		log.Fatalf("invalid labels: %s", labelScope)
+	}
+
+	if lineEndings != "lf" && lineEndings != "crlf" {
+		log.Fatalf("invalid line endings: %s", lineEndings)
+	}
+
+	if lowercaseFiles != "warn" && lowercaseFiles != "allow" {
+		log.Fatalf("invalid lowercase files: %s", lowercaseFiles)
+	}
+
+	if stringMode != "data" && stringMode != "os" {
+		log.Fatalf("invalid strings: %s", stringMode)
+	}
+
+	if breakpointMode != "nop" && breakpointMode != "trap" {
+		log.Fatalf("invalid breakpoint: %s", breakpointMode)
+	}
+
+	if favorMode != "speed" && favorMode != "size" {
+		log.Fatalf("invalid favor: %s", favorMode)
+	}
+
+	// Objects are translated apart, but shared routines are chosen and
+	// written for the whole program
+	if favorMode == "size" && emitMode == "asmobj" {
+		log.Fatal("-favor=size can't be used with -emit=asmobj")
+	}
+
+	if translationJobs < 1 {
+		log.Fatalf("invalid jobs: %d", translationJobs)
+	}
+
+	if err := memory.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if routineLayout != "before" && routineLayout != "after" && routineLayout != "used" {
+		log.Fatalf("invalid routine layout: %s", routineLayout)
+	}
+
+	if emitMode != "asm" && emitMode != "vm-bundle" && emitMode != "asmobj" && emitMode != "archive" {
+		log.Fatalf("invalid emit mode: %s", emitMode)
+	}
+
+	if _, ok := backends[backendName]; !ok && backendName != "hack" {
+		log.Fatalf("invalid backend: %s", backendName)
+	}
+}
+
+// Writes the asm to outputFilename, along with whatever the flags ask for
+// beside it, named after it
+func save(instructions []string, outputFilename string) {
+	// What the other files are named after
+	outputBase := strings.TrimSuffix(outputFilename, path.Ext(outputFilename))
 
-	info, err := os.Stat(pathToTranslate)
+	if emitMode == "archive" {
+		if err := saveArchive(instructions, outputBase+".zip"); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	total := 0
+	for _, instruction := range instructions {
+		total += instructionCount(instruction)
+	}
+
+	err := checkOutputSize(total, func() (string, error) {
+		return strings.Join(instructions, ""), nil
+	})
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal(err)
+	}
+
+	if shouldStrip {
+		instructions = stripAsm(instructions)
+	}
+
+	if shouldMarkGenerated {
+		instructions = append([]string{generatedHeader()}, instructions...)
+	}
+
+	if shouldDiff {
+		differs, err := diffOutput(instructions, outputFilename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if differs {
+			os.Exit(1)
+		}
+
 		return
 	}
 
-	if info.IsDir() {
-		saveToFolderPath = pathToTranslate
-	} else {
-		saveToFolderPath = filepath.Dir(pathToTranslate)
+	if romBankSize > 0 {
+		err := saveBanks(instructions, filepath.Dir(outputBase), filepath.Base(outputBase))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
 	}
 
-	// Save to file
-	extension := path.Ext(fileName)
-	outputFilename := strings.TrimSuffix(fileName, extension) + ".asm"
-	//fmt.Println(pathToSave + "/" + outputFilename)
-	outputFile, err := os.Create(saveToFolderPath + "/" + outputFilename)
+	outputFile, err := os.Create(outputFilename)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -104,105 +631,416 @@ func save(instructions []string, fileName string) {
 	defer writer.Flush()
 
 	for _, instruction := range instructions {
-		writer.WriteString(instruction)
+		writer.WriteString(withLineEndings(instruction))
+	}
+
+	if shouldEmitSymbols {
+		symbols := symbolFile(resolveSymbols(asmLines(instructions)))
+		if err := os.WriteFile(outputBase+".sym", []byte(symbols), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Asm as it's written out, its lines ending as -line-endings says
+func withLineEndings(asm string) string {
+	if lineEndings != "crlf" {
+		return asm
+	}
+
+	return strings.ReplaceAll(asm, "\n", "\r\n")
+}
+
+// The .vm files a path refers to, either the file itself or a folder's contents
+func vmFiles(pathName string) ([]string, error) {
+	if pathName == "" {
+		return nil, fmt.Errorf("no file or folder specified")
+	}
+
+	if path.Ext(pathName) == ".vm" {
+		return []string{pathName}, nil
+	}
+
+	files, err := filepath.Glob(pathName + "/*.vm")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .vm files found in folder")
 	}
+
+	return files, nil
 }
 
 func loadFolder(folderName string) ([]string, error) {
 	// If not, look for `.vm` files within the current folder and translate all of them
-	files, err := filepath.Glob(folderName + "/*.vm")
+	files, err := vmFiles(folderName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if len(files) == 0 {
-		log.Fatal("no .vm files found in folder")
+	return layoutProgram(func() ([]string, error) {
+		if canUseBuildCache() {
+			out := collectAsm()
+			err := translateFilesCached(out, files)
+			return out.pieces, err
+		}
+
+		commands, err := parseFiles(files)
+		if err != nil {
+			return nil, err
+		}
+
+		if osFolder != "" {
+			commands, err = includeOS(commands, osFolder)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The whole program is parsed before translating so that passes can
+		// see across files
+		commands, err = optimize(commands)
+		if err != nil {
+			return nil, err
+		}
+
+		return translate(commands)
+	})
+}
+
+// Surrounds the program with the bootstrap, epilogue and shared routines as
+// the layout asks. The program is only produced once the bootstrap has been,
+// so the bootstrap's return label is numbered first
+func layoutProgram(program func() ([]string, error)) ([]string, error) {
+	out := collectAsm()
+
+	err := layoutProgramTo(out, func(out *asmSink) error {
+		lines, err := program()
+		if err != nil {
+			return err
+		}
+
+		return out.writeAll(lines)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	instructions := []string{
-		"(START)\n",
+	return out.pieces, nil
+}
+
+// Lays the program out into a sink, which program writes its asm to
+func layoutProgramTo(out *asmSink, program func(out *asmSink) error) error {
+	var bootstrap []string
+
+	if shouldStandardBootstrap {
+		// The canonical bootstrap sits at ROM address 0, so there's no need for
+		// the `@START` trampoline; Sys.init never returns into the routines
+		init, err := translation.callEntry()
+		if err != nil {
+			return err
+		}
+
+		bootstrap = []string{setStackPointerInstructions(), init}
+	} else if shouldSetStackPointer {
+		bootstrap = []string{setStackPointerInstructions()}
 	}
 
-	if shouldBootstrap {
-		init, err := callFunction("Sys.init", "0")
+	if routineLayout == "before" {
+		// Which routines are needed is only known once the program has been
+		// translated, so it's held back until they've been written
+		held, err := out.hold()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		instructions = append(instructions, init)
+		if !shouldStandardBootstrap {
+			bootstrap = append(bootstrap, startInstructions())
+
+			if err := held.write("(START)\n"); err != nil {
+				return err
+			}
+		}
+
+		if err := layoutBody(held, program); err != nil {
+			return err
+		}
+
+		if err := out.writeAll(bootstrap); err != nil {
+			return err
+		}
+
+		if err := out.writeAll(layoutRoutines(nil)); err != nil {
+			return err
+		}
+
+		if err := out.release(held); err != nil {
+			return err
+		}
+
+		end, err := createEpilogue()
+		if err != nil {
+			return err
+		}
+
+		return out.writeAll(end)
+	}
+
+	// With the routines after the program, execution starts at the top and
+	// there's no trampoline to jump over them. The program mustn't fall
+	// through into them though
+	if epilogueMode == "none" {
+		epilogueMode = "loop"
 	}
 
-	for _, file := range files {
-		lines, err := parseFile(file)
+	if err := out.writeAll(bootstrap); err != nil {
+		return err
+	}
+
+	if err := layoutBody(out, program); err != nil {
+		return err
+	}
+
+	end, err := createEpilogue()
+	if err != nil {
+		return err
+	}
+
+	if err := out.writeAll(end); err != nil {
+		return err
+	}
+
+	return out.writeAll(layoutRoutines(out.referenced))
+}
+
+// The program, after the call to the entry function unless the standard
+// bootstrap has made it already
+func layoutBody(out *asmSink, program func(out *asmSink) error) error {
+	if shouldBootstrap && !shouldStandardBootstrap {
+		init, err := translation.callEntry()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		instructions = append(instructions, lines...)
+		if err := out.write(init); err != nil {
+			return err
+		}
 	}
 
-	// Needs to go here instead
-	instructions = prependFunctions(instructions)
-	instructions = prependStartInstructions(instructions)
+	return program(out)
+}
+
+// The shared routines as they appear in the output
+func layoutRoutines(referenced map[string]bool) []string {
+	routines := createRoutines(referenced)
+	if prettyOutput {
+		routines = prettyRoutines(routines)
+	}
 
-	if shouldEndWithLoop {
+	return routines
+}
+
+func createEpilogue() ([]string, error) {
+	switch epilogueMode {
+	case "loop":
 		infiniteLoop := strings.Join([]string{
-			"(INFINITE_LOOP)",
-			"@INFINITE_LOOP",
+			fmt.Sprintf("(%s)", epilogueLabel),
+			fmt.Sprintf("@%s", epilogueLabel),
 			"0;JMP",
 		}, "\n") + "\n"
 
-		instructions = append(instructions, infiniteLoop)
+		return []string{infiniteLoop}, nil
+
+	case "jump":
+		// The label is expected to be defined elsewhere (e.g. in hand-written asm)
+		jump := strings.Join([]string{
+			fmt.Sprintf("@%s", epilogueLabel),
+			"0;JMP",
+		}, "\n") + "\n"
+
+		return []string{jump}, nil
+
+	case "halt":
+		return []string{haltLoop()}, nil
+
+	case "sentinel":
+		if haltAddress < 0 || haltAddress > 32767 {
+			return nil, fmt.Errorf("sentinel epilogue needs a -halt-address between 0 and 32767")
+		}
+
+		value, err := loadConstant(haltValue)
+		if err != nil {
+			return nil, err
+		}
+
+		lines := append(value,
+			fmt.Sprintf("@%d", haltAddress),
+			"M=D",
+		)
+
+		return []string{strings.Join(lines, "\n") + "\n", haltLoop()}, nil
+
+	case "none":
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("invalid epilogue: %s", epilogueMode)
+}
+
+// Emulators and test harnesses can watch for the PC reaching `(HALT)`
+func haltLoop() string {
+	return strings.Join([]string{
+		"(HALT)",
+		"@HALT",
+		"0;JMP",
+	}, "\n") + "\n"
+}
+
+// Puts any 16-bit value into the D register. A-instructions only take 15 bits,
+// so negative values are built by negating
+func loadConstant(value int) ([]string, error) {
+	switch {
+	case value < -32768 || value > 32767:
+		return nil, fmt.Errorf("constant out of range: %d", value)
+
+	case value == -32768:
+		return []string{"@32767", "D=-A", "D=D-1"}, nil
+
+	case value < 0:
+		return []string{fmt.Sprintf("@%d", -value), "D=-A"}, nil
 	}
 
-	return instructions, nil
+	return []string{fmt.Sprintf("@%d", value), "D=A"}, nil
+}
+
+// Parses the files in order into a single command stream
+func parseFiles(files []string) ([]Command, error) {
+	return newIncludeSet(files).parseFiles(files)
 }
 
-var currentFile string
+// Parses a file and the files it includes
+func parseFile(fileName string) ([]Command, error) {
+	return newIncludeSet([]string{fileName}).parseFile(fileName, nil)
+}
 
-func parseFile(fileName string) ([]string, error) {
-	// Check first letter of filename is uppercase
-	if !strings.HasPrefix(fileName, strings.ToUpper(fileName[:1])) {
-		log.Fatal("file must start with an uppercase letter")
+// Parses a file as it's written, includes and all
+func parseSourceFile(fileName string) ([]Command, error) {
+	// The course's files are named after their classes, but a library or
+	// scratch file needn't be
+	if base := filepath.Base(fileName); lowercaseFiles == "warn" && !strings.HasPrefix(base, strings.ToUpper(base[:1])) {
+		log.Printf("warning: %s: file name doesn't start with an uppercase letter", fileName)
 	}
 
 	// Check extension is .vm
 	if path.Ext(fileName) != ".vm" {
-		log.Fatal("file must have .vm extension")
+		return nil, fmt.Errorf("file must have .vm extension")
 	}
 
-	currentFile = filepath.Base(fileName)
+	recordSourceFile(fileName)
+
+	if cache != nil {
+		if commands, ok := cache.parsed(fileName); ok {
+			return commands, nil
+		}
+	}
 
 	file, err := os.Open(fileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
-	parser := NewParser()
+	parser := NewParser(filepath.Base(fileName))
 
-	output, err := parser.Parse(scanner)
-	if err != nil {
-		log.Fatal(err)
+	commands, err := parser.Parse(scanner)
+	if err == nil && cache != nil {
+		cache.storeParsed(fileName, commands)
 	}
 
-	return output, nil
+	return commands, err
 }
 
-func NewParser() *Parser {
-	return &Parser{}
+func NewParser(file string) *Parser {
+	return &Parser{file: file}
 }
 
-func prependFunctions(instructions []string) []string {
-	// Prepend the functions
+// Every shared routine the program may need, each starting with its label
+func allRoutines() []string {
 	functions := createReturnRoutine()
 	functions = append(functions, createCallRoutine()...)
 	functions = append(functions, createLtRoutine()...)
 	functions = append(functions, createGtRoutine()...)
 	functions = append(functions, createEqRoutine()...)
 
-	return append(functions, instructions...)
+	if useStackGuards {
+		functions = append(functions, createStackTraps()...)
+	}
+
+	// A custom handler is expected to be provided elsewhere
+	if usePointerGuards && pointerGuardHandler == defaultPointerGuardHandler {
+		functions = append(functions, createPointerTrap()...)
+	}
+
+	if breakpointMode == "trap" {
+		functions = append(functions, createBreakpointTrap()...)
+	}
+
+	if translation.LeafReturnCount > 0 {
+		functions = append(functions, createLeafReturnRoutine()...)
+	}
+
+	// The shift routines are fairly long, so only include them when used
+	if translation.ShlCount > 0 {
+		functions = append(functions, createShlRoutine()...)
+	}
+
+	if translation.ShrCount > 0 {
+		functions = append(functions, createShrRoutine()...)
+	}
+
+	if translation.MultCount > 0 {
+		functions = append(functions, createMultRoutine()...)
+	}
+
+	if translation.DivCount > 0 {
+		functions = append(functions, createDivisionRoutine("DIV")...)
+	}
+
+	if translation.ModCount > 0 {
+		functions = append(functions, createDivisionRoutine("MOD")...)
+	}
+
+	functions = append(functions, createSharedRoutines(translation.SharedRoutines)...)
+
+	return functions
+}
+
+// Each routine starts with its label, e.g. `(CALL)`
+func routineName(routine string) string {
+	return strings.TrimPrefix(strings.SplitN(routine, ")", 2)[0], "(")
+}
+
+// Returns the shared routines. When laying out only the used routines, those
+// the referenced labels don't include are left out
+func createRoutines(referenced map[string]bool) []string {
+	functions := allRoutines()
+
+	if routineLayout != "used" {
+		return functions
+	}
+
+	used := []string{}
+	for _, function := range functions {
+		if referenced[routineName(function)] {
+			used = append(used, function)
+		}
+	}
+
+	return used
 }
 
 func createReturnRoutine() []string {
@@ -215,14 +1053,89 @@ func createReturnRoutine() []string {
 		"@LCL",
 		"A=M-D",
 		"D=M",
-
+
+		locRegister,
+		"M=D",
+
+		// Take the top of the working stack and put it at @ARG
+		"@SP",
+		"M=M-1",
+		"A=M",
+		"D=M",
+		"@ARG",
+		"A=M",
+		"M=D",
+
+		// Move the stack pointer
+		"@ARG",
+		"D=M+1",
+		"@SP",
+		"M=D",
+
+		// Restore THAT
+		"@LCL",
+		"A=M-1",
+		"D=M",
+		"@THAT",
+		"M=D",
+
+		// Restore THIS
+		"@LCL",
+		"D=M",
+		"@2",
+		"D=D-A",
+		"A=D",
+		"D=M",
+		"@THIS",
+		"M=D",
+
+		// Restore ARG
+		"@LCL",
+		"D=M",
+		"@3",
+		"D=D-A",
+		"A=D",
+		"D=M",
+		"@ARG",
+		"M=D",
+
+		// Restore LCL
+		"@LCL",
+		"D=M",
+		"@4",
+		"D=D-A",
+		"A=D",
+		"D=M",
+		"@LCL",
+		"M=D",
+
+		// Jump to the return address
+		locRegister,
+		"A=M",
+		"0;JMP",
+	}, "\n") + "\n"
+
+	return []string{returnFunction}
+}
+
+// Returns from a function that can't have changed THIS or THAT, so they're
+// left as they are rather than restored from the frame
+func createLeafReturnRoutine() []string {
+	returnFunction := strings.Join([]string{
+		"(RETURN_LEAF)",
+
+		// Put the return address in the location register
+		"@5",
+		"D=A",
+		"@LCL",
+		"A=M-D",
+		"D=M",
 		locRegister,
 		"M=D",
 
 		// Take the top of the working stack and put it at @ARG
 		"@SP",
-		"M=M-1",
-		"A=M",
+		"AM=M-1",
 		"D=M",
 		"@ARG",
 		"A=M",
@@ -234,29 +1147,11 @@ func createReturnRoutine() []string {
 		"@SP",
 		"M=D",
 
-		// Restore THAT
-		"@LCL",
-		"A=M-1",
-		"D=M",
-		"@THAT",
-		"M=D",
-
-		// Restore THIS
-		"@LCL",
-		"D=M",
-		"@2",
-		"D=D-A",
-		"A=D",
-		"D=M",
-		"@THIS",
-		"M=D",
-
 		// Restore ARG
 		"@LCL",
 		"D=M",
 		"@3",
-		"D=D-A",
-		"A=D",
+		"A=D-A",
 		"D=M",
 		"@ARG",
 		"M=D",
@@ -265,8 +1160,7 @@ func createReturnRoutine() []string {
 		"@LCL",
 		"D=M",
 		"@4",
-		"D=D-A",
-		"A=D",
+		"A=D-A",
 		"D=M",
 		"@LCL",
 		"M=D",
@@ -450,113 +1344,768 @@ func createEqRoutine() []string {
 	return []string{eqFunction}
 }
 
-func prependStartInstructions(instructions []string) []string {
-	setStackPointer := strings.Join([]string{
-		"@256",
+func setStackPointerInstructions() string {
+	return strings.Join([]string{
+		fmt.Sprintf("@%d", memory.Stack),
 		"D=A",
 		"@SP",
 		"M=D",
 	}, "\n") + "\n"
+}
+
+// Shifts x (second from top) left by y (top) places. The count is in the
+// locRegister throughout
+func createShlRoutine() []string {
+	var shift []string
+
+	if useExtendedALU {
+		shift = []string{
+			"@SP",
+			"A=M-1",
+			"M=M<<",
+		}
+	} else {
+		// Doubling is the same as shifting left once
+		shift = []string{
+			"@SP",
+			"A=M-1",
+			"D=M",
+			"M=D+M",
+		}
+	}
+
+	lines := []string{
+		"(SHL)",
+		"@R15",
+		"M=D",
+
+		"@SP",
+		"AM=M-1",
+		"D=M",
+		locRegister,
+		"M=D",
+
+		"(SHL_LOOP)",
+		locRegister,
+		"MD=M-1",
+		"@END_SHL",
+		"D;JLT",
+	}
+
+	lines = append(lines, shift...)
+	lines = append(lines,
+		"@SHL_LOOP",
+		"0;JMP",
+
+		"(END_SHL)",
+		"@R15",
+		"A=M",
+		"0;JMP",
+	)
+
+	return []string{strings.Join(lines, "\n") + "\n"}
+}
+
+// Arithmetic shift right of x (second from top) by y (top) places
+func createShrRoutine() []string {
+	if useExtendedALU {
+		shrFunction := strings.Join([]string{
+			"(SHR)",
+			"@R15",
+			"M=D",
+
+			"@SP",
+			"AM=M-1",
+			"D=M",
+			locRegister,
+			"M=D",
+
+			"(SHR_LOOP)",
+			locRegister,
+			"MD=M-1",
+			"@END_SHR",
+			"D;JLT",
+			"@SP",
+			"A=M-1",
+			"M=M>>",
+			"@SHR_LOOP",
+			"0;JMP",
+
+			"(END_SHR)",
+			"@R15",
+			"A=M",
+			"0;JMP",
+		}, "\n") + "\n"
+
+		return []string{shrFunction}
+	}
+
+	// Without a shifter, test each source bit from 1<<y upwards and set the
+	// matching destination bit from 1 upwards. x is parked just above the
+	// stack, the source bit lives in the valueRegister and the destination bit
+	// in the locRegister
+	shrFunction := strings.Join([]string{
+		"(SHR)",
+		"@R15",
+		"M=D",
+
+		// Count into the locRegister
+		"@SP",
+		"AM=M-1",
+		"D=M",
+		locRegister,
+		"M=D",
+
+		// Park x above the stack and clear the result
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@SP",
+		"A=M",
+		"M=D",
+		"@SP",
+		"A=M-1",
+		"M=0",
+
+		// Source bit = 1 << count
+		valueRegister,
+		"M=1",
+		"(SHR_SOURCE)",
+		locRegister,
+		"MD=M-1",
+		"@END_SHR_SOURCE",
+		"D;JLT",
+		valueRegister,
+		"D=M",
+		"M=D+M",
+		"@SHR_SOURCE",
+		"0;JMP",
+		"(END_SHR_SOURCE)",
+
+		// Destination bit = 1
+		locRegister,
+		"M=1",
+
+		// Copy bits until the source bit overflows
+		"(SHR_LOOP)",
+		valueRegister,
+		"D=M",
+		"@END_SHR_LOOP",
+		"D;JEQ",
+		"@SP",
+		"A=M",
+		"D=D&M",
+		"@SHR_NEXT",
+		"D;JEQ",
+		locRegister,
+		"D=M",
+		"@SP",
+		"A=M-1",
+		"M=D|M",
+		"(SHR_NEXT)",
+		valueRegister,
+		"D=M",
+		"M=D+M",
+		locRegister,
+		"D=M",
+		"M=D+M",
+		"@SHR_LOOP",
+		"0;JMP",
+		"(END_SHR_LOOP)",
+
+		// Sign extend by filling the remaining destination bits if x was negative
+		"@SP",
+		"A=M",
+		"D=M",
+		"@END_SHR",
+		"D;JGE",
+		"(SHR_FILL)",
+		locRegister,
+		"D=M",
+		"@END_SHR",
+		"D;JEQ",
+		"@SP",
+		"A=M-1",
+		"M=D|M",
+		locRegister,
+		"M=D+M",
+		"@SHR_FILL",
+		"0;JMP",
+
+		"(END_SHR)",
+		"@R15",
+		"A=M",
+		"0;JMP",
+	}, "\n") + "\n"
+
+	return []string{shrFunction}
+}
+
+// Jumps over the routines to the program
+func startInstructions() string {
+	return strings.Join([]string{
+		"@START",
+		"0;JMP",
+	}, "\n") + "\n"
+}
+
+// The longest line the parser reads, so a file that isn't VM code at all
+// can't make it buffer without limit
+var maxLineLength = 1 << 20
+
+// Blanks out the comments on a line, /* block */ comments as well as //
+// ones, leaving the code where it was. Says whether a block comment is still
+// open at the end of the line, for the next to start in. Neither starts in a
+// quoted string
+func blankComments(line string, inBlock bool) (string, bool) {
+	if !inBlock && !strings.Contains(line, "/*") && strings.IndexByte(line, '"') < 0 {
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			line = line[:comment]
+		}
+
+		return line, false
+	}
+
+	code := []byte(line)
+	inString := false
+
+	for i := 0; i < len(code); i++ {
+		switch {
+		case inBlock && strings.HasPrefix(line[i:], "*/"):
+			code[i], code[i+1] = ' ', ' '
+			inBlock = false
+			i++
+
+		case inBlock:
+			code[i] = ' '
+
+		case inString && line[i] == '\\':
+			i++
+
+		case line[i] == '"':
+			inString = !inString
+
+		case inString:
+
+		case strings.HasPrefix(line[i:], "//"):
+			return string(code[:i]), false
+
+		case strings.HasPrefix(line[i:], "/*"):
+			code[i], code[i+1] = ' ', ' '
+			inBlock = true
+			i++
+		}
+	}
+
+	return string(code), inBlock
+}
+
+// Reads commands a line at a time. Lines may be up to maxLineLength bytes,
+// and anything goes in comments, but commands must be UTF-8
+func (p *Parser) Parse(scanner *bufio.Scanner) ([]Command, error) {
+	size := 4096
+	if maxLineLength < size {
+		size = maxLineLength
+	}
+	scanner.Buffer(make([]byte, 0, size), maxLineLength)
+
+	commands := []Command{}
+	lineNumber := 0
+
+	// Where the block comment the parser is in, if any, began
+	inBlockComment := false
+	blockCommentLine := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := scanner.Text()
+
+		// As some Windows editors begin files
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		// Asm is kept as it's written, comments and all
+		if p.asmBlock != nil {
+			if strings.TrimSpace(line) == "}" {
+				commands = append(commands, *p.asmBlock)
+				p.asmBlock = nil
+			} else {
+				p.asmBlock.Args = append(p.asmBlock.Args, strings.TrimRight(line, " \t\r"))
+			}
+
+			continue
+		}
+
+		if asm, ok := inlineAsmLine(line); ok && !inBlockComment {
+			if err := p.needsExtended("asm", lineNumber); err != nil {
+				return nil, err
+			}
+
+			if p.defining != nil {
+				return nil, fmt.Errorf("%s:%d: asm can't be used in macro %s", p.file, lineNumber, p.defining.name)
+			}
+
+			commands = append(commands, Command{Kind: "asm", Args: []string{asm}, File: p.file, Line: lineNumber, Source: p.source})
+			continue
+		}
+
+		if value, ok := staticBaseLine(line); ok && !inBlockComment {
+			if err := p.needsExtended("//!static-base", lineNumber); err != nil {
+				return nil, err
+			}
+
+			if err := p.pinStatics(value, lineNumber); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		source, wasInBlockComment := line, inBlockComment
+		line, inBlockComment = blankComments(line, inBlockComment)
+
+		// Only block comments are blanked out rather than cut off
+		if line != source[:len(line)] {
+			if err := p.needsExtended("block comment", lineNumber); err != nil {
+				return nil, err
+			}
+		}
+
+		// Either one opens on this line, or one closes and another opens
+		if inBlockComment && (!wasInBlockComment || strings.Contains(source, "*/")) {
+			blockCommentLine = lineNumber
+		}
+
+		if where, ok := sourceComment(source[len(line):]); ok {
+			p.source = where
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		if !utf8.ValidString(line) {
+			return nil, fmt.Errorf("%s:%d: command isn't valid UTF-8: %q", p.file, lineNumber, line)
+		}
+
+		if opensAsmBlock(line) {
+			if err := p.needsExtended("asm", lineNumber); err != nil {
+				return nil, err
+			}
+
+			if p.defining != nil {
+				return nil, fmt.Errorf("%s:%d: asm can't be used in macro %s", p.file, lineNumber, p.defining.name)
+			}
+
+			p.asmBlock = &Command{Kind: "asm", Args: []string{}, File: p.file, Line: lineNumber, Source: p.source}
+			continue
+		}
+
+		parsed := len(commands)
+
+		var err error
+		commands, err = p.parseCommand(commands, line, lineNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := parsed; i < len(commands); i++ {
+			commands[i].Source = p.source
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, fmt.Errorf("%s:%d: line longer than %d bytes (see -max-line-length)", p.file, lineNumber+1, maxLineLength)
+		}
+
+		return nil, fmt.Errorf("%s:%d: %w", p.file, lineNumber+1, err)
+	}
+
+	if inBlockComment {
+		return nil, fmt.Errorf("%s:%d: block comment isn't closed", p.file, blockCommentLine)
+	}
+
+	if p.defining != nil {
+		return nil, fmt.Errorf("%s:%d: macro %s isn't closed by endmacro", p.file, p.defining.line, p.defining.name)
+	}
+
+	if p.asmBlock != nil {
+		return nil, fmt.Errorf("%s:%d: asm block isn't closed by }", p.file, p.asmBlock.Line)
+	}
+
+	return p.pinnedStatics(commands)
+}
+
+// Adds the command on a line, or those of the macro it uses
+func (p *Parser) parseCommand(commands []Command, line string, lineNumber int) ([]Command, error) {
+	commands, isMacro, err := p.macroLine(commands, line, lineNumber)
+	if isMacro || err != nil {
+		return commands, err
+	}
+
+	if literal, ok := pushedString(line); ok {
+		return p.pushString(commands, literal, lineNumber)
+	}
+
+	fields := strings.Fields(line)
+
+	if n, ok := commandArity[fields[0]]; ok && len(fields)-1 != n {
+		return nil, fmt.Errorf("%s:%d: wrong number of arguments: %s", p.file, lineNumber, strings.Join(fields, " "))
+	}
+
+	if internalCommands[fields[0]] {
+		return nil, fmt.Errorf("%s:%d: invalid command: %s", p.file, lineNumber, strings.Join(fields, " "))
+	}
+
+	if err := p.checkStandard(fields, lineNumber); err != nil {
+		return nil, err
+	}
+
+	switch fields[0] {
+	case "const":
+		return commands, p.defineConstant(fields[1], "", fields[2], lineNumber)
+
+	case "alias":
+		return commands, p.defineConstant(fields[1], fields[2], fields[3], lineNumber)
+
+	case "push", "pop":
+		if err := p.resolveIndex(fields, lineNumber); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(commands, Command{
+		Kind: fields[0],
+		Args: fields[1:],
+		File: p.file,
+		Line: lineNumber,
+	}), nil
+}
+
+func translate(commands []Command) ([]string, error) {
+	out := collectAsm()
+	if err := translateTo(out, commands); err != nil {
+		return nil, err
+	}
+
+	return out.pieces, nil
+}
+
+// Translates the commands a command at a time into a sink, the files of a
+// program several at once
+func translateTo(out *asmSink, commands []Command) error {
+	if translationJobs > 1 {
+		return translateConcurrently(out, fileChunks(commands))
+	}
+
+	return translation.translateTo(out, commands)
+}
+
+func (t *translator) translateTo(out *asmSink, commands []Command) error {
+	for _, command := range commands {
+		// Statics are named after the file they're declared in
+		t.CurrentFile = command.File
+		t.StaticBase = command.StaticBase
+
+		output, err := t.translateCommand(command)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
 
-	start := strings.Join([]string{
-		"@START",
-		"0;JMP",
-	}, "\n") + "\n"
+		if usePointerGuards {
+			output = pointerGuard(command) + output
+		}
 
-	if shouldSetStackPointer {
-		return append([]string{setStackPointer, start}, instructions...)
-	}
+		if shouldExplain {
+			commentary := ""
+			for _, line := range t.explanation(command) {
+				commentary += "// " + line + "\n"
+			}
 
-	return append([]string{start}, instructions...)
-}
+			output = commentary + output
+		}
 
-func (p *Parser) Parse(scanner *bufio.Scanner) ([]string, error) {
-	instructions := []string{}
+		if shouldAnnotate {
+			output = "// " + command.String() + "\n" + output
+		}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+		output = t.sourceAnnotation(command) + output
 
-		if strings.HasPrefix(line, "//") || line == "" {
-			continue
+		if useStackGuards {
+			output += stackGuard(command.Kind)
 		}
 
-		line = strings.Split(line, "//")[0]
-		line = strings.TrimSpace(line)
+		if prettyOutput {
+			output = t.prettyCommand(command, output)
+		}
 
-		output, err := parseCommand(line)
-		if err != nil {
-			log.Fatal(err)
+		if reportPath != "" || emitMode == "archive" || shouldKeepCommands {
+			t.translatedCommands = append(t.translatedCommands, translatedCommand{command, output})
+		}
+
+		if err := out.write(output); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// Joins asm lines into a block, each line ending in a newline. The block is
+// built in one allocation, rather than joined and then given its last newline
+func joinLines(lines []string) string {
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+
+	var block strings.Builder
+	block.Grow(size)
 
-		instructions = append(instructions, output)
+	for _, line := range lines {
+		block.WriteString(line)
+		block.WriteByte('\n')
 	}
 
-	return instructions, nil
+	return block.String()
 }
 
-func parseCommand(line string) (string, error) {
-	command := strings.Fields(line)
+func (t *translator) translateCommand(c Command) (string, error) {
+	if n, ok := commandArity[c.Kind]; ok && len(c.Args) != n {
+		return "", fmt.Errorf("invalid command: %s", c)
+	}
+
+	// Only the optimizer writes its own commands
+	if internalCommands[c.Kind] && !c.Synthetic {
+		return "", fmt.Errorf("invalid command: %s", c)
+	}
 
-	first := command[0]
+	// The commands the optimizer writes take a label or name
+	switch c.Kind {
+	case "inline-enter", "inline-return", "if-eq-goto", "if-gt-goto", "if-lt-goto":
+		if len(c.Args) != 1 {
+			return "", fmt.Errorf("invalid command: %s", c)
+		}
+	}
 
-	switch first {
+	switch c.Kind {
 	case "function":
-		return function(command[1], command[2])
+		return t.function(c.Args[0], c.Args[1])
 
 	case "call":
-		return callFunction(command[1], command[2])
+		return t.callFunction(c.Args[0], c.Args[1])
 
 	case "return":
-		return returnFromFunction(), nil
+		return returnAsm, nil
+
+	case "return-leaf":
+		return t.returnFromLeafFunction(), nil
 
 	case "goto":
-		return gotoLabel(command[1]), nil
+		return t.gotoLabel(c.Args[0]), nil
 
 	case "if-goto":
-		return ifGoto(command[1]), nil
+		return t.ifGoto(c.Args[0]), nil
 
 	case "label":
-		return label(command[1]), nil
+		return t.label(c.Args[0]), nil
+
+	case "cached-push", "cached-pop":
+		return cachedAccess(c)
+
+	case "shared-push", "shared-pop":
+		return t.sharedAccess(c)
+
+	case "asm":
+		return joinLines(c.Args), nil
+
+	case "breakpoint":
+		return breakpoint(), nil
+
+	case "inline-enter":
+		return enterInlined(c.Args[0])
+
+	case "inline-return":
+		return returnFromInlined(c.Args[0])
+
+	case "if-eq-goto":
+		return t.compareAndJump("JEQ", c.Args[0]), nil
+
+	case "if-gt-goto":
+		return t.compareAndJump("JGT", c.Args[0]), nil
+
+	case "if-lt-goto":
+		return t.compareAndJump("JLT", c.Args[0]), nil
 	}
 
 	// If none of the above, it's either a push / pop command, or a single-part operation command
 
 	// Is this a single-part command? (ie. an operation)
-	if len(command) == 1 {
-		operation, err := operation(command[0])
-		if err != nil {
-			return "", err
+	if len(c.Args) == 0 {
+		return t.operation(c.Kind)
+	}
+
+	if c.Kind == "push" && len(c.Args) == 2 && c.Args[0] == "string" {
+		return t.pushString(c.Args[1])
+	}
+
+	// Is the second argument a number?
+	if len(c.Args) == 2 {
+		if num, err := strconv.Atoi(c.Args[1]); err == nil {
+			// Yes, so we're pushing / popping from the stack
+			if c.Args[0] == "global" {
+				if _, err := globalAddress(num); err != nil {
+					return "", err
+				}
+			}
+
+			switch c.Kind {
+			case "push":
+				if asm, ok, err := accessTables().push.lookup(c.Args[0], num); ok || err != nil {
+					return asm, err
+				}
+
+				return t.handlePush(c.Args[0], num)
+			case "pop":
+				if asm, ok, err := accessTables().pop.lookup(c.Args[0], num); ok || err != nil {
+					return asm, err
+				}
+
+				return t.handlePop(c.Args[0], num)
+			}
 		}
+	}
+
+	return "", fmt.Errorf("invalid command: %s", append([]string{c.Kind}, c.Args...))
+}
+
+// The segments addressed through a base pointer
+var segmentPointers = map[string]string{
+	"argument": "@ARG",
+	"local":    "@LCL",
+	"this":     "@THIS",
+	"that":     "@THAT",
+}
+
+// For small indices it's cheaper to step A up from the base pointer than to
+// load the index and add it
+func smallIndexAddress(segment string, index int) ([]string, bool) {
+	pointer, ok := segmentPointers[segment]
+	if !ok || optimizationLevel < 1 || index < 1 || index > 3 {
+		return nil, false
+	}
+
+	lines := []string{
+		pointer,
+		"A=M+1",
+	}
+
+	for i := 1; i < index; i++ {
+		lines = append(lines, "A=A+1")
+	}
 
-		return operation + "\n", nil
+	return lines, true
+}
+
+// A push or pop whose address is kept in the locRegister across a run of
+// accesses to the same segment. The first access of a run computes the
+// address, later ones step it from the previous index (the third arg)
+func cachedAccess(c Command) (string, error) {
+	if len(c.Args) < 2 {
+		return "", fmt.Errorf("invalid command: %s", c)
+	}
+
+	pointer, ok := segmentPointers[c.Args[0]]
+	if !ok {
+		return "", fmt.Errorf("invalid segment for cached access: %s", c.Args[0])
 	}
 
-	// Is the third part of the command a number?
-	num, err := strconv.Atoi(command[2])
-	if err == nil {
-		// Yes, so we're pushing / popping from the stack
-		second := command[1]
+	index, err := strconv.Atoi(c.Args[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid command: %s", c)
+	}
+
+	// Instructions that leave the address in both A and the locRegister
+	var address []string
+
+	if len(c.Args) == 2 {
+		address = []string{
+			"@" + strconv.Itoa(index),
+			"D=A",
+			pointer,
+			"D=D+M",
+			locRegister,
+			"AM=D",
+		}
+	} else {
+		previous, err := strconv.Atoi(c.Args[2])
+		if err != nil {
+			return "", fmt.Errorf("invalid command: %s", c)
+		}
 
-		if command[0] == "push" {
-			return handlePush(second, num), nil
-		} else if command[0] == "pop" {
-			return handlePop(second, num), nil
+		switch delta := index - previous; {
+		case delta == 0:
+			address = []string{locRegister, "A=M"}
+		case delta == 1:
+			address = []string{locRegister, "AM=M+1"}
+		case delta == -1:
+			address = []string{locRegister, "AM=M-1"}
+		case delta > 0:
+			address = []string{"@" + strconv.Itoa(delta), "D=A", locRegister, "AM=D+M"}
+		default:
+			address = []string{"@" + strconv.Itoa(-delta), "D=A", locRegister, "AM=M-D"}
 		}
+	}
+
+	var lines []string
 
-		return "", fmt.Errorf("invalid command: %s", command)
+	if c.Kind == "cached-push" {
+		lines = append(address,
+			"D=M",
+			"@SP",
+			"AM=M+1",
+			"A=A-1",
+			"M=D",
+		)
+	} else {
+		// D is needed for the popped value, so the address is settled first
+		lines = append(address,
+			"@SP",
+			"AM=M-1",
+			"D=M",
+			locRegister,
+			"A=M",
+			"M=D",
+		)
 	}
 
-	return "", fmt.Errorf("invalid command: %s", command)
+	return joinLines(lines), nil
 }
 
-func handlePush(segment string, index int) string {
+func (t *translator) handlePush(segment string, index int) (string, error) {
 	var lines []string
 
+	if address, ok := smallIndexAddress(segment, index); ok {
+		lines = append(address,
+			"D=M",
+			"@SP",
+			"AM=M+1",
+			"A=A-1",
+			"M=D",
+		)
+
+		return joinLines(lines), nil
+	}
+
 	switch segment {
 	case "constant":
 		lines = []string{
-			fmt.Sprintf("@%d", index),
+			"@" + strconv.Itoa(index),
 			"D=A",
 			"@SP",
 			"AM=M+1",
@@ -577,7 +2126,7 @@ func handlePush(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@ARG",
 				"A=M",
@@ -604,7 +2153,7 @@ func handlePush(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@LCL",
 				"A=M",
@@ -619,8 +2168,13 @@ func handlePush(segment string, index int) string {
 		}
 
 	case "static":
+		symbol, err := t.staticSymbol(index)
+		if err != nil {
+			return "", err
+		}
+
 		lines = []string{
-			fmt.Sprintf("@%s.%d", currentFile, index),
+			symbol,
 			"D=M",
 			"@SP",
 			"AM=M+1",
@@ -641,7 +2195,7 @@ func handlePush(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@THIS",
 				"A=D+M",
@@ -666,7 +2220,7 @@ func handlePush(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@THAT",
 				"A=D+M",
@@ -701,7 +2255,17 @@ func handlePush(segment string, index int) string {
 
 	case "temp":
 		lines = []string{
-			fmt.Sprintf("@%d", index+5),
+			"@" + strconv.Itoa(index+memory.Temp),
+			"D=M",
+			"@SP",
+			"AM=M+1",
+			"A=A-1",
+			"M=D",
+		}
+
+	case "global":
+		lines = []string{
+			"@" + strconv.Itoa(index+memory.Global),
 			"D=M",
 			"@SP",
 			"AM=M+1",
@@ -710,12 +2274,24 @@ func handlePush(segment string, index int) string {
 		}
 	}
 
-	return strings.Join(lines, "\n") + "\n"
+	return joinLines(lines), nil
 }
 
-func handlePop(segment string, index int) string {
+func (t *translator) handlePop(segment string, index int) (string, error) {
 	var lines []string
 
+	if address, ok := smallIndexAddress(segment, index); ok {
+		lines = []string{
+			"@SP",
+			"AM=M-1",
+			"D=M",
+		}
+		lines = append(lines, address...)
+		lines = append(lines, "M=D")
+
+		return joinLines(lines), nil
+	}
+
 	switch segment {
 	case "argument":
 		if index == 0 {
@@ -729,7 +2305,7 @@ func handlePop(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@ARG",
 				"A=D+M",
@@ -758,7 +2334,7 @@ func handlePop(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@LCL",
 				"A=D+M",
@@ -776,11 +2352,16 @@ func handlePop(segment string, index int) string {
 		}
 
 	case "static":
+		symbol, err := t.staticSymbol(index)
+		if err != nil {
+			return "", err
+		}
+
 		lines = []string{
 			"@SP",
 			"AM=M-1",
 			"D=M",
-			fmt.Sprintf("@%s.%d", currentFile, index),
+			symbol,
 			"M=D",
 		}
 
@@ -796,7 +2377,7 @@ func handlePop(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@THIS",
 				"A=D+M",
@@ -825,7 +2406,7 @@ func handlePop(segment string, index int) string {
 			}
 		} else {
 			lines = []string{
-				fmt.Sprintf("@%d", index),
+				"@" + strconv.Itoa(index),
 				"D=A",
 				"@THAT",
 				"A=D+M",
@@ -866,22 +2447,31 @@ func handlePop(segment string, index int) string {
 			"@SP",
 			"AM=M-1",
 			"D=M",
-			fmt.Sprintf("@%d", index+5),
+			"@" + strconv.Itoa(index+memory.Temp),
+			"M=D",
+		}
+
+	case "global":
+		lines = []string{
+			"@SP",
+			"AM=M-1",
+			"D=M",
+			"@" + strconv.Itoa(index+memory.Global),
 			"M=D",
 		}
 	}
 
-	return strings.Join(lines, "\n") + "\n"
+	return joinLines(lines), nil
 }
 
-func function(name string, nVars string) (string, error) {
+func (t *translator) function(name string, nVars string) (string, error) {
 	numVars, err := strconv.Atoi(nVars)
 	if err != nil {
 		return "", fmt.Errorf("invalid vars for function definition (%s): %s", name, nVars)
 	}
 
 	// Change the function context
-	funcStack.current = name
+	t.FuncStack.Current = name
 
 	// Initialise all local variables to 0
 	lines := []string{
@@ -900,17 +2490,17 @@ func function(name string, nVars string) (string, error) {
 		lines = append(lines, initLocalVariable...)
 	}
 
-	return strings.Join(lines, "\n") + "\n", nil
+	return joinLines(lines), nil
 }
 
-func callFunction(name string, nArgs string) (string, error) {
+func (t *translator) callFunction(name string, nArgs string) (string, error) {
 	numArgs, err := strconv.Atoi(nArgs)
 	if err != nil {
 		return "", fmt.Errorf("invalid args to function (%s): %s", name, nArgs)
 	}
 
-	callingFuncName := funcStack.current
-	returnLabel := getFolderName() + "." + callingFuncName + "$ret" + strconv.Itoa(funcStack.returnCounter)
+	callingFuncName := t.FuncStack.Current
+	returnLabel := getFolderName() + "." + callingFuncName + "$ret" + strconv.Itoa(t.FuncStack.ReturnCounter)
 
 	lines := []string{
 		// Put the function address into the `locRegister`
@@ -920,13 +2510,13 @@ func callFunction(name string, nArgs string) (string, error) {
 		"M=D",
 
 		// Put the number of args into the `valueRegister`
-		fmt.Sprintf("@%d", numArgs),
+		"@" + strconv.Itoa(numArgs),
 		"D=A",
 		valueRegister,
 		"M=D",
 
 		// Put the return address into the D register
-		fmt.Sprintf("@%s", returnLabel),
+		"@" + returnLabel,
 		"D=A",
 
 		// Jump to the call routine
@@ -934,86 +2524,206 @@ func callFunction(name string, nArgs string) (string, error) {
 		"0;JMP",
 
 		// Set the return label for this call
-		fmt.Sprintf("(%s)", returnLabel),
+		"(" + returnLabel + ")",
 	}
 
 	// Increment the return counter for the next call from this function
-	funcStack.returnCounter++
+	t.FuncStack.ReturnCounter++
+
+	return joinLines(lines), nil
+}
+
+// The bootstrap's call to the entry function, its arguments pushed first
+func (t *translator) callEntry() (string, error) {
+	call, err := t.callFunction(entryFunction, strconv.Itoa(entryArgs))
+	if err != nil {
+		return "", err
+	}
 
-	return strings.Join(lines, "\n") + "\n", nil
+	zero := joinLines([]string{"@SP", "AM=M+1", "A=A-1", "M=0"})
+
+	return strings.Repeat(zero, entryArgs) + call, nil
 }
 
+// Returns are all alike
+var returnAsm = returnFromFunction()
+
 func returnFromFunction() string {
 	lines := []string{
 		"@RETURN",
 		"0;JMP",
 	}
 
-	return strings.Join(lines, "\n") + "\n"
+	return joinLines(lines)
+}
+
+// An inlined body runs on the caller's frame with ARG temporarily pointed at
+// its arguments. The caller's ARG is pushed just above them
+func enterInlined(nArgs string) (string, error) {
+	numArgs, err := strconv.Atoi(nArgs)
+	if err != nil {
+		return "", fmt.Errorf("invalid args to inlined function: %s", nArgs)
+	}
+
+	lines := []string{
+		// Push the caller's ARG
+		"@ARG",
+		"D=M",
+		"@SP",
+		"AM=M+1",
+		"A=A-1",
+		"M=D",
+
+		// Point ARG at the arguments, just below the saved ARG
+		"@SP",
+		"D=M",
+		"@" + strconv.Itoa(numArgs+1),
+		"D=D-A",
+		"@ARG",
+		"M=D",
+	}
+
+	return joinLines(lines), nil
+}
+
+func returnFromInlined(nArgs string) (string, error) {
+	numArgs, err := strconv.Atoi(nArgs)
+	if err != nil {
+		return "", fmt.Errorf("invalid args to inlined function: %s", nArgs)
+	}
+
+	// The caller's ARG sits just above the arguments
+	lines := []string{
+		"@ARG",
+		"D=M",
+		"@" + strconv.Itoa(numArgs),
+		"A=D+A",
+		"D=M",
+		locRegister,
+		"M=D",
+
+		// Put the return value where the first argument was
+		"@SP",
+		"A=M-1",
+		"D=M",
+		"@ARG",
+		"A=M",
+		"M=D",
+
+		// Drop everything above it
+		"@ARG",
+		"D=M+1",
+		"@SP",
+		"M=D",
+
+		// Restore the caller's ARG
+		locRegister,
+		"D=M",
+		"@ARG",
+		"M=D",
+	}
+
+	return joinLines(lines), nil
+}
+
+func (t *translator) returnFromLeafFunction() string {
+	t.LeafReturnCount++
+
+	lines := []string{
+		"@RETURN_LEAF",
+		"0;JMP",
+	}
+
+	return joinLines(lines)
+}
+
+// A label as it's named in the asm, after what it's scoped to
+func (t *translator) scopedLabel(label string) string {
+	return scopedLabel(labelOwner(t.FuncStack.Current, t.CurrentFile), label)
 }
 
-func gotoLabel(label string) string {
-	callingFuncName := funcStack.current
-	constructedLabel := callingFuncName + "$" + label
+func (t *translator) gotoLabel(label string) string {
+	constructedLabel := t.scopedLabel(label)
 
 	lines := []string{
-		fmt.Sprintf("@%s", constructedLabel),
+		"@" + constructedLabel,
 		"0;JMP",
 	}
 
-	return strings.Join(lines, "\n") + "\n"
+	return joinLines(lines)
 }
 
-func ifGoto(label string) string {
-	callingFuncName := funcStack.current
-	constructedLabel := callingFuncName + "$" + label
+func (t *translator) ifGoto(label string) string {
+	constructedLabel := t.scopedLabel(label)
 
 	lines := []string{
 		"@SP",
 		"AM=M-1",
 		"D=M",
-		fmt.Sprintf("@%s", constructedLabel),
+		"@" + constructedLabel,
 		"D;JNE",
 	}
 
-	return strings.Join(lines, "\n") + "\n"
+	return joinLines(lines)
 }
 
-func label(label string) string {
-	callingFuncName := funcStack.current
-	constructedLabel := callingFuncName + "$" + label
+// A comparison fused with the following if-goto: x - y is tested directly
+// rather than materialising a boolean on the stack
+func (t *translator) compareAndJump(jump string, label string) string {
+	constructedLabel := t.scopedLabel(label)
+
+	lines := []string{
+		"@SP",
+		"AM=M-1",
+		"D=M",
+		"@SP",
+		"AM=M-1",
+		"D=M-D",
+		"@" + constructedLabel,
+		fmt.Sprintf("D;%s", jump),
+	}
 
-	return fmt.Sprintf("(%s)", constructedLabel) + "\n"
+	return joinLines(lines)
 }
 
-func operation(op string) (string, error) {
-	switch op {
-	case "add":
-		return add(), nil
+func (t *translator) label(label string) string {
+	constructedLabel := t.scopedLabel(label)
+
+	return "(" + constructedLabel + ")\n"
+}
 
-	case "sub":
-		return sub(), nil
+// The asm of the operations that come out the same every time, built once
+var fixedOperations = map[string]string{
+	"add": add(),
+	"sub": sub(),
+	"neg": neg(),
+	"and": and(),
+	"or":  or(),
+	"not": not(),
+}
+
+func (t *translator) operation(op string) (string, error) {
+	if asm, ok := fixedOperations[op]; ok {
+		return asm, nil
+	}
+
+	if extendedOperations[op] {
+		if dialect != "extended" {
+			return "", fmt.Errorf("%s needs -dialect=extended", op)
+		}
 
-	case "neg":
-		return neg(), nil
+		return t.extendedOperation(op), nil
+	}
 
+	switch op {
 	case "eq":
-		return eq(), nil
+		return t.eq(), nil
 
 	case "gt":
-		return gt(), nil
+		return t.gt(), nil
 
 	case "lt":
-		return lt(), nil
-
-	case "and":
-		return and(), nil
-
-	case "or":
-		return or(), nil
-
-	case "not":
-		return not(), nil
+		return t.lt(), nil
 
 	default:
 		return "", fmt.Errorf("invalid operation: %s", op)
@@ -1029,7 +2739,7 @@ func add() string {
 		"M=D+M",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
 func sub() string {
@@ -1041,7 +2751,7 @@ func sub() string {
 		"M=M-D",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
 func neg() string {
@@ -1053,62 +2763,88 @@ func neg() string {
 		"M=M+1",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
-var eqCount = 0
-
-func eq() string {
-	retAddress := fmt.Sprintf("RET_ADDRESS_EQ%d", eqCount)
+func (t *translator) eq() string {
+	retAddress := "RET_ADDRESS_EQ" + strconv.Itoa(t.EqCount)
 
 	lines := []string{
-		fmt.Sprintf("@%s", retAddress),
+		"@" + retAddress,
 		"D=A",
 		"@EQ",
 		"0;JMP",
-		fmt.Sprintf("(%s)", retAddress),
+		"(" + retAddress + ")",
 	}
 
-	eqCount++
+	t.EqCount++
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
-var gtCount = 0
-
-func gt() string {
-	retAddress := fmt.Sprintf("RET_ADDRESS_GT%d", gtCount)
+func (t *translator) gt() string {
+	retAddress := "RET_ADDRESS_GT" + strconv.Itoa(t.GtCount)
 
 	lines := []string{
-		fmt.Sprintf("@%s", retAddress),
+		"@" + retAddress,
 		"D=A",
 		"@GT",
 		"0;JMP",
-		fmt.Sprintf("(%s)", retAddress),
+		"(" + retAddress + ")",
 	}
 
-	gtCount++
+	t.GtCount++
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
-var ltCount = 0
-
-func lt() string {
+func (t *translator) lt() string {
 
-	retAddress := fmt.Sprintf("RET_ADDRESS_LT%d", ltCount)
+	retAddress := "RET_ADDRESS_LT" + strconv.Itoa(t.LtCount)
 
 	lines := []string{
-		fmt.Sprintf("@%s", retAddress),
+		"@" + retAddress,
 		"D=A",
 		"@LT",
 		"0;JMP",
-		fmt.Sprintf("(%s)", retAddress),
+		"(" + retAddress + ")",
 	}
 
-	ltCount++
+	t.LtCount++
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
+}
+
+func (t *translator) shl() string {
+	retAddress := "RET_ADDRESS_SHL" + strconv.Itoa(t.ShlCount)
+
+	lines := []string{
+		"@" + retAddress,
+		"D=A",
+		"@SHL",
+		"0;JMP",
+		"(" + retAddress + ")",
+	}
+
+	t.ShlCount++
+
+	return joinLines(lines)
+}
+
+func (t *translator) shr() string {
+	retAddress := "RET_ADDRESS_SHR" + strconv.Itoa(t.ShrCount)
+
+	lines := []string{
+		"@" + retAddress,
+		"D=A",
+		"@SHR",
+		"0;JMP",
+		"(" + retAddress + ")",
+	}
+
+	t.ShrCount++
+
+	return joinLines(lines)
 }
 
 func and() string {
@@ -1120,7 +2856,7 @@ func and() string {
 		"M=D&M",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
 func or() string {
@@ -1132,7 +2868,7 @@ func or() string {
 		"M=D|M",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
 func not() string {
@@ -1144,7 +2880,7 @@ func not() string {
 		"M=M+1",
 	}
 
-	return strings.Join(lines, "\n")
+	return joinLines(lines)
 }
 
 func incStackPointer() string {
@@ -1156,6 +2892,26 @@ func incStackPointer() string {
 	return strings.Join(lines, "\n")
 }
 
+// Where a plain translation writes its asm: where -o says, or beside the
+// file or in the folder translated
+func asmOutputPath() string {
+	if outputPath != "" {
+		return outputPath
+	}
+
+	if ext := path.Ext(pathToTranslate); ext != "" {
+		return strings.TrimSuffix(pathToTranslate, ext) + ".asm"
+	}
+
+	return filepath.Join(pathToTranslate, getFolderName()+".asm")
+}
+
+// Marks the output as generated in the form Go tools recognise, saying how
+// to generate it again
+func generatedHeader() string {
+	return fmt.Sprintf("// Code generated by vmtranslator %s; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
+}
+
 func getFolderName() string {
 	// Get the name of the current folder
 	dir := pathToTranslate
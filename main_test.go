@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+// Tests run with the flags' defaults, as a translation given none would. The
+// test flags are parsed first, as parsing the translator's leaves none
+func TestMain(m *testing.M) {
+	flag.Parse()
+	parseFlags(nil)
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Base addresses of the RAM regions the generated code relies on
+type MemoryMap struct {
+	Temp int
+	// Negative when statics are left for the assembler to allocate
+	Static   int
+	Stack    int
+	Heap     int
+	Screen   int
+	Keyboard int
+	// Negative when there's no global segment
+	Global int
+}
+
+// The standard Hack memory map
+var memory = MemoryMap{
+	Temp:     5,
+	Static:   -1,
+	Stack:    256,
+	Heap:     2048,
+	Screen:   16384,
+	Keyboard: 24576,
+	Global:   -1,
+}
+
+func (m MemoryMap) validate() error {
+	addresses := map[string]int{
+		"temp":     m.Temp,
+		"stack":    m.Stack,
+		"heap":     m.Heap,
+		"screen":   m.Screen,
+		"keyboard": m.Keyboard,
+	}
+
+	for name, address := range addresses {
+		if address < 0 || address > 32767 {
+			return fmt.Errorf("invalid %s address: %d", name, address)
+		}
+	}
+
+	if m.Stack >= m.Heap {
+		return fmt.Errorf("stack base (%d) must be below the heap base (%d)", m.Stack, m.Heap)
+	}
+
+	if m.Global > 32767 {
+		return fmt.Errorf("invalid global address: %d", m.Global)
+	}
+
+	if m.Static >= m.Stack {
+		return fmt.Errorf("static base (%d) must be below the stack base (%d)", m.Static, m.Stack)
+	}
+
+	return nil
+}
+
+// Returns the A-instruction for a static variable of the current file. With a
+// static base, statics get addresses in order of first use, otherwise the
+// assembler allocates them from their symbols. A file's pinned statics are
+// where it pins them
+func (t *translator) staticSymbol(index int) (string, error) {
+	if t.StaticBase != 0 {
+		return fmt.Sprintf("@%d", t.StaticBase+index), nil
+	}
+
+	return t.dataSymbol(fmt.Sprintf("%s.%d", t.CurrentFile, index))
+}
+
+// Returns the A-instruction for a word of data kept with the statics, e.g. a
+// string's, addressed as they are. Left to the assembler, they're still
+// counted, as it hands them out from firstVariableAddress in the order
+// they're first used, and they mustn't run into the stack
+func (t *translator) dataSymbol(symbol string) (string, error) {
+	base := memory.Static
+	if base < 0 {
+		base = firstVariableAddress
+	}
+
+	address, ok := t.StaticAddresses[symbol]
+	if !ok {
+		address = base + len(t.StaticAddresses)
+		if address >= memory.Stack {
+			return "", fmt.Errorf("too many statics and strings for the static region (%d-%d)", base, memory.Stack-1)
+		}
+
+		t.StaticAddresses[symbol] = address
+	}
+
+	if memory.Static < 0 {
+		return "@" + symbol, nil
+	}
+
+	return fmt.Sprintf("@%d", address), nil
+}
+
+// Applies flag values from a JSON config file, e.g. {"stack-base": 512}. A
+// list sets a repeatable flag once per value, e.g. {"post": ["make"]}. Flags
+// set on the command line take precedence
+func loadConfig(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid config %s: %w", configPath, err)
+	}
+
+	setOnCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	for name, value := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting in config %s: %s", configPath, name)
+		}
+
+		if setOnCommandLine[name] {
+			continue
+		}
+
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+
+		for _, value := range list {
+			if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("invalid value for %s in config %s: %w", name, configPath, err)
+			}
+		}
+	}
+
+	return nil
+}
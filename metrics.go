@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the translation duration histogram's buckets, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// How often a service call has been made, how often it failed and how long
+// it took
+type callMetrics struct {
+	count  int
+	errors int
+	// Calls taking at most each of durationBuckets, not cumulative
+	buckets []int
+	seconds float64
+}
+
+// What the translation service has done since the process started
+type serviceMetrics struct {
+	lock  sync.Mutex
+	calls map[string]*callMetrics
+}
+
+var metrics = &serviceMetrics{calls: map[string]*callMetrics{}}
+
+func (m *serviceMetrics) observe(call string, duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c, ok := m.calls[call]
+	if !ok {
+		c = &callMetrics{buckets: make([]int, len(durationBuckets))}
+		m.calls[call] = c
+	}
+
+	c.count++
+	if err != nil {
+		c.errors++
+	}
+
+	c.seconds += duration.Seconds()
+
+	for i, bound := range durationBuckets {
+		if duration.Seconds() <= bound {
+			c.buckets[i]++
+			break
+		}
+	}
+}
+
+// Writes the metrics in the Prometheus text format
+func (m *serviceMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var calls []string
+	for call := range m.calls {
+		calls = append(calls, call)
+	}
+	sort.Strings(calls)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP vmtranslator_calls_total Translation service calls, by call.")
+	fmt.Fprintln(w, "# TYPE vmtranslator_calls_total counter")
+	for _, call := range calls {
+		fmt.Fprintf(w, "vmtranslator_calls_total{call=%q} %d\n", call, m.calls[call].count)
+	}
+
+	fmt.Fprintln(w, "# HELP vmtranslator_errors_total Translation service calls that failed, by call.")
+	fmt.Fprintln(w, "# TYPE vmtranslator_errors_total counter")
+	for _, call := range calls {
+		fmt.Fprintf(w, "vmtranslator_errors_total{call=%q} %d\n", call, m.calls[call].errors)
+	}
+
+	fmt.Fprintln(w, "# HELP vmtranslator_call_duration_seconds How long translation service calls took, not counting waiting their turn.")
+	fmt.Fprintln(w, "# TYPE vmtranslator_call_duration_seconds histogram")
+	for _, call := range calls {
+		c := m.calls[call]
+
+		cumulative := 0
+		for i, bound := range durationBuckets {
+			cumulative += c.buckets[i]
+			fmt.Fprintf(w, "vmtranslator_call_duration_seconds_bucket{call=%q,le=\"%g\"} %d\n", call, bound, cumulative)
+		}
+
+		fmt.Fprintf(w, "vmtranslator_call_duration_seconds_bucket{call=%q,le=\"+Inf\"} %d\n", call, c.count)
+		fmt.Fprintf(w, "vmtranslator_call_duration_seconds_sum{call=%q} %g\n", call, c.seconds)
+		fmt.Fprintf(w, "vmtranslator_call_duration_seconds_count{call=%q} %d\n", call, c.count)
+	}
+}
+
+// Serves /metrics and the /debug/pprof profiles on their own address, in the
+// background, so they needn't be exposed wherever translations are
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Printf("serving metrics and profiles on %s", address)
+
+	go func() {
+		log.Fatal(http.ListenAndServe(address, mux))
+	}()
+}
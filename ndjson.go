@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// A request on the NDJSON protocol: a command for a program on disk at path,
+// or for source sent along with the request under a file name
+type ndjsonRequest struct {
+	// Anything, echoed in the response to match them up
+	ID     json.RawMessage `json:"id,omitempty"`
+	Cmd    string          `json:"cmd"`
+	Path   string          `json:"path,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Source string          `json:"source,omitempty"`
+}
+
+type ndjsonResponse struct {
+	ID          json.RawMessage `json:"id,omitempty"`
+	OK          bool            `json:"ok"`
+	Asm         string          `json:"asm,omitempty"`
+	Commands    []Command       `json:"commands,omitempty"`
+	Diagnostics []diagnostic    `json:"diagnostics,omitempty"`
+}
+
+// Answers a request: translate gives the asm, check every problem and ir the
+// commands after the optimization passes
+func answerNDJSON(request ndjsonRequest) ndjsonResponse {
+	response := ndjsonResponse{ID: request.ID}
+	service := translationService{}
+
+	run := func(path string) error {
+		switch request.Cmd {
+		case "translate":
+			instructions, err := service.Translate(path)
+			response.Asm = strings.Join(instructions, "")
+			return err
+
+		case "check":
+			return service.Check(path, func(d diagnostic) error {
+				response.Diagnostics = append(response.Diagnostics, d)
+				return nil
+			})
+
+		case "ir":
+			return service.EmitIR(path, func(command Command) error {
+				response.Commands = append(response.Commands, command)
+				return nil
+			})
+		}
+
+		return fmt.Errorf("unknown cmd: %q (try translate, check or ir)", request.Cmd)
+	}
+
+	var err error
+	switch {
+	case request.Source != "":
+		service.confined = true
+		err = withRequestSource(request.Name, []byte(request.Source), run)
+	case request.Path != "":
+		err = run(request.Path)
+	default:
+		err = fmt.Errorf("a request needs a path or source")
+	}
+
+	if err != nil {
+		response.Diagnostics = append(response.Diagnostics, newDiagnostic(err))
+	}
+
+	response.OK = err == nil && len(response.Diagnostics) == 0
+
+	return response
+}
+
+// Answers a request per line of in with a response per line of out, until
+// in ends
+func serveNDJSON(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxRequestSize)
+	encoder := json.NewEncoder(out)
+
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var request ndjsonRequest
+		response := ndjsonResponse{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Diagnostics = []diagnostic{{Message: "invalid request: " + err.Error()}}
+		} else {
+			response = answerNDJSON(request)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Speaks newline-delimited JSON over stdin and stdout, so tools can translate
+// again and again without starting the translator each time. The translation
+// flags given apply to every request
+func stdioCommand(args []string) {
+	parseFlags(args)
+
+	if err := serveNDJSON(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
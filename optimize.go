@@ -0,0 +1,384 @@
+package main
+
+import "strconv"
+
+// Runs the commands through the external passes, so they see the IR as it's
+// written, then the IR passes enabled by the optimization level, and
+// -favor=size
+func optimize(commands []Command) ([]Command, error) {
+	commands, err := runExternalPasses(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	if optimizationLevel >= 2 {
+		commands = inlineFunctions(commands)
+
+		// The rest only look within a function, so functions go through
+		// them at the same time
+		commands = runPerFunction(commands, specializeLeafReturns, fuseComparisons, cacheSegmentBases)
+	}
+
+	if favorMode == "size" {
+		commands = shareAccesses(commands)
+	}
+
+	return commands, nil
+}
+
+// The commands only the optimizer writes, which the source can't use
+var internalCommands = map[string]bool{
+	"if-eq-goto":    true,
+	"if-gt-goto":    true,
+	"if-lt-goto":    true,
+	"inline-enter":  true,
+	"inline-return": true,
+	"cached-push":   true,
+	"cached-pop":    true,
+	"shared-push":   true,
+	"shared-pop":    true,
+	"return-leaf":   true,
+}
+
+var fusedComparisons = map[string]string{
+	"eq": "if-eq-goto",
+	"gt": "if-gt-goto",
+	"lt": "if-lt-goto",
+}
+
+// Folds a comparison immediately followed by an if-goto into a single
+// conditional jump
+func fuseComparisons(commands []Command) []Command {
+	fused := make([]Command, 0, len(commands))
+
+	for i := 0; i < len(commands); i++ {
+		command := commands[i]
+
+		kind, ok := fusedComparisons[command.Kind]
+		if ok && i+1 < len(commands) && commands[i+1].Kind == "if-goto" {
+			next := commands[i+1]
+
+			fused = append(fused, Command{
+				Kind:       kind,
+				Args:       next.Args,
+				File:       command.File,
+				Line:       command.Line,
+				Source:     command.Source,
+				StaticBase: command.StaticBase,
+				Synthetic:  true,
+			})
+
+			i++
+			continue
+		}
+
+		fused = append(fused, command)
+	}
+
+	return fused
+}
+
+type functionDefinition struct {
+	name   string
+	locals int
+	// The commands following the `function` declaration, up to the next one
+	body []Command
+}
+
+// Splits the program into its functions. Commands before the first function
+// declaration don't belong to any
+func collectFunctions(commands []Command) map[string]functionDefinition {
+	functions := map[string]functionDefinition{}
+	var current *functionDefinition
+
+	for _, command := range commands {
+		if command.Kind == "function" && len(command.Args) == 2 {
+			if current != nil {
+				functions[current.name] = *current
+			}
+
+			locals, _ := strconv.Atoi(command.Args[1])
+			current = &functionDefinition{name: command.Args[0], locals: locals}
+			continue
+		}
+
+		if current != nil {
+			current.body = append(current.body, command)
+		}
+	}
+
+	if current != nil {
+		functions[current.name] = *current
+	}
+
+	return functions
+}
+
+// Only straight-line leaf functions without locals that leave THIS and THAT
+// alone are inlined, so the body can run on the caller's frame with just ARG
+// swapped
+func isInlinable(function functionDefinition) bool {
+	body := function.body
+
+	if function.locals != 0 || len(body) == 0 || len(body)-1 > inlineThreshold {
+		return false
+	}
+
+	if body[len(body)-1].Kind != "return" {
+		return false
+	}
+
+	for _, command := range body[:len(body)-1] {
+		switch command.Kind {
+		case "function", "call", "return", "label", "goto", "if-goto", "asm":
+			return false
+
+		case "push", "pop":
+			if len(command.Args) > 0 && command.Args[0] == "local" {
+				return false
+			}
+
+			// A real return would restore the caller's THIS and THAT
+			if command.Kind == "pop" && len(command.Args) > 0 && command.Args[0] == "pointer" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Replaces calls to small, rarely called functions with their bodies
+func inlineFunctions(commands []Command) []Command {
+	functions := collectFunctions(commands)
+
+	callCounts := map[string]int{}
+	for _, command := range commands {
+		if command.Kind == "call" && len(command.Args) == 2 {
+			callCounts[command.Args[0]]++
+		}
+	}
+
+	// Decided for the whole program first, so each function's calls can then
+	// be replaced on their own
+	inlinable := map[string]bool{}
+	for name, function := range functions {
+		inlinable[name] = callCounts[name] <= inlineMaxCalls && isInlinable(function)
+	}
+
+	return runPerFunction(commands, func(commands []Command) []Command {
+		inlined := make([]Command, 0, len(commands))
+
+		for _, command := range commands {
+			if command.Kind != "call" || len(command.Args) != 2 || !inlinable[command.Args[0]] {
+				inlined = append(inlined, command)
+				continue
+			}
+
+			function := functions[command.Args[0]]
+			nArgs := command.Args[1]
+			body := function.body[:len(function.body)-1]
+
+			inlined = append(inlined, Command{
+				Kind:       "inline-enter",
+				Args:       []string{nArgs},
+				File:       command.File,
+				Line:       command.Line,
+				Source:     command.Source,
+				StaticBase: command.StaticBase,
+				Synthetic:  true,
+			})
+			inlined = append(inlined, body...)
+			inlined = append(inlined, Command{
+				Kind:       "inline-return",
+				Args:       []string{nArgs},
+				File:       command.File,
+				Line:       command.Line,
+				Source:     command.Source,
+				StaticBase: command.StaticBase,
+				Synthetic:  true,
+			})
+		}
+
+		return inlined
+	})
+}
+
+// Returns the segment and index of a push/pop through a base pointer
+func pointerAccess(command Command) (string, string, bool) {
+	if command.Kind != "push" && command.Kind != "pop" || len(command.Args) != 2 {
+		return "", "", false
+	}
+
+	if _, ok := segmentPointers[command.Args[0]]; !ok {
+		return "", "", false
+	}
+
+	if _, err := strconv.Atoi(command.Args[1]); err != nil {
+		return "", "", false
+	}
+
+	return command.Args[0], command.Args[1], true
+}
+
+// Whether a command may change the locRegister or a segment base, or be
+// jumped to
+func invalidatesCachedBase(command Command) bool {
+	switch command.Kind {
+	case "push", "add", "sub", "neg", "and", "or", "not", "eq", "gt", "lt", "min", "max", "abs", "breakpoint",
+		"if-goto", "if-eq-goto", "if-gt-goto", "if-lt-goto":
+		return false
+
+	case "pop":
+		// Pops to fixed addresses don't go through the locRegister
+		return len(command.Args) == 0 || command.Args[0] != "static" && command.Args[0] != "temp"
+	}
+
+	return true
+}
+
+// Keeps the address of the last access in the locRegister across runs of
+// pushes/pops to the same segment, so later accesses only step it
+func cacheSegmentBases(commands []Command) []Command {
+	cached := make([]Command, len(commands))
+	copy(cached, commands)
+
+	for i := 0; i < len(cached); {
+		segment, _, ok := pointerAccess(cached[i])
+		if !ok {
+			i++
+			continue
+		}
+
+		run := []int{i}
+
+		for j := i + 1; j < len(cached); j++ {
+			if s, _, ok := pointerAccess(cached[j]); ok && s == segment {
+				run = append(run, j)
+				continue
+			}
+
+			if invalidatesCachedBase(cached[j]) {
+				break
+			}
+		}
+
+		if len(run) < 2 || !cachingPays(cached, run) {
+			i++
+			continue
+		}
+
+		previous := ""
+
+		for _, k := range run {
+			command := cached[k]
+			args := []string{segment, command.Args[1]}
+
+			if previous != "" {
+				args = append(args, previous)
+			}
+
+			cached[k] = Command{
+				Kind:       "cached-" + command.Kind,
+				Args:       args,
+				File:       command.File,
+				Line:       command.Line,
+				Source:     command.Source,
+				StaticBase: command.StaticBase,
+				Synthetic:  true,
+			}
+
+			previous = command.Args[1]
+		}
+
+		i = run[len(run)-1] + 1
+	}
+
+	return cached
+}
+
+// Compares the address computation costs (in instructions) of a run with and
+// without the cache
+func cachingPays(commands []Command, run []int) bool {
+	uncached, withCache := 0, 0
+	previous := 0
+
+	for n, k := range run {
+		command := commands[k]
+		pop := command.Kind == "pop"
+		index, _ := strconv.Atoi(command.Args[1])
+
+		switch {
+		case index == 0:
+			uncached += 2
+		case index <= 3 && optimizationLevel >= 1:
+			uncached += 1 + index
+		case pop:
+			uncached += 8
+		default:
+			uncached += 5
+		}
+
+		delta := index - previous
+
+		switch {
+		case n == 0:
+			withCache += 6
+		case delta >= -1 && delta <= 1:
+			withCache += 2
+		default:
+			withCache += 4
+		}
+
+		// Pops reload the address after taking the value off the stack
+		if pop {
+			withCache += 2
+		}
+
+		previous = index
+	}
+
+	return withCache < uncached
+}
+
+// A function without locals that makes no calls and never pops to pointer
+// leaves THIS and THAT as its caller had them. There's no telling with asm
+func isLeafFunction(function functionDefinition) bool {
+	if function.locals != 0 {
+		return false
+	}
+
+	for _, command := range function.body {
+		if command.Kind == "call" || command.Kind == "asm" {
+			return false
+		}
+
+		if command.Kind == "pop" && len(command.Args) > 0 && command.Args[0] == "pointer" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Switches the returns of leaf functions to the cheaper leaf return routine
+func specializeLeafReturns(commands []Command) []Command {
+	functions := collectFunctions(commands)
+	specialized := make([]Command, 0, len(commands))
+	leaf := false
+
+	for _, command := range commands {
+		if command.Kind == "function" && len(command.Args) == 2 {
+			leaf = isLeafFunction(functions[command.Args[0]])
+		}
+
+		if command.Kind == "return" && leaf {
+			command.Kind = "return-leaf"
+			command.Synthetic = true
+		}
+
+		specialized = append(specialized, command)
+	}
+
+	return specialized
+}
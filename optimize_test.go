@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Runs a pass over the source, giving the commands it comes to a line each
+func rewrite(t *testing.T, pass func([]Command) []Command, source string) string {
+	commands, err := parseAs("extended", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, command := range pass(commands) {
+		lines = append(lines, command.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestRewrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		pass   func([]Command) []Command
+		source string
+		want   string
+	}{
+		{
+			"comparison then if-goto is fused",
+			fuseComparisons,
+			"push local 0\npush local 1\nlt\nif-goto LESS\nlabel LESS",
+			"push local 0\npush local 1\nif-lt-goto LESS\nlabel LESS",
+		},
+		{
+			"no fusion across a label",
+			fuseComparisons,
+			"push local 0\npush local 1\neq\nlabel JOIN\nif-goto SAME\nlabel SAME",
+			"push local 0\npush local 1\neq\nlabel JOIN\nif-goto SAME\nlabel SAME",
+		},
+		{
+			"cache run broken by pop pointer",
+			cacheSegmentBases,
+			"push that 5\npush that 6\npush that 7\npop pointer 1\npush that 5\npush that 6\npush that 7",
+			"cached-push that 5\ncached-push that 6 5\ncached-push that 7 6\npop pointer 1\ncached-push that 5\ncached-push that 6 5\ncached-push that 7 6",
+		},
+		{
+			"only functions without locals are inlined",
+			inlineFunctions,
+			"function Main.main 0\ncall Main.twice 1\ncall Main.local 1\nreturn\n" +
+				"function Main.twice 0\npush argument 0\npush argument 0\nadd\nreturn\n" +
+				"function Main.local 1\npush argument 0\npop local 0\npush local 0\nreturn",
+			"function Main.main 0\ninline-enter 1\npush argument 0\npush argument 0\nadd\ninline-return 1\ncall Main.local 1\nreturn\n" +
+				"function Main.twice 0\npush argument 0\npush argument 0\nadd\nreturn\n" +
+				"function Main.local 1\npush argument 0\npop local 0\npush local 0\nreturn",
+		},
+		{
+			"only leaf functions get the leaf return",
+			specializeLeafReturns,
+			"function Main.leaf 0\npush argument 0\nreturn\n" +
+				"function Main.caller 0\ncall Main.leaf 0\nreturn\n" +
+				"function Main.pointer 0\npush argument 0\npop pointer 0\npush constant 0\nreturn",
+			"function Main.leaf 0\npush argument 0\nreturn-leaf\n" +
+				"function Main.caller 0\ncall Main.leaf 0\nreturn\n" +
+				"function Main.pointer 0\npush argument 0\npop pointer 0\npush constant 0\nreturn",
+		},
+		{
+			"only common shapes share a routine, and statics never do",
+			shareAccesses,
+			strings.Repeat("pop local 5\npush static 0\n", 4) + "pop local 6",
+			strings.Repeat("shared-pop local 5\npush static 0\n", 4) + "pop local 6",
+		},
+	}
+
+	for _, test := range tests {
+		if got := rewrite(t, test.pass, test.source); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
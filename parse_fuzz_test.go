@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Seeds the fuzzer with the .vm files under testdata, whole or a line at a
+// time
+func addSamples(f *testing.F, lines bool) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*", "*.vm"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		if !lines {
+			f.Add(source)
+			continue
+		}
+
+		for _, line := range strings.Split(string(source), "\n") {
+			f.Add([]byte(line))
+		}
+	}
+}
+
+// Parses the data as a .vm file in each dialect, translating what parses.
+// Neither may panic, however malformed the data
+func parseAndTranslate(data []byte, single bool) {
+	language := dialect
+	defer func() { dialect = language }()
+
+	for _, dialect = range []string{"standard", "extended"} {
+		commands, err := NewParser("Fuzz.vm").Parse(bufio.NewScanner(bytes.NewReader(data)))
+		if err != nil || single && len(commands) != 1 {
+			continue
+		}
+
+		translator := newTranslator()
+		for _, command := range commands {
+			translator.translateCommand(command)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addSamples(f, false)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseAndTranslate(data, false)
+	})
+}
+
+func FuzzParseCommand(f *testing.F) {
+	addSamples(f, true)
+	f.Add([]byte("push"))
+	f.Add([]byte("push local"))
+	f.Add([]byte("function Main.main"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseAndTranslate(data, true)
+	})
+}
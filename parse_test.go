@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Parses source as Main.vm in a dialect
+func parseAs(language string, source string) ([]Command, error) {
+	previous := dialect
+	dialect = language
+	defer func() { dialect = previous }()
+
+	return NewParser("Main.vm").Parse(bufio.NewScanner(strings.NewReader(source)))
+}
+
+// A command as its line and itself, plus where its statics are pinned
+func describeCommand(command Command) string {
+	described := fmt.Sprintf("%d: %s", command.Line, command)
+	if command.StaticBase != 0 {
+		described += fmt.Sprintf(" (statics from %d)", command.StaticBase)
+	}
+
+	return described
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		standard bool
+		source   string
+		// The commands, a line each, or the error
+		want string
+	}{
+		{"block comment", false, "push constant 1 /* one */\n/* spans\nlines */ add\n", "1: push constant 1\n3: add"},
+		{"block comment in standard", true, "/* note */ add\n", "Main.vm:1: block comment needs -dialect=extended"},
+		{"unclosed block comment", false, "add\n/* never\nclosed\n", "Main.vm:2: block comment isn't closed"},
+
+		{"macro", false, "macro inc(slot)\npush slot\npush constant 1\nadd\nendmacro\ninc(local 0)\n", "6: push local 0\n6: push constant 1\n6: add"},
+		{"macro in standard", true, "macro m()\nadd\nendmacro\n", "Main.vm:1: macro needs -dialect=extended"},
+		{"unclosed macro", false, "macro m()\nadd\n", "Main.vm:1: macro m isn't closed by endmacro"},
+		{"unknown macro", false, "m(1)\n", "Main.vm:1: unknown macro: m"},
+
+		{"const", false, "const SCREEN 16384\nconst START SCREEN\npush constant START\n", "3: push constant 16384"},
+		{"alias", false, "alias COUNTER temp 3\npop temp COUNTER\n", "2: pop temp 3"},
+		{"alias of another segment", false, "alias COUNTER temp 3\npush local COUNTER\n", "Main.vm:2: COUNTER is an alias for temp 3, not for a local index"},
+		{"unknown constant", false, "push constant SCREEN\n", "Main.vm:1: unknown constant: SCREEN"},
+		{"const in standard", true, "const SCREEN 16384\n", "Main.vm:1: const needs -dialect=extended"},
+
+		{"asm block", false, "asm {\n@SP\nAM=M-1\n}\nadd\n", "1: asm @SP AM=M-1\n5: add"},
+		{"asm line", false, "//!asm @SP\n", "1: asm @SP"},
+		{"unclosed asm block", false, "asm {\n@SP\n", "Main.vm:1: asm block isn't closed by }"},
+		{"asm in standard", true, "//!asm @SP\n", "Main.vm:1: asm needs -dialect=extended"},
+
+		{"global segment", false, "push global 3\n", "1: push global 3"},
+		{"global segment in standard", true, "push global 3\n", "Main.vm:1: the global segment needs -dialect=extended"},
+
+		{"push string", false, "push string \"hi\"\n", "1: push string \"hi\""},
+		{"push string in standard", true, "push string \"hi\"\n", "Main.vm:1: push string needs -dialect=extended"},
+
+		{"static base", false, "//!static-base 200\npush static 1\n", "2: push static 1 (statics from 200)"},
+		{"invalid static base", false, "//!static-base 5\n", "Main.vm:1: invalid static base: 5"},
+		{"static base twice", false, "//!static-base 200\n//!static-base 300\n", "Main.vm:2: statics already pinned from 200"},
+		{"static base in standard", true, "//!static-base 200\n", "Main.vm:1: //!static-base needs -dialect=extended"},
+
+		{"extended operation in standard", true, "mult\n", "Main.vm:1: mult needs -dialect=extended"},
+		{"unknown command in standard", true, "foo\n", "Main.vm:1: invalid command: foo"},
+		{"index out of range in standard", true, "push temp 8\n", "Main.vm:1: no such access: push temp 8, index out of range"},
+		{"optimizer's command", false, "if-eq-goto END\n", "Main.vm:1: invalid command: if-eq-goto END"},
+		{"wrong number of arguments", false, "push local\n", "Main.vm:1: wrong number of arguments: push local"},
+	}
+
+	for _, test := range tests {
+		language := "extended"
+		if test.standard {
+			language = "standard"
+		}
+
+		commands, err := parseAs(language, test.source)
+
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			var lines []string
+			for _, command := range commands {
+				lines = append(lines, describeCommand(command))
+			}
+
+			got = strings.Join(lines, "\n")
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+// With -labels=file, a label can be jumped to from anywhere in its file
+func TestLabelScope(t *testing.T) {
+	commands, err := parseAs("standard", "function Main.a 0\nlabel SHARED\nreturn\nfunction Main.b 0\ngoto SHARED\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := labelScope
+	defer func() { labelScope = previous }()
+
+	for _, test := range []struct{ scope, want string }{
+		{"function", "Main.vm:5: undefined label: SHARED"},
+		{"file", "<nil>"},
+	} {
+		labelScope = test.scope
+
+		if _, err := layoutCommands(commands); fmt.Sprint(err) != test.want {
+			t.Errorf("-labels=%s: got %v, want %s", test.scope, err, test.want)
+		}
+	}
+}
+
+// The program starts at the -entry function if it has one, from the top if
+// it doesn't
+func TestEntry(t *testing.T) {
+	commands, err := parseAs("standard", "push constant 1\npop temp 0\nlabel END\ngoto END\nfunction Main.main 0\npush constant 2\npop temp 0\nlabel HALT\ngoto HALT\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := entryFunction
+	defer func() { entryFunction = previous }()
+
+	for _, test := range []struct {
+		entry string
+		want  int16
+	}{
+		{"Sys.init", 1},
+		{"Main.main", 2},
+	} {
+		entryFunction = test.entry
+
+		m, err := newVMMachine(commands)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.boot(); err != nil {
+			t.Fatal(err)
+		}
+
+		for !m.halted {
+			if err := m.step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := m.ram[memory.Temp]; got != test.want {
+			t.Errorf("-entry=%s: temp 0 is %d, want %d", test.entry, got, test.want)
+		}
+	}
+}
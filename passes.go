@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Shell commands the IR goes through before the built-in passes, in order
+var externalPasses []string
+
+// Runs the commands through each external pass. A pass reads the commands
+// as JSON on stdin, in the form the ir subcommand prints, and writes the
+// commands to translate instead to stdout
+func runExternalPasses(commands []Command) ([]Command, error) {
+	for _, pass := range externalPasses {
+		input, err := json.Marshal(commands)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := exec.Command("sh", "-c", pass)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stderr = os.Stderr
+
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("pass %s: %w", pass, err)
+		}
+
+		commands = nil
+		if err := json.Unmarshal(output, &commands); err != nil {
+			return nil, fmt.Errorf("pass %s: invalid IR: %w", pass, err)
+		}
+
+		for i, command := range commands {
+			if command.Kind == "" {
+				return nil, fmt.Errorf("pass %s: command %d has no kind", pass, i+1)
+			}
+
+			if command.Args == nil {
+				commands[i].Args = []string{}
+			}
+		}
+	}
+
+	return commands, nil
+}
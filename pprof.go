@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Where to write pprof profiles of a translation, for reporting where a large
+// program's time and memory go
+var cpuProfilePath string
+var memProfilePath string
+
+// Starts the CPU profile, if one is wanted. What it returns stops it and
+// writes the heap profile, which has everything the translation allocated as
+// well as what's still in use
+func startProfiling() (func() error, error) {
+	var cpuProfile *os.File
+
+	if cpuProfilePath != "" {
+		file, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		cpuProfile = file
+	}
+
+	return func() error {
+		if cpuProfile != nil {
+			pprof.StopCPUProfile()
+
+			if err := cpuProfile.Close(); err != nil {
+				return err
+			}
+		}
+
+		if memProfilePath == "" {
+			return nil
+		}
+
+		file, err := os.Create(memProfilePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// Up to date statistics on what's still in use
+		runtime.GC()
+
+		return pprof.WriteHeapProfile(file)
+	}, nil
+}
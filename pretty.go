@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Indents everything but labels and comments, so labels stand out
+func indentAsm(asm string) string {
+	lines := strings.Split(strings.TrimSuffix(asm, "\n"), "\n")
+
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "(") && !strings.HasPrefix(line, "//") {
+			lines[i] = "    " + line
+		}
+	}
+
+	return joinLines(lines)
+}
+
+// Lays out a command's asm for reading: a banner where a file or function
+// starts, the asm indented and a blank line after
+func (t *translator) prettyCommand(command Command, asm string) string {
+	var banner string
+
+	if command.File != t.PrettyFile {
+		t.PrettyFile = command.File
+		banner += fmt.Sprintf("\n// ==== %s ====\n\n", command.File)
+	}
+
+	if command.Kind == "function" && len(command.Args) > 0 {
+		banner += fmt.Sprintf("// ---- %s ----\n", command.Args[0])
+	}
+
+	return banner + indentAsm(asm) + "\n"
+}
+
+// Gives each shared routine a banner and indents its body
+func prettyRoutines(routines []string) []string {
+	if len(routines) == 0 {
+		return routines
+	}
+
+	pretty := []string{"\n// ==== routines ====\n\n"}
+
+	for _, routine := range routines {
+		banner := fmt.Sprintf("// ---- %s ----\n", routineName(routine))
+		pretty = append(pretty, banner+indentAsm(routine)+"\n")
+	}
+
+	return pretty
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Where a run's cycles went, by function and by VM command. Cycles spent in
+// the bootstrap and shared routines count towards the command that used them
+type profile struct {
+	program *programMap
+	cycles  int
+	// By mapping index
+	commandRuns   []int
+	commandCycles []int
+	functionCalls map[string]int
+	// The cycles the call, return and function commands took
+	overhead int
+	// Where the last command ran
+	current int
+}
+
+func newProfile(program *programMap) *profile {
+	return &profile{
+		program:       program,
+		commandRuns:   make([]int, len(program.mappings)),
+		commandCycles: make([]int, len(program.mappings)),
+		functionCalls: map[string]int{},
+		current:       -1,
+	}
+}
+
+// Counts the instruction the CPU is about to run
+func (p *profile) count(cpu *hackCPU) {
+	if owner, ok := p.program.commandAt(cpu.pc); ok {
+		p.commandRuns[owner]++
+
+		if fields := strings.Fields(p.program.mappings[owner].Command); len(fields) == 3 && fields[0] == "call" {
+			p.functionCalls[fields[1]]++
+		}
+	}
+
+	if owner := p.program.owner(cpu.pc); owner >= 0 {
+		p.current = owner
+	}
+
+	p.cycles++
+
+	if p.current < 0 {
+		return
+	}
+
+	p.commandCycles[p.current]++
+
+	switch strings.Fields(p.program.mappings[p.current].Command)[0] {
+	case "call", "return", "function":
+		p.overhead++
+	}
+}
+
+func (p *profile) percent(cycles int) string {
+	if p.cycles == 0 {
+		return "0.0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(cycles)*100/float64(p.cycles))
+}
+
+// The profile as tables of functions and the hottest commands, top of them
+func (p *profile) report(top int, markdown bool) string {
+	functionCycles := map[string]int{}
+	bootstrap := p.cycles
+
+	for i, cycles := range p.commandCycles {
+		function := p.program.functions[i]
+		if function == "" {
+			function = "(top level)"
+		}
+
+		functionCycles[function] += cycles
+		bootstrap -= cycles
+	}
+
+	if bootstrap > 0 {
+		functionCycles["(bootstrap)"] = bootstrap
+	}
+
+	var out strings.Builder
+
+	out.WriteString(reportTitle(filepath.Base(pathToTranslate)+" profile", markdown))
+
+	out.WriteString(reportHeading("Cycles", markdown))
+	out.WriteString(fmt.Sprintf("%d cycles, %d (%s) in call, function and return\n\n", p.cycles, p.overhead, p.percent(p.overhead)))
+
+	var rows [][]string
+	for _, function := range sortedByCount(functionCycles) {
+		calls := "-"
+		if n, ok := p.functionCalls[function]; ok {
+			calls = fmt.Sprint(n)
+		}
+
+		rows = append(rows, []string{function, calls, fmt.Sprint(functionCycles[function]), p.percent(functionCycles[function])})
+	}
+
+	out.WriteString(reportHeading("Functions", markdown))
+	out.WriteString(reportTable([]string{"Function", "Calls", "Cycles", "Share"}, rows, markdown) + "\n")
+
+	var hottest []int
+	for i, cycles := range p.commandCycles {
+		if cycles > 0 {
+			hottest = append(hottest, i)
+		}
+	}
+
+	sort.SliceStable(hottest, func(i, j int) bool {
+		return p.commandCycles[hottest[i]] > p.commandCycles[hottest[j]]
+	})
+
+	if len(hottest) > top {
+		hottest = hottest[:top]
+	}
+
+	rows = nil
+	for _, i := range hottest {
+		mapping := p.program.mappings[i]
+		rows = append(rows, []string{
+			fmt.Sprintf("%s:%d", mapping.File, mapping.Line),
+			mapping.Command,
+			fmt.Sprint(p.commandRuns[i]),
+			fmt.Sprint(p.commandCycles[i]),
+			p.percent(p.commandCycles[i]),
+		})
+	}
+
+	out.WriteString(reportHeading("Hottest commands", markdown))
+	out.WriteString(reportTable([]string{"Source", "Command", "Runs", "Cycles", "Share"}, rows, markdown))
+
+	return out.String()
+}
+
+// Runs the machine code and reports where the cycles went: per function, per
+// command and in the calling convention
+func profileCommand(args []string) {
+	cycles := flag.Int("cycles", 10000000, "clock cycles to run for at most")
+	top := flag.Int("top", 20, "how many of the hottest commands to list")
+	markdown := flag.Bool("md", false, "print the profile as Markdown")
+	parseFlags(args)
+
+	instructions, cpu, _ := loadHackCPU()
+	program := newProgramMap(instructions, len(cpu.rom))
+	p := newProfile(program)
+
+	for !cpu.halted && cpu.cycles < *cycles {
+		p.count(cpu)
+		cpu.step()
+
+		if cpu.fault != "" {
+			log.Fatalf("%s at cycle %d", cpu.fault, cpu.cycles)
+		}
+	}
+
+	fmt.Print(p.report(*top, *markdown))
+}
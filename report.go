@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// A command alongside the asm it was translated to, kept for the report
+type translatedCommand struct {
+	Command Command
+	Asm     string
+}
+
+// Keeps the translated commands for tools other than the report and archive
+var shouldKeepCommands bool
+
+func instructionCount(asm string) int {
+	count := 0
+
+	// Walks the lines in place rather than splitting them out
+	for rest := asm; rest != ""; {
+		line := rest
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			line, rest = rest[:end], rest[end+1:]
+		} else {
+			rest = ""
+		}
+
+		if isInstruction(strings.TrimSpace(line)) {
+			count++
+		}
+	}
+
+	return count
+}
+
+type reportRow struct {
+	Location string
+	Command  string
+	Asm      string
+	Size     int
+	// Shared routines the command jumps into, which cost cycles on top
+	Routines []string
+}
+
+type reportSection struct {
+	Name string
+	Rows []reportRow
+	Size int
+}
+
+type reportRoutine struct {
+	Name string
+	Size int
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+pre { margin: 0; }
+.vm { font-family: monospace; white-space: nowrap; }
+.where { color: #888; font-size: smaller; }
+summary { cursor: pointer; font-weight: bold; margin-top: 0.5em; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Commands}} commands translated to {{.Program}} instructions, {{.Total}} in the whole output.</p>
+{{range .Sections}}
+<details open>
+<summary>{{.Name}} &mdash; {{len .Rows}} commands, {{.Size}} instructions</summary>
+<table>
+<tr><th>Source</th><th>Command</th><th>Asm</th><th>Size</th></tr>
+{{range .Rows}}<tr>
+<td class="where">{{.Location}}</td>
+<td class="vm">{{.Command}}</td>
+<td><pre>{{.Asm}}</pre></td>
+<td>{{.Size}}{{range .Routines}}<br>+ {{.}}{{end}}</td>
+</tr>
+{{end}}</table>
+</details>
+{{end}}
+<h2>Shared routines</h2>
+<table>
+<tr><th>Routine</th><th>Size</th></tr>
+{{range .Routines}}<tr><td class="vm">{{.Name}}</td><td>{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes an HTML page showing each command next to its asm, grouped by
+// function, with the size of each and the routines it leans on
+func saveHTMLReport(fileName string, instructions []string) error {
+	routines := map[string]bool{}
+	var routineSizes []reportRoutine
+
+	for _, routine := range allRoutines() {
+		routines[routineName(routine)] = true
+		routineSizes = append(routineSizes, reportRoutine{routineName(routine), instructionCount(routine)})
+	}
+
+	sections := []reportSection{{Name: "(top level)"}}
+	program := 0
+
+	for _, translated := range translation.translatedCommands {
+		command := translated.Command
+
+		if command.Kind == "function" && len(command.Args) > 0 {
+			sections = append(sections, reportSection{Name: command.Args[0]})
+		}
+
+		row := reportRow{
+			Location: fmt.Sprintf("%s:%d", filepath.Base(command.File), command.Line),
+			Command:  command.String(),
+			Asm:      strings.TrimSuffix(translated.Asm, "\n"),
+			Size:     instructionCount(translated.Asm),
+		}
+
+		for _, line := range strings.Split(translated.Asm, "\n") {
+			if strings.HasPrefix(line, "@") && routines[line[1:]] {
+				row.Routines = append(row.Routines, line[1:])
+			}
+		}
+
+		section := &sections[len(sections)-1]
+		section.Rows = append(section.Rows, row)
+		section.Size += row.Size
+		program += row.Size
+	}
+
+	// Nothing comes before the first function in most programs
+	if len(sections[0].Rows) == 0 {
+		sections = sections[1:]
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return reportTemplate.Execute(file, map[string]interface{}{
+		"Name":     filepath.Base(pathToTranslate),
+		"Commands": len(translation.translatedCommands),
+		"Program":  program,
+		"Total":    instructionCount(strings.Join(instructions, "")),
+		"Sections": sections,
+		"Routines": routineSizes,
+	})
+}
+
+func saveReport(instructions []string) error {
+	switch filepath.Ext(reportPath) {
+	case ".html", ".htm":
+		return saveHTMLReport(reportPath, instructions)
+	case ".md":
+		return saveStatsReport(reportPath, instructions, true)
+	case ".txt":
+		return saveStatsReport(reportPath, instructions, false)
+	}
+
+	return fmt.Errorf("unknown report format: %s", reportPath)
+}
+
+// Renders a table as Markdown or, for plain text, as aligned columns
+func reportTable(header []string, rows [][]string, markdown bool) string {
+	var out strings.Builder
+
+	if markdown {
+		out.WriteString("| " + strings.Join(header, " | ") + " |\n")
+		out.WriteString(strings.Repeat("| --- ", len(header)) + "|\n")
+
+		for _, row := range rows {
+			out.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		}
+
+		return out.String()
+	}
+
+	writer := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+
+	writer.Flush()
+
+	return out.String()
+}
+
+// The program's statistics: commands by kind, functions by size, calls per
+// function, statics per file and how much of the ROM the output takes
+func statsReport(instructions []string, markdown bool) string {
+	kinds := map[string]int{}
+	functionSizes := map[string]int{}
+	functionCommands := map[string]int{}
+	callSites := map[string]int{}
+	statics := map[string]map[string]bool{}
+	var functions []string
+	current := "(top level)"
+
+	for _, translated := range translation.translatedCommands {
+		command := translated.Command
+		kinds[command.Kind]++
+
+		if command.Kind == "function" && len(command.Args) > 0 {
+			current = command.Args[0]
+			functions = append(functions, current)
+		}
+
+		functionSizes[current] += instructionCount(translated.Asm)
+		functionCommands[current]++
+
+		if command.Kind == "call" {
+			callSites[current]++
+		}
+
+		if len(command.Args) == 2 && command.Args[0] == "static" {
+			file := filepath.Base(command.File)
+			if statics[file] == nil {
+				statics[file] = map[string]bool{}
+			}
+
+			statics[file][command.Args[1]] = true
+		}
+	}
+
+	var commands []Command
+	for _, translated := range translation.translatedCommands {
+		commands = append(commands, translated.Command)
+	}
+
+	graph := dependencyGraph(commands)
+	total := instructionCount(strings.Join(instructions, ""))
+
+	var out strings.Builder
+
+	out.WriteString(reportTitle(filepath.Base(pathToTranslate)+" statistics", markdown))
+
+	out.WriteString(reportHeading("ROM", markdown))
+	out.WriteString(fmt.Sprintf("%d instructions, %.1f%% of the 32768 word ROM\n\n", total, float64(total)*100/32768))
+
+	var rows [][]string
+	for _, kind := range sortedByCount(kinds) {
+		rows = append(rows, []string{kind, fmt.Sprint(kinds[kind])})
+	}
+
+	out.WriteString(reportHeading("Commands by kind", markdown))
+	out.WriteString(reportTable([]string{"Kind", "Count"}, rows, markdown) + "\n")
+
+	rows = nil
+	for _, name := range sortedByCount(functionSizes) {
+		rows = append(rows, []string{name, fmt.Sprint(functionCommands[name]), fmt.Sprint(functionSizes[name])})
+	}
+
+	out.WriteString(reportHeading("Functions by size", markdown))
+	out.WriteString(reportTable([]string{"Function", "Commands", "Instructions"}, rows, markdown) + "\n")
+
+	rows = nil
+	for _, name := range functions {
+		rows = append(rows, []string{name, fmt.Sprint(callSites[name]), fmt.Sprint(len(graph[name].calledBy))})
+	}
+
+	out.WriteString(reportHeading("Calls per function", markdown))
+	out.WriteString(reportTable([]string{"Function", "Call sites", "Callers"}, rows, markdown) + "\n")
+
+	var files []string
+	for file := range statics {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	rows = nil
+	for _, file := range files {
+		rows = append(rows, []string{file, fmt.Sprint(len(statics[file]))})
+	}
+
+	out.WriteString(reportHeading("Static usage", markdown))
+	out.WriteString(reportTable([]string{"File", "Statics"}, rows, markdown))
+
+	return out.String()
+}
+
+func saveStatsReport(fileName string, instructions []string, markdown bool) error {
+	return os.WriteFile(fileName, []byte(statsReport(instructions, markdown)), 0644)
+}
+
+func reportTitle(title string, markdown bool) string {
+	if markdown {
+		return "# " + title + "\n\n"
+	}
+
+	return title + "\n" + strings.Repeat("=", len(title)) + "\n\n"
+}
+
+func reportHeading(title string, markdown bool) string {
+	if markdown {
+		return "## " + title + "\n\n"
+	}
+
+	return title + "\n" + strings.Repeat("-", len(title)) + "\n\n"
+}
+
+// The keys, largest count first, ties by name
+func sortedByCount(counts map[string]int) []string {
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The RAM a run prints when it isn't told which addresses to: the pointers,
+// temp, the statics and whatever is on the stack
+func defaultDump(m *vmMachine) []int {
+	addresses := []int{0, 1, 2, 3, 4}
+
+	for i := 0; i < 8; i++ {
+		addresses = append(addresses, memory.Temp+i)
+	}
+
+	addresses = append(addresses, vmStatics(m)...)
+
+	for address := memory.Stack; address < int(m.ram[0]) && address < len(m.ram); address++ {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// The RAM addresses of the program's statics, in order
+func vmStatics(m *vmMachine) []int {
+	var statics []int
+	for _, address := range m.layout.statics {
+		statics = append(statics, address)
+	}
+	sort.Ints(statics)
+
+	return statics
+}
+
+// Parses the run's positional arguments: ADDRESS=VALUE sets RAM before
+// running, ADDRESS or FIRST-LAST is printed afterwards
+func runArguments(args []string, ram []int16) ([]int, error) {
+	var dump []int
+
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			address, err := strconv.Atoi(name)
+			if err != nil || address < 0 || address >= len(ram) {
+				return nil, fmt.Errorf("invalid address: %s", name)
+			}
+
+			n, err := strconv.Atoi(value)
+			if err != nil || n < -32768 || n > 32767 {
+				return nil, fmt.Errorf("invalid value: %s", value)
+			}
+
+			ram[address] = int16(n)
+			continue
+		}
+
+		first, last, ok := strings.Cut(arg, "-")
+		if !ok {
+			last = first
+		}
+
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %s", arg)
+		}
+
+		to, err := strconv.Atoi(last)
+		if err != nil || from < 0 || to < from || to >= len(ram) {
+			return nil, fmt.Errorf("invalid address: %s", arg)
+		}
+
+		for address := from; address <= to; address++ {
+			dump = append(dump, address)
+		}
+	}
+
+	return dump, nil
+}
+
+// Interprets a VM program directly and prints the RAM it finishes with
+func runCommand(args []string) {
+	cmpPath := flag.String("cmp", "", "check the final RAM against this .cmp file, exiting 1 on a mismatch")
+	dumpPath := flag.String("dump", "", "write the final RAM to this file in the test tools' output format")
+	dumpRanges := flag.String("dump-ranges", "pointers,temp,statics,stack", "what -dump writes: pointers, temp, statics, stack, heap, FIRST-LAST or ADDRESS, separated by commas")
+	maxSteps := flag.Int("max-cycles", 0, "stop with an error after running this many VM commands (0 for no limit)")
+	timeout := flag.Duration("timeout", 0, "stop with an error after running this long (0 for no limit)")
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if osFolder != "" {
+		commands, err = includeOS(commands, osFolder)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	m, err := newVMMachine(commands)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := m.boot(); err != nil {
+		log.Fatal(err)
+	}
+
+	dump, err := runArguments(flag.Args(), m.ram)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limits := newRunLimits(*maxSteps, *timeout)
+
+	for !m.halted {
+		if err := m.step(); err != nil {
+			log.Fatal(err)
+		}
+
+		if limit := limits.exceeded(m.steps); limit != "" {
+			log.Fatalf("%s at %s, VM step %d", limit, m.location(), m.steps)
+		}
+	}
+
+	if len(dump) == 0 {
+		dump = defaultDump(m)
+	}
+
+	if *dumpPath != "" {
+		if err := saveDump(*dumpPath, *dumpRanges, m.ram, vmStatics(m)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *cmpPath != "" {
+		checkFinalRAM(*cmpPath, m.ram, fmt.Sprintf("VM step %d", m.steps))
+		return
+	}
+
+	for _, address := range dump {
+		fmt.Printf("RAM[%d] = %d\n", address, m.ram[address])
+	}
+}
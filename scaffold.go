@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Where a test sets the segment bases when there's no bootstrap to do it, as
+// the course's own tests for project 7 do
+var testSegmentBases = map[string]int{
+	"local":    300,
+	"argument": 400,
+	"this":     3000,
+	"that":     3010,
+}
+
+// The RAM addresses a program's pops could write to, as far as they can be
+// known without running it
+func poppedAddresses(commands []Command, bootstrapped bool) []int {
+	movesPointers := false
+	for _, command := range commands {
+		if command.Kind == "pop" && len(command.Args) == 2 && command.Args[0] == "pointer" {
+			movesPointers = true
+		}
+	}
+
+	seen := map[int]bool{}
+	var addresses []int
+
+	for _, command := range commands {
+		if command.Kind != "pop" || len(command.Args) != 2 {
+			continue
+		}
+
+		index, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			continue
+		}
+
+		address := -1
+		segment := command.Args[0]
+
+		switch segment {
+		case "temp":
+			address = memory.Temp + index
+		case "pointer":
+			address = 3 + index
+		case "static":
+			// Otherwise the assembler decides where they go
+			if pinned, ok := pinnedStatic(command); ok {
+				address = pinned
+			} else if memory.Static >= 0 {
+				address = memory.Static + index
+			}
+		case "local", "argument":
+			if !bootstrapped {
+				address = testSegmentBases[segment] + index
+			}
+		case "this", "that":
+			if !bootstrapped && !movesPointers {
+				address = testSegmentBases[segment] + index
+			}
+		}
+
+		if address >= 0 && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	sort.Ints(addresses)
+
+	return addresses
+}
+
+// Centres a column heading the way the test tools print them, cutting it
+// short if it doesn't fit
+func cmpHeading(name string, width int) string {
+	if len(name) > width {
+		name = name[:width]
+	}
+
+	left := (width - len(name)) / 2
+
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", width-left-len(name))
+}
+
+// A nand2tetris .tst script that runs the asm and dumps the RAM it should
+// change, and a .cmp holding the matching headings for the expected values
+func testScaffold(name string, commands []Command, steps int, stack int) (string, string) {
+	_, bootstrapped := collectFunctions(commands)["Sys.init"]
+
+	columns := []int{0}
+
+	// A bootstrap leaves Sys.init's frame at the bottom of the stack
+	stackStart := memory.Stack
+	if bootstrapped {
+		stackStart += 5
+	}
+
+	for i := 0; i < stack; i++ {
+		columns = append(columns, stackStart+i)
+	}
+
+	for _, address := range poppedAddresses(commands, bootstrapped) {
+		if address != 0 && (address < stackStart || address >= stackStart+stack) {
+			columns = append(columns, address)
+		}
+	}
+
+	var outputs, headings, blanks []string
+	for _, address := range columns {
+		column := fmt.Sprintf("RAM[%d]", address)
+		outputs = append(outputs, column+"%D2.6.2")
+		headings = append(headings, cmpHeading(column, 10))
+		blanks = append(blanks, strings.Repeat(" ", 10))
+	}
+
+	tst := []string{
+		fmt.Sprintf("// Runs %s.asm; fill in the expected values in %s.cmp", name, name),
+		"",
+		fmt.Sprintf("load %s.asm,", name),
+		fmt.Sprintf("output-file %s.out,", name),
+		fmt.Sprintf("compare-to %s.cmp,", name),
+		"output-list " + strings.Join(outputs, " ") + ";",
+		"",
+		fmt.Sprintf("set RAM[0] %d,", memory.Stack),
+	}
+
+	if !bootstrapped {
+		for i, segment := range []string{"local", "argument", "this", "that"} {
+			tst = append(tst, fmt.Sprintf("set RAM[%d] %d,", i+1, testSegmentBases[segment]))
+		}
+	}
+
+	tst = append(tst,
+		"",
+		fmt.Sprintf("repeat %d {", steps),
+		"  ticktock;",
+		"}",
+		"",
+		"output;",
+	)
+
+	cmp := []string{
+		"|" + strings.Join(headings, "|") + "|",
+		"|" + strings.Join(blanks, "|") + "|",
+	}
+
+	return strings.Join(tst, "\n") + "\n", strings.Join(cmp, "\n") + "\n"
+}
+
+// Writes a .tst script and a .cmp template beside the program, to be filled in
+// with the values it should leave in RAM
+func scaffoldCommand(args []string) {
+	steps := flag.Int("steps", 1000, "how many clock cycles the test runs the program for")
+	stack := flag.Int("stack", 5, "how many stack slots the test dumps")
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(pathToTranslate), ".vm")
+	folder := pathToTranslate
+	if filepath.Ext(folder) == ".vm" {
+		folder = filepath.Dir(folder)
+	}
+
+	tst, cmp := testScaffold(name, commands, *steps, *stack)
+
+	if err := os.WriteFile(filepath.Join(folder, name+".tst"), []byte(tst), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(folder, name+".cmp"), []byte(cmp), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
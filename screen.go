@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+)
+
+// The Hack screen's size in pixels. Each row takes 32 words, and the least
+// significant bit of a word is its leftmost pixel
+const (
+	screenWidth  = 512
+	screenHeight = 256
+)
+
+func screenPixel(ram []int16, x int, y int) bool {
+	word := ram[memory.Screen+y*screenWidth/16+x/16]
+	return word>>(x%16)&1 != 0
+}
+
+// The screen memory map as an image, black on white
+func screenImage(ram []int16) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, screenWidth, screenHeight))
+
+	for y := 0; y < screenHeight; y++ {
+		for x := 0; x < screenWidth; x++ {
+			shade := color.Gray{Y: 255}
+			if screenPixel(ram, x, y) {
+				shade = color.Gray{Y: 0}
+			}
+
+			img.SetGray(x, y, shade)
+		}
+	}
+
+	return img
+}
+
+func saveScreen(fileName string, ram []int16) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, screenImage(ram)); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// The bits of a braille character for each of its 2x4 dots
+var brailleDots = [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+// Draws the screen with braille characters, each dot standing for a 2x2 block
+// of pixels that's set if any of them is, so it fits in 128x32 characters
+func terminalScreen(ram []int16) string {
+	var out strings.Builder
+
+	for row := 0; row < screenHeight; row += 8 {
+		for column := 0; column < screenWidth; column += 4 {
+			char := rune(0x2800)
+
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					x, y := column+dx*2, row+dy*2
+
+					if screenPixel(ram, x, y) || screenPixel(ram, x+1, y) || screenPixel(ram, x, y+1) || screenPixel(ram, x+1, y+1) {
+						char |= brailleDots[dy][dx]
+					}
+				}
+			}
+
+			out.WriteRune(char)
+		}
+
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The most a request may send, source or zip
+const maxRequestSize = 16 << 20
+
+// A problem with the source sent, located where the error says it is
+type diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// Splits an error of the form File.vm:12: message into where and what
+func newDiagnostic(err error) diagnostic {
+	parts := strings.SplitN(err.Error(), ":", 3)
+	if len(parts) == 3 && strings.HasSuffix(parts[0], ".vm") {
+		if line, lineErr := strconv.Atoi(parts[1]); lineErr == nil {
+			return diagnostic{File: parts[0], Line: line, Message: strings.TrimSpace(parts[2])}
+		}
+	}
+
+	return diagnostic{Message: err.Error()}
+}
+
+// Writes the source a request sent into dir: a zip's .vm files into a folder
+// named after the request, a single file under the name it gives. Returns the
+// path to translate
+func unpackSource(dir string, name string, body []byte) (string, error) {
+	if !bytes.HasPrefix(body, []byte("PK")) {
+		if name == "" {
+			name = "Main.vm"
+		}
+
+		if filepath.Ext(name) != ".vm" || filepath.Base(name) != name {
+			return "", fmt.Errorf("invalid file name: %s", name)
+		}
+
+		fileName := filepath.Join(dir, name)
+		return fileName, os.WriteFile(fileName, body, 0644)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		name = "Program"
+	}
+
+	folder := filepath.Join(dir, filepath.Base(strings.TrimSuffix(name, ".zip")))
+	if err := os.Mkdir(folder, 0755); err != nil {
+		return "", err
+	}
+
+	for _, entry := range archive.File {
+		// Only the .vm files, and never outside the folder
+		base := filepath.Base(entry.Name)
+		if entry.FileInfo().IsDir() || filepath.Ext(base) != ".vm" {
+			continue
+		}
+
+		reader, err := entry.Open()
+		if err != nil {
+			return "", err
+		}
+
+		contents, err := io.ReadAll(io.LimitReader(reader, maxRequestSize))
+		reader.Close()
+		if err != nil {
+			return "", err
+		}
+
+		if err := os.WriteFile(filepath.Join(folder, base), contents, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return folder, nil
+}
+
+// Unpacks what a request sent into a temporary folder for use to translate
+func withRequestSource(name string, body []byte, use func(path string) error) error {
+	dir, err := os.MkdirTemp("", "vmtranslator")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	source, err := unpackSource(dir, name, body)
+	if err != nil {
+		return err
+	}
+
+	return use(source)
+}
+
+// Answers POST /translate. The body is a .vm file, named by ?name=, or a
+// zip of a folder's .vm files. Success sends back the asm, failure a JSON
+// list of diagnostics, as does any request with ?format=json
+func handleTranslate(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSource(w, r)
+	if !ok {
+		return
+	}
+
+	asJSON := r.URL.Query().Get("format") == "json"
+	var instructions []string
+	err := withRequestSource(r.URL.Query().Get("name"), body, func(path string) error {
+		var err error
+		instructions, err = translationService{confined: true}.Translate(path)
+		return err
+	})
+
+	if err != nil || asJSON {
+		response := struct {
+			Asm         string       `json:"asm,omitempty"`
+			Diagnostics []diagnostic `json:"diagnostics"`
+		}{Diagnostics: []diagnostic{}}
+
+		if err != nil {
+			response.Diagnostics = append(response.Diagnostics, newDiagnostic(err))
+		} else {
+			response.Asm = strings.Join(instructions, "")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, strings.Join(instructions, ""))
+}
+
+// Answers POST /check with a JSON list of every problem in the source
+func handleCheck(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSource(w, r)
+	if !ok {
+		return
+	}
+
+	diagnostics := []diagnostic{}
+	err := withRequestSource(r.URL.Query().Get("name"), body, func(path string) error {
+		return translationService{confined: true}.Check(path, func(d diagnostic) error {
+			diagnostics = append(diagnostics, d)
+			return nil
+		})
+	})
+
+	if err != nil {
+		diagnostics = append(diagnostics, newDiagnostic(err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+// Answers POST /ir with the source's commands after the optimization passes
+func handleIR(w http.ResponseWriter, r *http.Request) {
+	body, ok := readSource(w, r)
+	if !ok {
+		return
+	}
+
+	commands := []Command{}
+	err := withRequestSource(r.URL.Query().Get("name"), body, func(path string) error {
+		return translationService{confined: true}.EmitIR(path, func(command Command) error {
+			commands = append(commands, command)
+			return nil
+		})
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode([]diagnostic{newDiagnostic(err)})
+		return
+	}
+
+	json.NewEncoder(w).Encode(commands)
+}
+
+// The source a request posted, answering it with an error if there's none
+func readSource(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST .vm source or a zip of a folder", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// Serves translations over HTTP, and gRPC if asked, for tools that can't run
+// the translator themselves. The translation flags given apply to every
+// request
+func serveCommand(args []string) {
+	address := flag.String("http", ":8080", "address to listen on")
+	grpcAddress := flag.String("grpc", "", "address to serve the gRPC Translator service of translator.proto on (off by default)")
+	metricsAddress := flag.String("metrics", "", "address to serve Prometheus /metrics and /debug/pprof on (off by default)")
+	parseFlags(args)
+
+	if *metricsAddress != "" {
+		serveMetrics(*metricsAddress)
+	}
+
+	if *grpcAddress != "" {
+		serveGRPC(*grpcAddress)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/translate", handleTranslate)
+	mux.HandleFunc("/check", handleCheck)
+	mux.HandleFunc("/ir", handleIR)
+
+	log.Printf("listening on %s", *address)
+	log.Fatal(http.ListenAndServe(*address, mux))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The calls translator.proto describes, independent of the transport that
+// carries them, on source already on disk. Streamed results go to send as
+// they're ready, stopping at the first error it returns
+type translationService struct {
+	// Whether the source came with the request, so its includes may not
+	// reach outside the folder translated into the server's own files
+	confined bool
+}
+
+// Translation works through global state, so calls take turns
+var translationLock sync.Mutex
+
+// Runs use with the translator reset and to itself, translating path, and
+// counts it in the metrics as the named call. A request's source has its
+// includes kept within its folder
+func (s translationService) with(call string, path string, use func() error) error {
+	translationLock.Lock()
+	defer translationLock.Unlock()
+
+	resetTranslation()
+	pathToTranslate = path
+
+	if s.confined {
+		includeRoot = path
+		if filepath.Ext(path) == ".vm" {
+			includeRoot = filepath.Dir(path)
+		}
+
+		defer func() { includeRoot = "" }()
+	}
+
+	start := time.Now()
+	err := use()
+	metrics.observe(call, time.Since(start), err)
+
+	return err
+}
+
+// The program's asm. Single files get the routines and bootstrap too, so
+// they can run
+func (s translationService) Translate(path string) ([]string, error) {
+	var instructions []string
+
+	err := s.with("Translate", path, func() error {
+		if cache == nil {
+			var err error
+			instructions, err = loadFolder(path)
+			return err
+		}
+
+		if cached, ok := cache.translated(path); ok {
+			instructions = cached
+			return nil
+		}
+
+		started := time.Now()
+
+		var err error
+		instructions, err = loadFolder(path)
+		if err == nil {
+			cache.storeTranslated(path, append([]string{}, sourceFiles...), started, instructions)
+		}
+
+		return err
+	})
+
+	return instructions, err
+}
+
+// Every problem in the program: each file that doesn't parse, and each
+// command in the rest that doesn't translate
+func (s translationService) Check(path string, send func(diagnostic) error) error {
+	return s.with("Check", path, func() error {
+		files, err := vmFiles(path)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			commands, err := parseFile(file)
+			if err != nil {
+				if err := send(newDiagnostic(err)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			for _, command := range commands {
+				translation.CurrentFile = command.File
+
+				if _, err := translation.translateCommand(command); err != nil {
+					if err := send(diagnostic{File: command.File, Line: command.Line, Message: err.Error()}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// The program's commands after the optimization passes
+func (s translationService) EmitIR(path string, send func(Command) error) error {
+	return s.with("EmitIR", path, func() error {
+		files, err := vmFiles(path)
+		if err != nil {
+			return err
+		}
+
+		commands, err := parseFiles(files)
+		if err != nil {
+			return err
+		}
+
+		commands, err = optimize(commands)
+		if err != nil {
+			return err
+		}
+
+		for _, command := range commands {
+			if err := send(command); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// What to favor where speed and ROM size pull apart: "speed" writes every
+// push and pop out in full, "size" sends those of common shapes through a
+// routine shared between them
+var favorMode string
+
+// Each use of a shared routine: the continuation into D, and the jump
+const sharedAccessLength = 4
+
+// Whether a push or pop can go through a shared routine. Statics are named
+// after their file, so each file's would need its own
+func isShareable(command Command) bool {
+	if command.Kind != "push" && command.Kind != "pop" || len(command.Args) != 2 {
+		return false
+	}
+
+	if command.Args[0] == "static" || command.Kind == "pop" && command.Args[0] == "constant" {
+		return false
+	}
+
+	_, err := strconv.Atoi(command.Args[1])
+	return err == nil
+}
+
+// The routine shared by the pushes or pops of a shape, e.g. PUSH_LOCAL_5
+func sharedRoutineName(shape string) string {
+	return strings.ToUpper(strings.ReplaceAll(shape, " ", "_"))
+}
+
+// Sends the pushes and pops of a shape through a shared routine wherever
+// there are enough of them for it to take less ROM than writing each out.
+// The routine is the push or pop as it's usually written, plus keeping and
+// returning to the continuation
+func shareAccesses(commands []Command) []Command {
+	uses := map[string]int{}
+	shapes := map[string]Command{}
+
+	for _, command := range commands {
+		if isShareable(command) {
+			shape := command.String()
+			uses[shape]++
+			shapes[shape] = command
+		}
+	}
+
+	shared := map[string]bool{}
+	scratch := newTranslator()
+
+	for shape, n := range uses {
+		asm, err := scratch.translateCommand(shapes[shape])
+		if err != nil {
+			continue
+		}
+
+		written := instructionCount(asm)
+		routine := written + 5
+
+		shared[shape] = n*sharedAccessLength+routine < n*written
+	}
+
+	rewritten := make([]Command, len(commands))
+
+	for i, command := range commands {
+		if isShareable(command) && shared[command.String()] {
+			command.Kind = "shared-" + command.Kind
+			command.Synthetic = true
+		}
+
+		rewritten[i] = command
+	}
+
+	return rewritten
+}
+
+// A push or pop through the routine shared by those of its shape, which
+// comes back to the continuation it's given in D
+func (t *translator) sharedAccess(c Command) (string, error) {
+	shape := Command{Kind: strings.TrimPrefix(c.Kind, "shared-"), Args: c.Args}
+	if !isShareable(shape) {
+		return "", fmt.Errorf("invalid command: %s", c)
+	}
+
+	t.SharedRoutines[shape.String()] = true
+
+	retAddress := "RET_ADDRESS_SHARED" + strconv.Itoa(t.SharedCount)
+
+	lines := []string{
+		"@" + retAddress,
+		"D=A",
+		"@" + sharedRoutineName(shape.String()),
+		"0;JMP",
+		"(" + retAddress + ")",
+	}
+
+	t.SharedCount++
+
+	return joinLines(lines), nil
+}
+
+// The routines for the shapes used, in order of name. Each keeps the
+// continuation in the valueRegister (R14) while it pushes or pops, pops
+// working out the address in the locRegister (R13) as usual
+func createSharedRoutines(shapes map[string]bool) []string {
+	var names []string
+	for shape := range shapes {
+		names = append(names, shape)
+	}
+	sort.Strings(names)
+
+	routines := []string{}
+	scratch := newTranslator()
+
+	for _, shape := range names {
+		fields := strings.Fields(shape)
+
+		asm, err := scratch.translateCommand(Command{Kind: fields[0], Args: fields[1:]})
+		if err != nil {
+			continue
+		}
+
+		routine := joinLines([]string{
+			"(" + sharedRoutineName(shape) + ")",
+			valueRegister,
+			"M=D",
+		})
+
+		routine += asm + joinLines([]string{
+			valueRegister,
+			"A=M",
+			"0;JMP",
+		})
+
+		routines = append(routines, routine)
+	}
+
+	return routines
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+)
+
+// Comments like `// source: Main.jack:37`, left by a compiler to say where the
+// VM that follows came from
+const sourceCommentPrefix = "source:"
+
+// Where a comment says the VM after it came from. The comment is what's left
+// of a line once its code is blanked out
+func sourceComment(comment string) (string, bool) {
+	if !strings.HasPrefix(comment, "//") {
+		return "", false
+	}
+
+	comment = strings.TrimSpace(strings.TrimPrefix(comment, "//"))
+	if !strings.HasPrefix(comment, sourceCommentPrefix) {
+		return "", false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(comment, sourceCommentPrefix))
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	return fields[0], true
+}
+
+// Says where the asm that follows came from, when that's changed since the
+// last command
+func (t *translator) sourceAnnotation(command Command) string {
+	if command.Source == t.Source {
+		return ""
+	}
+
+	t.Source = command.Source
+	if command.Source == "" {
+		return ""
+	}
+
+	return "// " + sourceCommentPrefix + " " + command.Source + "\n"
+}
+
+// Where the command behind a mapping came from, e.g. " [Main.jack:37]", if a
+// comment said
+func (m sourceMapping) origin() string {
+	if m.Source == "" {
+		return ""
+	}
+
+	return " [" + m.Source + "]"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pins a file's statics to RAM from a given address, static i at the address
+// plus i, for hand-written asm or a device that expects them there:
+//
+//	//!static-base 200
+//
+// Other statics don't steer clear of the range, so it's for whoever pins it
+// to keep it to themselves
+const staticBasePrefix = "//!static-base"
+
+// What follows `//!static-base` on a line, if it is one
+func staticBaseLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+
+	value := strings.TrimPrefix(line, staticBasePrefix)
+	if value == line || value != "" && value[0] != ' ' && value[0] != '\t' {
+		return "", false
+	}
+
+	return strings.TrimSpace(value), true
+}
+
+// Notes the address a file's statics are pinned from. A file has one
+func (p *Parser) pinStatics(value string, lineNumber int) error {
+	base, err := strconv.Atoi(value)
+	if err != nil || base < 16 || base > 32767 {
+		return fmt.Errorf("%s:%d: invalid static base: %s", p.file, lineNumber, value)
+	}
+
+	if p.staticBase != 0 && p.staticBase != base {
+		return fmt.Errorf("%s:%d: statics already pinned from %d", p.file, lineNumber, p.staticBase)
+	}
+
+	p.staticBase = base
+
+	return nil
+}
+
+// Gives the file's commands the address its statics are pinned from, if they
+// are, checking they all fit in RAM
+func (p *Parser) pinnedStatics(commands []Command) ([]Command, error) {
+	if p.staticBase == 0 {
+		return commands, nil
+	}
+
+	for i, command := range commands {
+		commands[i].StaticBase = p.staticBase
+
+		if (command.Kind == "push" || command.Kind == "pop") && len(command.Args) == 2 && command.Args[0] == "static" {
+			if index, err := strconv.Atoi(command.Args[1]); err == nil && p.staticBase+index > 32767 {
+				return nil, fmt.Errorf("%s:%d: static %d is outside the RAM from %d", command.File, command.Line, index, p.staticBase)
+			}
+		}
+	}
+
+	return commands, nil
+}
+
+// The address a push or pop of a pinned static refers to
+func pinnedStatic(command Command) (int, bool) {
+	if command.StaticBase == 0 || len(command.Args) != 2 {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(command.Args[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return command.StaticBase + index, true
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where asm goes as it's translated, a piece at a time: collected in memory,
+// or written straight out. Notes the labels the asm refers to, so that only
+// the routines in use need be laid out, and counts the instructions
+type asmSink struct {
+	pieces []string
+	w      *bufio.Writer
+	// The temporary file behind w, for asm held back to be written later
+	spool        *os.File
+	referenced   map[string]bool
+	instructions int
+}
+
+func collectAsm() *asmSink {
+	return &asmSink{pieces: []string{}, referenced: map[string]bool{}}
+}
+
+func streamAsm(w io.Writer) *asmSink {
+	return &asmSink{w: bufio.NewWriterSize(w, 64<<10), referenced: map[string]bool{}}
+}
+
+func (s *asmSink) write(asm string) error {
+	noteReferences := routineLayout == "used"
+
+	// Walks the lines in place rather than splitting them out
+	for rest := asm; rest != ""; {
+		line := rest
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			line, rest = rest[:end], rest[end+1:]
+		} else {
+			rest = ""
+		}
+
+		if noteReferences && strings.HasPrefix(line, "@") {
+			s.referenced[line[1:]] = true
+		}
+
+		if isInstruction(strings.TrimSpace(line)) {
+			s.instructions++
+		}
+	}
+
+	if s.w == nil {
+		s.pieces = append(s.pieces, asm)
+		return nil
+	}
+
+	_, err := s.w.WriteString(withLineEndings(asm))
+	return err
+}
+
+func (s *asmSink) writeAll(pieces []string) error {
+	for _, asm := range pieces {
+		if err := s.write(asm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A sink for asm that has to come later in the output than asm yet to be
+// written, e.g. the program when the routines go before it. Streamed asm is
+// held in a temporary file rather than in memory
+func (s *asmSink) hold() (*asmSink, error) {
+	if s.w == nil {
+		return collectAsm(), nil
+	}
+
+	spool, err := os.CreateTemp("", "vmtranslator-*.asm")
+	if err != nil {
+		return nil, err
+	}
+
+	held := streamAsm(spool)
+	held.spool = spool
+
+	return held, nil
+}
+
+// Writes what a held sink holds, after what's been written already
+func (s *asmSink) release(held *asmSink) error {
+	for label := range held.referenced {
+		s.referenced[label] = true
+	}
+
+	if held.spool == nil {
+		return s.writeAll(held.pieces)
+	}
+
+	defer os.Remove(held.spool.Name())
+	defer held.spool.Close()
+
+	s.instructions += held.instructions
+
+	if err := held.w.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := held.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(s.w, held.spool)
+	return err
+}
+
+func (s *asmSink) flush() error {
+	if s.w == nil {
+		return nil
+	}
+
+	return s.w.Flush()
+}
+
+// Whether the asm can be written as it's translated. Everything that works
+// on the finished asm needs it all in memory
+func canStreamOutput() bool {
+	return emitMode == "asm" && !shouldStrip && !shouldDiff && romBankSize == 0 && !shouldEmitSymbols && reportPath == ""
+}
+
+// Translates the program at pathToTranslate straight into a file, without
+// holding the asm in memory. It's written beside the file under another name
+// and only replaces it once it's all there, so a translation that fails, or
+// comes out over -max-output-instructions, leaves the file as it was
+func streamTranslation(fileName string) error {
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+
+	temporary := file.Name()
+
+	written, err := streamTranslationTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = checkOutputSize(written, func() (string, error) {
+			asm, err := os.ReadFile(temporary)
+			return string(asm), err
+		})
+	}
+
+	// A file replaced keeps its permissions
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(fileName); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err == nil {
+		err = os.Chmod(temporary, mode)
+	}
+
+	if err == nil {
+		err = os.Rename(temporary, fileName)
+	}
+
+	if err != nil {
+		os.Remove(temporary)
+	}
+
+	return err
+}
+
+// Returns how many instructions were written
+func streamTranslationTo(w io.Writer) (int, error) {
+	out := streamAsm(w)
+
+	if shouldMarkGenerated {
+		if err := out.write(generatedHeader()); err != nil {
+			return 0, err
+		}
+	}
+
+	program := func(out *asmSink) error {
+		files, err := vmFiles(pathToTranslate)
+		if err != nil {
+			return err
+		}
+
+		if canUseBuildCache() {
+			return translateFilesCached(out, files)
+		}
+
+		// Without anything that looks across files, they can be translated
+		// and forgotten in turn, as many at a time as there are jobs
+		if translatedPerFile() {
+			included := newIncludeSet(files)
+
+			for len(files) > 0 {
+				batch := files
+				if len(batch) > translationJobs {
+					batch = batch[:translationJobs]
+				}
+				files = files[len(batch):]
+
+				commands, err := included.parseFiles(batch)
+				if err != nil {
+					return err
+				}
+
+				if err := translateTo(out, commands); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		commands, err := parseFiles(files)
+		if err != nil {
+			return err
+		}
+
+		if osFolder != "" {
+			commands, err = includeOS(commands, osFolder)
+			if err != nil {
+				return err
+			}
+		}
+
+		commands, err = optimize(commands)
+		if err != nil {
+			return err
+		}
+
+		return translateTo(out, commands)
+	}
+
+	// A single file is translated on its own, as it would be collected
+	var err error
+	if strings.HasSuffix(pathToTranslate, ".vm") {
+		err = program(out)
+	} else {
+		err = layoutProgramTo(out, program)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return out.instructions, out.flush()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "strings"
+
+// Cuts the asm down to what an assembler needs: no comments, blank lines or
+// indentation, and no labels nothing jumps to
+func stripAsm(instructions []string) []string {
+	var lines []string
+
+	for _, line := range asmLines(instructions) {
+		line = strings.TrimSpace(strings.Split(line, "//")[0])
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	referenced := map[string]bool{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "@") {
+			referenced[line[1:]] = true
+		}
+	}
+
+	stripped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "(") && !referenced[strings.Trim(line, "()")] {
+			continue
+		}
+
+		stripped = append(stripped, line+"\n")
+	}
+
+	return stripped
+}
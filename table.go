@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The order segments appear in as table columns
+var tableSegments = []string{"local", "argument", "this", "that", "pointer", "temp", "static"}
+
+// Lists the stack from the bottom up
+func describeStack(m *vmMachine) string {
+	var values []string
+	for address := memory.Stack; address < int(m.ram[0]); address++ {
+		values = append(values, strconv.Itoa(int(m.ram[address])))
+	}
+
+	if len(values) == 0 {
+		return "(empty)"
+	}
+
+	return strings.Join(values, " ")
+}
+
+// Runs a straight-line snippet a command at a time, tabulating the stack and
+// the segment cells it has touched after each one
+func stackTable(commands []Command, markdown bool) (string, error) {
+	m, err := newVMMachine(commands)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.boot(); err != nil {
+		return "", err
+	}
+
+	touched := map[string]map[int]Command{}
+
+	for _, command := range commands {
+		if command.Kind != "push" && command.Kind != "pop" || command.Args[0] == "constant" {
+			continue
+		}
+
+		if touched[command.Args[0]] == nil {
+			touched[command.Args[0]] = map[int]Command{}
+		}
+	}
+
+	header := []string{"#", "command", "stack"}
+	var segments []string
+	for _, segment := range tableSegments {
+		if touched[segment] != nil {
+			segments = append(segments, segment)
+			header = append(header, segment)
+		}
+	}
+
+	row := func(n string, command string) []string {
+		row := []string{n, command, describeStack(m)}
+
+		for _, segment := range segments {
+			var indexes []int
+			for index := range touched[segment] {
+				indexes = append(indexes, index)
+			}
+			sort.Ints(indexes)
+
+			var cells []string
+			for _, index := range indexes {
+				// Through the command, as statics belong to its file
+				address, _ := m.address(touched[segment][index])
+				cells = append(cells, fmt.Sprintf("[%d]=%d", index, m.ram[address]))
+			}
+
+			row = append(row, strings.Join(cells, " "))
+		}
+
+		return row
+	}
+
+	rows := [][]string{row("", "(start)")}
+
+	for i, command := range commands {
+		switch command.Kind {
+		case "label", "goto", "if-goto", "function", "call", "return":
+			return "", fmt.Errorf("%s:%d: %s isn't straight-line code", command.File, command.Line, command)
+		}
+
+		if err := m.step(); err != nil {
+			return "", err
+		}
+
+		if command.Kind == "push" || command.Kind == "pop" {
+			if index, err := strconv.Atoi(command.Args[1]); err == nil && touched[command.Args[0]] != nil {
+				touched[command.Args[0]][index] = command
+			}
+		}
+
+		rows = append(rows, row(strconv.Itoa(i+1), command.String()))
+	}
+
+	return reportTable(header, rows, markdown), nil
+}
+
+// Prints a table of the stack and segments after each command of a
+// straight-line snippet
+func tableCommand(args []string) {
+	markdown := flag.Bool("md", false, "print the table as Markdown")
+	parseFlags(args)
+
+	files, err := vmFiles(pathToTranslate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands, err := parseFiles(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	table, err := stackTable(commands, *markdown)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(table)
+}
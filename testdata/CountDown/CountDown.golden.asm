@@ -0,0 +1,247 @@
+@START
+0;JMP
+(RETURN)
+@5
+D=A
+@LCL
+A=M-D
+D=M
+@R13
+M=D
+@SP
+M=M-1
+A=M
+D=M
+@ARG
+A=M
+M=D
+@ARG
+D=M+1
+@SP
+M=D
+@LCL
+A=M-1
+D=M
+@THAT
+M=D
+@LCL
+D=M
+@2
+D=D-A
+A=D
+D=M
+@THIS
+M=D
+@LCL
+D=M
+@3
+D=D-A
+A=D
+D=M
+@ARG
+M=D
+@LCL
+D=M
+@4
+D=D-A
+A=D
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(CALL)
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@LCL
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@ARG
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THIS
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THAT
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@SP
+D=M
+@R14
+D=D-M
+@5
+D=D-A
+@ARG
+M=D
+@SP
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(LT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_LT
+D;JGE
+@SP
+A=M
+M=-1
+(END_LT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(GT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_GT
+D;JLE
+@SP
+A=M
+M=-1
+(END_GT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(EQ)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_EQ
+D;JNE
+@SP
+A=M
+M=-1
+(END_EQ)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(START)
+@0
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+@LCL
+A=M
+M=D
+(Sys.init$LOOP)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@SP
+AM=M-1
+D=M
+@LCL
+A=M
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=M-D
+@SP
+AM=M-1
+D=M
+@ARG
+A=M
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+@Sys.init$LOOP
+D;JNE
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
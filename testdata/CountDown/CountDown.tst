@@ -0,0 +1,26 @@
+// Steps CountDown.vm through its loop from 3, outputting the sum so far and
+// what's left to add after each time round
+load CountDown.vm,
+output-file CountDown.out,
+compare-to CountDown.cmp,
+output-list RAM[0]%D1.6.1 RAM[300]%D1.6.1 RAM[400]%D1.6.1;
+
+set RAM[0] 256,
+set RAM[1] 300,
+set RAM[2] 400,
+set RAM[400] 3;
+
+repeat 2 {
+  vmstep;
+}
+output;
+
+repeat 3 {
+  repeat 11 {
+    vmstep;
+  }
+  output;
+}
+
+vmstep;
+output;
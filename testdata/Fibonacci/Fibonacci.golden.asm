@@ -0,0 +1,311 @@
+@256
+D=A
+@SP
+M=D
+@Fibonacci.Sys.init
+D=A
+@R13
+M=D
+@0
+D=A
+@R14
+M=D
+@Fibonacci.Sys.init$ret4
+D=A
+@CALL
+0;JMP
+(Fibonacci.Sys.init$ret4)
+(RETURN)
+@5
+D=A
+@LCL
+A=M-D
+D=M
+@R13
+M=D
+@SP
+M=M-1
+A=M
+D=M
+@ARG
+A=M
+M=D
+@ARG
+D=M+1
+@SP
+M=D
+@LCL
+A=M-1
+D=M
+@THAT
+M=D
+@LCL
+D=M
+@2
+D=D-A
+A=D
+D=M
+@THIS
+M=D
+@LCL
+D=M
+@3
+D=D-A
+A=D
+D=M
+@ARG
+M=D
+@LCL
+D=M
+@4
+D=D-A
+A=D
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(CALL)
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@LCL
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@ARG
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THIS
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THAT
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@SP
+D=M
+@R14
+D=D-M
+@5
+D=D-A
+@ARG
+M=D
+@SP
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(LT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_LT
+D;JGE
+@SP
+A=M
+M=-1
+(END_LT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(GT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_GT
+D;JLE
+@SP
+A=M
+M=-1
+(END_GT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(EQ)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_EQ
+D;JNE
+@SP
+A=M
+M=-1
+(END_EQ)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(Fibonacci.Main.fibonacci)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@2
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@RET_ADDRESS_LT1
+D=A
+@LT
+0;JMP
+(RET_ADDRESS_LT1)
+@SP
+AM=M-1
+D=M
+@Main.fibonacci$BASE
+D;JNE
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@2
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=M-D
+@Fibonacci.Main.fibonacci
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Fibonacci.Main.fibonacci$ret5
+D=A
+@CALL
+0;JMP
+(Fibonacci.Main.fibonacci$ret5)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=M-D
+@Fibonacci.Main.fibonacci
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Fibonacci.Main.fibonacci$ret6
+D=A
+@CALL
+0;JMP
+(Fibonacci.Main.fibonacci$ret6)
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@RETURN
+0;JMP
+(Main.fibonacci$BASE)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RETURN
+0;JMP
+(Fibonacci.Sys.init)
+@10
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@Fibonacci.Main.fibonacci
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Fibonacci.Sys.init$ret7
+D=A
+@CALL
+0;JMP
+(Fibonacci.Sys.init$ret7)
+@SP
+AM=M-1
+D=M
+@Sys.vm.0
+M=D
+(Sys.init$END)
+@Sys.init$END
+0;JMP
@@ -0,0 +1,645 @@
+@256
+D=A
+@SP
+M=D
+@Optimized.Sys.init
+D=A
+@R13
+M=D
+@0
+D=A
+@R14
+M=D
+@Optimized.Sys.init$ret5
+D=A
+@CALL
+0;JMP
+(Optimized.Sys.init$ret5)
+(RETURN)
+@5
+D=A
+@LCL
+A=M-D
+D=M
+@R13
+M=D
+@SP
+M=M-1
+A=M
+D=M
+@ARG
+A=M
+M=D
+@ARG
+D=M+1
+@SP
+M=D
+@LCL
+A=M-1
+D=M
+@THAT
+M=D
+@LCL
+D=M
+@2
+D=D-A
+A=D
+D=M
+@THIS
+M=D
+@LCL
+D=M
+@3
+D=D-A
+A=D
+D=M
+@ARG
+M=D
+@LCL
+D=M
+@4
+D=D-A
+A=D
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(CALL)
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@LCL
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@ARG
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THIS
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@THAT
+D=M
+@SP
+A=M
+M=D
+@SP
+M=M+1
+@SP
+D=M
+@R14
+D=D-M
+@5
+D=D-A
+@ARG
+M=D
+@SP
+D=M
+@LCL
+M=D
+@R13
+A=M
+0;JMP
+(LT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_LT
+D;JGE
+@SP
+A=M
+M=-1
+(END_LT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(GT)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_GT
+D;JLE
+@SP
+A=M
+M=-1
+(END_GT)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(EQ)
+@R15
+M=D
+@SP
+AM=M-1
+D=M
+@SP
+AM=M-1
+D=M-D
+M=0
+@END_EQ
+D;JNE
+@SP
+A=M
+M=-1
+(END_EQ)
+@SP
+M=M+1
+@R15
+A=M
+0;JMP
+(Optimized.Main.sumTo)
+@SP
+A=M
+M=0
+@SP
+M=M+1
+@SP
+A=M
+M=0
+@SP
+M=M+1
+@0
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+@LCL
+A=M
+M=D
+@0
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@LCL
+A=D+M
+D=A
+@R13
+M=D
+@SP
+AM=M-1
+D=M
+@R13
+A=M
+M=D
+(Main.sumTo$LOOP)
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RET_ADDRESS_EQ1
+D=A
+@EQ
+0;JMP
+(RET_ADDRESS_EQ1)
+@SP
+AM=M-1
+D=M
+@Main.sumTo$DONE
+D;JNE
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@SP
+AM=M-1
+D=M
+@LCL
+A=M
+M=D
+@1
+D=A
+@LCL
+A=M
+D=D+A
+A=D
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@1
+D=A
+@LCL
+A=D+M
+D=A
+@R13
+M=D
+@SP
+AM=M-1
+D=M
+@R13
+A=M
+M=D
+@Main.sumTo$LOOP
+0;JMP
+(Main.sumTo$DONE)
+@1
+D=A
+@LCL
+A=M
+D=D+A
+A=D
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RETURN
+0;JMP
+(Optimized.Main.max)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@ARG
+A=M
+D=D+A
+A=D
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RET_ADDRESS_GT1
+D=A
+@GT
+0;JMP
+(RET_ADDRESS_GT1)
+@SP
+AM=M-1
+D=M
+@Main.max$FIRST
+D;JNE
+@1
+D=A
+@ARG
+A=M
+D=D+A
+A=D
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RETURN
+0;JMP
+(Main.max$FIRST)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RETURN
+0;JMP
+(Optimized.Main.double)
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@RETURN
+0;JMP
+(Optimized.Main.fill)
+@SP
+A=M
+M=0
+@SP
+M=M+1
+@3000
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+@THAT
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+@THAT
+A=M
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@1
+D=A
+@THAT
+A=D+M
+D=A
+@R13
+M=D
+@SP
+AM=M-1
+D=M
+@R13
+A=M
+M=D
+@ARG
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@2
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@2
+D=A
+@THAT
+A=D+M
+D=A
+@R13
+M=D
+@SP
+AM=M-1
+D=M
+@R13
+A=M
+M=D
+@THAT
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@1
+D=A
+@THAT
+A=D+M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@2
+D=A
+@THAT
+A=D+M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@SP
+AM=M-1
+D=M
+A=A-1
+M=D+M
+@SP
+AM=M-1
+D=M
+@LCL
+A=M
+M=D
+@LCL
+A=M
+D=M
+@SP
+AM=M+1
+A=A-1
+M=D
+@RETURN
+0;JMP
+(Optimized.Sys.init)
+@5
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@Optimized.Main.sumTo
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Optimized.Sys.init$ret6
+D=A
+@CALL
+0;JMP
+(Optimized.Sys.init$ret6)
+@SP
+AM=M-1
+D=M
+@Sys.vm.0
+M=D
+@7
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@3
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@Optimized.Main.max
+D=A
+@R13
+M=D
+@2
+D=A
+@R14
+M=D
+@Optimized.Sys.init$ret7
+D=A
+@CALL
+0;JMP
+(Optimized.Sys.init$ret7)
+@SP
+AM=M-1
+D=M
+@Sys.vm.1
+M=D
+@6
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@Optimized.Main.double
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Optimized.Sys.init$ret8
+D=A
+@CALL
+0;JMP
+(Optimized.Sys.init$ret8)
+@SP
+AM=M-1
+D=M
+@Sys.vm.2
+M=D
+@4
+D=A
+@SP
+AM=M+1
+A=A-1
+M=D
+@Optimized.Main.fill
+D=A
+@R13
+M=D
+@1
+D=A
+@R14
+M=D
+@Optimized.Sys.init$ret9
+D=A
+@CALL
+0;JMP
+(Optimized.Sys.init$ret9)
+@SP
+AM=M-1
+D=M
+@Sys.vm.3
+M=D
+(Sys.init$END)
+@Sys.init$END
+0;JMP
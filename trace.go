@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// What the machine code at each ROM address was translated from
+type programMap struct {
+	mappings []sourceMapping
+	// Index into mappings of the command each ROM address belongs to, or -1
+	// for the bootstrap and routines
+	owners []int
+	// The function each mapping's command is in
+	functions []string
+	// Labels by ROM address
+	labels map[int]string
+	// Where the program's breakpoint commands start
+	breakpoints map[int]bool
+}
+
+func newProgramMap(instructions []string, romSize int) *programMap {
+	p := &programMap{
+		mappings:    sourceMap(instructions),
+		owners:      make([]int, romSize),
+		labels:      map[int]string{},
+		breakpoints: map[int]bool{},
+	}
+
+	for address := range p.owners {
+		p.owners[address] = -1
+	}
+
+	function := ""
+
+	for i, mapping := range p.mappings {
+		if fields := strings.Fields(mapping.Command); len(fields) == 3 && fields[0] == "function" {
+			function = fields[1]
+		}
+
+		p.functions = append(p.functions, function)
+
+		if mapping.Command == "breakpoint" {
+			p.breakpoints[mapping.Start] = true
+		}
+
+		for address := mapping.Start; address < mapping.End && address < romSize; address++ {
+			p.owners[address] = i
+		}
+	}
+
+	for _, symbol := range resolveSymbols(asmLines(instructions)) {
+		if symbol.Label {
+			p.labels[symbol.Address] = symbol.Name
+		}
+	}
+
+	return p
+}
+
+// The index of the mapping of the command at a ROM address, or -1
+func (p *programMap) owner(address int) int {
+	if address < 0 || address >= len(p.owners) {
+		return -1
+	}
+
+	return p.owners[address]
+}
+
+// Watches a run for the faults VM programs hit: the stack pointer leaving the
+// stack, a guard trapping, or a jump off the end of the program
+type faultWatch struct {
+	program *programMap
+	traps   map[int]string
+	// Where the last VM command ran, as faults in routines belong to the
+	// command that called them
+	commandPC int
+	// The stack pointer only counts once the program (or whoever ran it) has
+	// set it up
+	stackSet bool
+}
+
+func newFaultWatch(p *programMap) *faultWatch {
+	w := &faultWatch{program: p, traps: map[int]string{}}
+
+	for address, label := range p.labels {
+		switch label {
+		case "STACK_OVERFLOW", "STACK_UNDERFLOW", pointerGuardHandler, breakpointTrap:
+			w.traps[address] = label
+		}
+	}
+
+	return w
+}
+
+// Describes the fault the CPU has just hit, if any
+func (w *faultWatch) check(cpu *hackCPU) string {
+	if w.program.owner(cpu.pc) >= 0 {
+		w.commandPC = cpu.pc
+	}
+
+	if cpu.fault != "" {
+		return cpu.fault
+	}
+
+	if trap, ok := w.traps[cpu.pc]; ok {
+		return "trapped to " + trap
+	}
+
+	sp := int(cpu.ram[0])
+	if sp >= memory.Stack && sp <= memory.Heap {
+		w.stackSet = true
+	} else if w.stackSet {
+		return fmt.Sprintf("stack pointer out of range: SP=%d", sp)
+	}
+
+	return ""
+}
+
+// Whether the instruction at a ROM address starts a VM command, returning
+// the command's mapping if it does
+func (p *programMap) commandAt(address int) (int, bool) {
+	owner := p.owner(address)
+
+	return owner, owner >= 0 && p.mappings[owner].Start == address
+}
+
+// A line of an execution trace, for the command about to run: the cycle,
+// where the command is and the stack pointer and top of stack it starts with
+func (p *programMap) traceLine(cpu *hackCPU, owner int) string {
+	mapping := p.mappings[owner]
+
+	function := p.functions[owner]
+	if function == "" {
+		function = "-"
+	}
+
+	sp := int(uint16(cpu.ram[0]))
+	top := "-"
+	if sp > memory.Stack && sp <= len(cpu.ram) {
+		top = strconv.Itoa(int(cpu.ram[sp-1]))
+	}
+
+	return fmt.Sprintf("%d\t%s\t%s:%d\t%s\t%d\t%s", cpu.cycles, function, mapping.File, mapping.Line, mapping.Command, sp, top)
+}
+
+// Deep recursion is cut short, as the frames nearest the fault matter most
+const maxTraceDepth = 100
+
+// A function's frame on the VM call stack, as the call left it in RAM
+type stackFrame struct {
+	// Where the function is: the ROM address of the call it's making, or of
+	// the instruction running in the innermost frame
+	pc int
+	// Index into mappings of the command at pc, or -1 for the bootstrap
+	// and routines
+	owner    int
+	function string
+	lcl, arg int
+	args     []int
+	// The label the function returns to, if a caller's frame could be found
+	returnLabel string
+}
+
+// Reconstructs the VM call stack from the frames the calls left in RAM,
+// innermost first. The innermost function is the one running the command at
+// pc. Deeper stacks than maxTraceDepth are cut short, saying so
+func (p *programMap) frames(cpu *hackCPU, pc int) ([]stackFrame, bool) {
+	var frames []stackFrame
+
+	ram := cpu.ram
+	lcl, arg := int(ram[1]), int(ram[2])
+	returnLabel := ""
+	validFrame := func(address int) bool {
+		return address-5 >= 0 && address < len(ram)
+	}
+
+	for depth := 0; depth < maxTraceDepth; depth++ {
+		frame := stackFrame{pc: pc, owner: p.owner(pc), lcl: lcl, arg: arg, returnLabel: returnLabel}
+		if frame.owner < 0 {
+			return append(frames, frame), false
+		}
+
+		frame.function = p.functions[frame.owner]
+		if frame.function == "" {
+			return append(frames, frame), false
+		}
+
+		// The argument count is the caller's to know
+		returnAddress := -1
+		nArgs := 0
+		caller := -1
+
+		if validFrame(lcl) {
+			returnAddress = int(uint16(ram[lcl-5]))
+			caller = p.owner(returnAddress - 1)
+
+			if caller >= 0 {
+				if fields := strings.Fields(p.mappings[caller].Command); len(fields) == 3 && fields[0] == "call" {
+					nArgs, _ = strconv.Atoi(fields[2])
+				}
+			}
+		}
+
+		for i := 0; i < nArgs && arg+i >= 0 && arg+i < len(ram); i++ {
+			frame.args = append(frame.args, int(ram[arg+i]))
+		}
+
+		frames = append(frames, frame)
+
+		if caller < 0 {
+			return frames, false
+		}
+
+		returnLabel = p.labels[returnAddress]
+
+		pc = returnAddress - 1
+		lcl, arg = int(ram[lcl-4]), int(ram[lcl-3])
+	}
+
+	return frames, true
+}
+
+// The VM call stack as text: each function with its arguments and where it is
+func (p *programMap) stackTrace(cpu *hackCPU, pc int) []string {
+	var trace []string
+
+	frames, truncated := p.frames(cpu, pc)
+
+	for _, frame := range frames {
+		if frame.owner < 0 {
+			trace = append(trace, fmt.Sprintf("ROM %d (bootstrap or shared routine)", frame.pc))
+			continue
+		}
+
+		mapping := p.mappings[frame.owner]
+		if frame.function == "" {
+			trace = append(trace, fmt.Sprintf("%s:%d %s", mapping.File, mapping.Line, mapping.Command))
+			continue
+		}
+
+		var args []string
+		for _, value := range frame.args {
+			args = append(args, strconv.Itoa(value))
+		}
+
+		text := fmt.Sprintf("%s(%s)\n\t%s:%d %s%s", frame.function, strings.Join(args, ", "), mapping.File, mapping.Line, mapping.Command, mapping.origin())
+		if frame.returnLabel != "" {
+			text += ", returns to " + frame.returnLabel
+		}
+
+		trace = append(trace, text)
+	}
+
+	if truncated {
+		trace = append(trace, "...")
+	}
+
+	return trace
+}
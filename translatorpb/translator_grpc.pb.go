@@ -0,0 +1,249 @@
+// The translator as a gRPC service, which serve -grpc offers. The calls are
+// implemented, independent of any transport, by translationService in
+// service.go, and serve offers the same calls over HTTP. translatorpb holds
+// the code protoc-gen-go and protoc-gen-go-grpc generate from this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: translator.proto
+
+package translatorpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Translator_Translate_FullMethodName = "/vmtranslator.Translator/Translate"
+	Translator_Check_FullMethodName     = "/vmtranslator.Translator/Check"
+	Translator_EmitIR_FullMethodName    = "/vmtranslator.Translator/EmitIR"
+)
+
+// TranslatorClient is the client API for Translator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TranslatorClient interface {
+	// Translates a program to asm, or says why it can't
+	Translate(ctx context.Context, in *Source, opts ...grpc.CallOption) (*Translation, error)
+	// Reports every problem in a program, each as soon as it's found
+	Check(ctx context.Context, in *Source, opts ...grpc.CallOption) (Translator_CheckClient, error)
+	// Sends the program's commands after the optimization passes, one by one
+	EmitIR(ctx context.Context, in *Source, opts ...grpc.CallOption) (Translator_EmitIRClient, error)
+}
+
+type translatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTranslatorClient(cc grpc.ClientConnInterface) TranslatorClient {
+	return &translatorClient{cc}
+}
+
+func (c *translatorClient) Translate(ctx context.Context, in *Source, opts ...grpc.CallOption) (*Translation, error) {
+	out := new(Translation)
+	err := c.cc.Invoke(ctx, Translator_Translate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *translatorClient) Check(ctx context.Context, in *Source, opts ...grpc.CallOption) (Translator_CheckClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Translator_ServiceDesc.Streams[0], Translator_Check_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &translatorCheckClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Translator_CheckClient interface {
+	Recv() (*Diagnostic, error)
+	grpc.ClientStream
+}
+
+type translatorCheckClient struct {
+	grpc.ClientStream
+}
+
+func (x *translatorCheckClient) Recv() (*Diagnostic, error) {
+	m := new(Diagnostic)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *translatorClient) EmitIR(ctx context.Context, in *Source, opts ...grpc.CallOption) (Translator_EmitIRClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Translator_ServiceDesc.Streams[1], Translator_EmitIR_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &translatorEmitIRClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Translator_EmitIRClient interface {
+	Recv() (*Command, error)
+	grpc.ClientStream
+}
+
+type translatorEmitIRClient struct {
+	grpc.ClientStream
+}
+
+func (x *translatorEmitIRClient) Recv() (*Command, error) {
+	m := new(Command)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TranslatorServer is the server API for Translator service.
+// All implementations must embed UnimplementedTranslatorServer
+// for forward compatibility
+type TranslatorServer interface {
+	// Translates a program to asm, or says why it can't
+	Translate(context.Context, *Source) (*Translation, error)
+	// Reports every problem in a program, each as soon as it's found
+	Check(*Source, Translator_CheckServer) error
+	// Sends the program's commands after the optimization passes, one by one
+	EmitIR(*Source, Translator_EmitIRServer) error
+	mustEmbedUnimplementedTranslatorServer()
+}
+
+// UnimplementedTranslatorServer must be embedded to have forward compatible implementations.
+type UnimplementedTranslatorServer struct {
+}
+
+func (UnimplementedTranslatorServer) Translate(context.Context, *Source) (*Translation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Translate not implemented")
+}
+func (UnimplementedTranslatorServer) Check(*Source, Translator_CheckServer) error {
+	return status.Errorf(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedTranslatorServer) EmitIR(*Source, Translator_EmitIRServer) error {
+	return status.Errorf(codes.Unimplemented, "method EmitIR not implemented")
+}
+func (UnimplementedTranslatorServer) mustEmbedUnimplementedTranslatorServer() {}
+
+// UnsafeTranslatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TranslatorServer will
+// result in compilation errors.
+type UnsafeTranslatorServer interface {
+	mustEmbedUnimplementedTranslatorServer()
+}
+
+func RegisterTranslatorServer(s grpc.ServiceRegistrar, srv TranslatorServer) {
+	s.RegisterService(&Translator_ServiceDesc, srv)
+}
+
+func _Translator_Translate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Source)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TranslatorServer).Translate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Translator_Translate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TranslatorServer).Translate(ctx, req.(*Source))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Translator_Check_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Source)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TranslatorServer).Check(m, &translatorCheckServer{stream})
+}
+
+type Translator_CheckServer interface {
+	Send(*Diagnostic) error
+	grpc.ServerStream
+}
+
+type translatorCheckServer struct {
+	grpc.ServerStream
+}
+
+func (x *translatorCheckServer) Send(m *Diagnostic) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Translator_EmitIR_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Source)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TranslatorServer).EmitIR(m, &translatorEmitIRServer{stream})
+}
+
+type Translator_EmitIRServer interface {
+	Send(*Command) error
+	grpc.ServerStream
+}
+
+type translatorEmitIRServer struct {
+	grpc.ServerStream
+}
+
+func (x *translatorEmitIRServer) Send(m *Command) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Translator_ServiceDesc is the grpc.ServiceDesc for Translator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Translator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vmtranslator.Translator",
+	HandlerType: (*TranslatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Translate",
+			Handler:    _Translator_Translate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Check",
+			Handler:       _Translator_Check_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "EmitIR",
+			Handler:       _Translator_EmitIR_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "translator.proto",
+}
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A statement of a nand2tetris test script, such as `set RAM[0] 256`
+type tstStatement struct {
+	line  int
+	words []string
+	// The statements a repeat runs, count times (or forever if negative)
+	count int
+	body  []tstStatement
+}
+
+type tstToken struct {
+	text string
+	line int
+}
+
+// Splits a test script into words and the punctuation between statements,
+// leaving out comments
+func tokenizeTestScript(source string) []tstToken {
+	var tokens []tstToken
+	line := 1
+
+	for i := 0; i < len(source); {
+		c := source[i]
+
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(source) - i - 2
+			}
+
+			line += strings.Count(source[i:i+2+end], "\n")
+			i += end + 4
+
+		case strings.ContainsRune(",;!{}", rune(c)):
+			tokens = append(tokens, tstToken{string(c), line})
+			i++
+
+		case c == '"':
+			end := strings.IndexByte(source[i+1:], '"')
+			if end < 0 {
+				end = len(source) - i - 1
+			}
+
+			tokens = append(tokens, tstToken{source[i : i+end+2], line})
+			i += end + 2
+
+		default:
+			start := i
+			for i < len(source) && !strings.ContainsRune(" \t\r\n,;!{}\"", rune(source[i])) && !strings.HasPrefix(source[i:], "//") {
+				i++
+			}
+
+			tokens = append(tokens, tstToken{source[start:i], line})
+		}
+	}
+
+	return tokens
+}
+
+// Parses statements up to the end of the script or of the enclosing block
+func parseTestStatements(tokens []tstToken, i *int) ([]tstStatement, error) {
+	var statements []tstStatement
+	var current *tstStatement
+
+	for *i < len(tokens) {
+		token := tokens[*i]
+		*i++
+
+		switch token.text {
+		case ",", ";", "!":
+			if current != nil {
+				statements = append(statements, *current)
+				current = nil
+			}
+
+		case "}":
+			if current != nil {
+				return nil, fmt.Errorf("line %d: missing terminator before }", token.line)
+			}
+
+			return statements, nil
+
+		case "{":
+			if current == nil || current.words[0] != "repeat" {
+				return nil, fmt.Errorf("line %d: only repeat blocks are supported", token.line)
+			}
+
+			current.count = -1
+			if len(current.words) > 1 {
+				count, err := strconv.Atoi(current.words[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid repeat count: %s", token.line, current.words[1])
+				}
+
+				current.count = count
+			}
+
+			body, err := parseTestStatements(tokens, i)
+			if err != nil {
+				return nil, err
+			}
+
+			current.body = body
+			statements = append(statements, *current)
+			current = nil
+
+		default:
+			if current == nil {
+				current = &tstStatement{line: token.line}
+			}
+
+			current.words = append(current.words, token.text)
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("line %d: missing terminator after %s", current.line, strings.Join(current.words, " "))
+	}
+
+	return statements, nil
+}
+
+func parseTestScript(source string) ([]tstStatement, error) {
+	i := 0
+	return parseTestStatements(tokenizeTestScript(source), &i)
+}
+
+// A column of the output list, e.g. `RAM[0]%D2.6.2`
+type tstOutput struct {
+	name   string
+	format byte
+	left   int
+	width  int
+	right  int
+}
+
+func parseTestOutput(spec string) (tstOutput, error) {
+	output := tstOutput{name: spec, format: 'D', left: 1, width: 6, right: 1}
+
+	name, format, ok := strings.Cut(spec, "%")
+	if !ok {
+		return output, nil
+	}
+
+	output.name = name
+
+	var sizes []int
+	for _, size := range strings.Split(format[1:], ".") {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return output, fmt.Errorf("invalid output format: %s", spec)
+		}
+
+		sizes = append(sizes, n)
+	}
+
+	if len(format) == 0 || !strings.ContainsRune("DXBS", rune(format[0])) || len(sizes) != 3 {
+		return output, fmt.Errorf("invalid output format: %s", spec)
+	}
+
+	output.format = format[0]
+	output.left, output.width, output.right = sizes[0], sizes[1], sizes[2]
+
+	return output, nil
+}
+
+// The column's name centred over it, as the test tools print headings
+func (o tstOutput) heading() string {
+	return cmpHeading(o.name, o.left+o.width+o.right)
+}
+
+func (o tstOutput) cell(value int) string {
+	var text string
+
+	switch o.format {
+	case 'X':
+		text = fmt.Sprintf("%04X", uint16(value))
+	case 'B':
+		text = fmt.Sprintf("%016b", uint16(value))
+	default:
+		text = strconv.Itoa(value)
+	}
+
+	if len(text) > o.width {
+		text = text[len(text)-o.width:]
+	}
+
+	return strings.Repeat(" ", o.left) + fmt.Sprintf("%*s", o.width, text) + strings.Repeat(" ", o.right)
+}
+
+// Runs a test script against the Hack CPU or, for scripts that load VM code,
+// the VM machine
+type testRunner struct {
+	dir     string
+	cpu     *hackCPU
+	vm      *vmMachine
+	outputs []tstOutput
+	// The output file's lines so far, the columns of each and the compare
+	// file's lines
+	lines      []string
+	columns    [][]string
+	compare    []string
+	outputFile string
+	// The RAM addresses of the loaded program's statics, for dumps
+	statics []int
+	// Asm to load in place of any the script names, and whether to skip the
+	// compare file, for comparing the output with another run's
+	reference     string
+	ignoreCompare bool
+}
+
+// The program a `load` names. Asm that this translator would produce from .vm
+// files beside the script is translated afresh rather than read from disk
+func (r *testRunner) load(name string) error {
+	if name == "" || filepath.Ext(name) == ".vm" || filepath.Ext(name) == "" {
+		files, err := vmFiles(filepath.Join(r.dir, name))
+		if err != nil {
+			return err
+		}
+
+		commands, err := parseFiles(files)
+		if err != nil {
+			return err
+		}
+
+		r.cpu = nil
+		r.vm, err = newVMMachine(commands)
+		if err != nil {
+			return err
+		}
+
+		r.statics = vmStatics(r.vm)
+
+		// Like the VM emulator, start at Sys.init if there is one, leaving the
+		// script to set up the stack
+		if start, ok := r.vm.layout.functions["Sys.init"]; ok {
+			r.vm.pc = start
+		}
+
+		return nil
+	}
+
+	base := strings.TrimSuffix(name, ".asm")
+	source := ""
+
+	if _, err := os.Stat(filepath.Join(r.dir, base+".vm")); err == nil {
+		source = filepath.Join(r.dir, base+".vm")
+	} else if files, err := vmFiles(r.dir); err == nil && len(files) > 0 && base == filepath.Base(r.dir) {
+		source = r.dir
+	}
+
+	var lines []string
+
+	if r.reference != "" {
+		data, err := os.ReadFile(r.reference)
+		if err != nil {
+			return err
+		}
+
+		lines = strings.Split(string(data), "\n")
+	} else if source != "" {
+		pathToTranslate = source
+
+		instructions, err := loadFolder(source)
+		if err != nil {
+			return err
+		}
+
+		lines = asmLines(instructions)
+	} else {
+		data, err := os.ReadFile(filepath.Join(r.dir, name))
+		if err != nil {
+			return err
+		}
+
+		lines = strings.Split(string(data), "\n")
+	}
+
+	rom, err := assemble(lines)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	r.vm = nil
+	r.cpu = newHackCPU(rom)
+	r.statics = asmVariables(resolveSymbols(lines))
+
+	return nil
+}
+
+func (r *testRunner) ram() []int16 {
+	if r.vm != nil {
+		return r.vm.ram
+	}
+
+	return r.cpu.ram
+}
+
+var vmPointerNames = map[string]int{"sp": 0, "local": 1, "argument": 2, "this": 3, "that": 4}
+
+// Where a script variable lives, e.g. RAM[256], D, or local[2] for VM code
+func (r *testRunner) variable(name string) (*int16, error) {
+	if r.cpu == nil && r.vm == nil {
+		return nil, fmt.Errorf("nothing loaded")
+	}
+
+	if strings.HasPrefix(name, "RAM[") && strings.HasSuffix(name, "]") {
+		address, err := strconv.Atoi(name[4 : len(name)-1])
+		if err != nil || address < 0 || address >= len(r.ram()) {
+			return nil, fmt.Errorf("invalid address: %s", name)
+		}
+
+		return &r.ram()[address], nil
+	}
+
+	if r.cpu != nil {
+		switch name {
+		case "A":
+			return &r.cpu.a, nil
+		case "D":
+			return &r.cpu.d, nil
+		}
+
+		return nil, fmt.Errorf("unknown variable: %s", name)
+	}
+
+	if pointer, ok := vmPointerNames[name]; ok {
+		return &r.vm.ram[pointer], nil
+	}
+
+	if segment, index, ok := strings.Cut(strings.TrimSuffix(name, "]"), "["); ok {
+		address, err := r.vm.address(Command{Kind: "push", Args: []string{segment, index}, File: "script"})
+		if err != nil {
+			return nil, err
+		}
+
+		return &r.vm.ram[address], nil
+	}
+
+	return nil, fmt.Errorf("unknown variable: %s", name)
+}
+
+func (r *testRunner) get(name string) (int, error) {
+	switch {
+	case name == "time" && r.cpu != nil:
+		return r.cpu.cycles, nil
+	case name == "PC" && r.cpu != nil:
+		return r.cpu.pc, nil
+	}
+
+	variable, err := r.variable(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(*variable), nil
+}
+
+// Values may be given in decimal or, with a %X, %B or %D prefix, in hex,
+// binary or decimal
+func parseTestValue(text string) (int, error) {
+	base := 10
+
+	if strings.HasPrefix(text, "%") && len(text) > 1 {
+		base = map[byte]int{'X': 16, 'B': 2, 'D': 10}[text[1]]
+		text = text[2:]
+	}
+
+	if base == 0 {
+		return 0, fmt.Errorf("invalid value: %s", text)
+	}
+
+	value, err := strconv.ParseInt(text, base, 32)
+	if err != nil || value < -32768 || value > 65535 {
+		return 0, fmt.Errorf("invalid value: %s", text)
+	}
+
+	return int(value), nil
+}
+
+func (r *testRunner) set(name string, text string) error {
+	value, err := parseTestValue(text)
+	if err != nil {
+		return err
+	}
+
+	if name == "PC" && r.cpu != nil {
+		r.cpu.pc = value
+		r.cpu.halted = false
+		return nil
+	}
+
+	variable, err := r.variable(name)
+	if err != nil {
+		return err
+	}
+
+	*variable = int16(value)
+
+	return nil
+}
+
+// How far the program has run, in clock cycles or VM commands
+func (r *testRunner) time() string {
+	if r.vm != nil {
+		return fmt.Sprintf("VM step %d", r.vm.steps)
+	}
+
+	if r.cpu != nil {
+		return fmt.Sprintf("cycle %d", r.cpu.cycles)
+	}
+
+	return "before loading"
+}
+
+// Adds a line to the output, checking it against the compare file. Value
+// lines that differ are reported by column
+func (r *testRunner) write(line string, values bool) error {
+	var names []string
+	if values {
+		for _, output := range r.outputs {
+			names = append(names, output.name)
+		}
+	}
+
+	r.lines = append(r.lines, line)
+	r.columns = append(r.columns, names)
+
+	if r.compare == nil {
+		return nil
+	}
+
+	n := len(r.lines)
+	if n > len(r.compare) {
+		return fmt.Errorf("comparison failure at line %d (%s): the compare file ends at line %d", n, r.time(), len(r.compare))
+	}
+
+	if matchesCompareLine(line, r.compare[n-1]) {
+		return nil
+	}
+
+	return fmt.Errorf("comparison failure at line %d (%s): %s", n, r.time(), describeMismatch(names, line, r.compare[n-1]))
+}
+
+// Compare files may hold * for characters that can be anything
+func matchesCompareLine(line string, expected string) bool {
+	if len(line) != len(expected) {
+		return false
+	}
+
+	for i := range line {
+		if expected[i] != '*' && expected[i] != line[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *testRunner) exec(statements []tstStatement) error {
+	for _, statement := range statements {
+		if err := r.execStatement(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *testRunner) execStatement(statement tstStatement) error {
+	words := statement.words
+	arg := func(n int) string {
+		if n < len(words) {
+			return words[n]
+		}
+
+		return ""
+	}
+
+	var err error
+
+	switch words[0] {
+	case "repeat":
+		for n := 0; statement.count < 0 || n < statement.count; n++ {
+			if err := r.exec(statement.body); err != nil {
+				return err
+			}
+
+			if statement.count < 0 && (r.cpu != nil && r.cpu.halted || r.vm != nil && r.vm.halted) {
+				break
+			}
+		}
+
+	case "load":
+		err = r.load(arg(1))
+
+	case "output-file":
+		r.outputFile = arg(1)
+
+	case "compare-to":
+		if r.ignoreCompare {
+			break
+		}
+
+		var data []byte
+		data, err = os.ReadFile(filepath.Join(r.dir, arg(1)))
+		if err == nil {
+			r.compare = strings.Split(strings.TrimRight(strings.ReplaceAll(string(data), "\r", ""), "\n"), "\n")
+		}
+
+	case "output-list":
+		r.outputs = nil
+
+		var headings []string
+		for _, spec := range words[1:] {
+			output, err := parseTestOutput(spec)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", statement.line, err)
+			}
+
+			r.outputs = append(r.outputs, output)
+			headings = append(headings, output.heading())
+		}
+
+		err = r.write("|"+strings.Join(headings, "|")+"|", false)
+
+	case "output":
+		var cells []string
+		for _, output := range r.outputs {
+			value, err := r.get(output.name)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", statement.line, err)
+			}
+
+			cells = append(cells, output.cell(value))
+		}
+
+		err = r.write("|"+strings.Join(cells, "|")+"|", true)
+
+	case "set":
+		err = r.set(arg(1), arg(2))
+
+	case "ticktock", "tock":
+		if r.cpu == nil {
+			return fmt.Errorf("line %d: %s needs an .asm program loaded", statement.line, words[0])
+		}
+
+		r.cpu.step()
+
+	case "tick":
+
+	case "vmstep":
+		if r.vm == nil {
+			return fmt.Errorf("line %d: vmstep needs VM code loaded", statement.line)
+		}
+
+		if !r.vm.halted {
+			err = r.vm.step()
+		}
+
+	case "dump":
+		// Not part of the standard language: dump FILE [RANGE...] writes the
+		// RAM as -dump does
+		if r.cpu == nil && r.vm == nil {
+			return fmt.Errorf("line %d: nothing loaded", statement.line)
+		}
+
+		spec := "pointers,temp,statics,stack"
+		if len(words) > 2 {
+			spec = strings.Join(words[2:], ",")
+		}
+
+		err = saveDump(filepath.Join(r.dir, arg(1)), spec, r.ram(), r.statics)
+
+	case "echo":
+		fmt.Println(strings.Trim(strings.Join(words[1:], " "), `"`))
+
+	case "clear-echo":
+
+	default:
+		return fmt.Errorf("line %d: unsupported command: %s", statement.line, words[0])
+	}
+
+	if err != nil {
+		return fmt.Errorf("line %d: %w", statement.line, err)
+	}
+
+	return nil
+}
+
+// Runs a script, writing its output file beside it as the test tools do
+func runTestScript(script string) error {
+	source, err := os.ReadFile(script)
+	if err != nil {
+		return err
+	}
+
+	statements, err := parseTestScript(string(source))
+	if err != nil {
+		return fmt.Errorf("%s: %w", script, err)
+	}
+
+	r := &testRunner{dir: filepath.Dir(script)}
+	runErr := r.exec(statements)
+
+	if r.outputFile != "" {
+		output := strings.Join(r.lines, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(r.dir, r.outputFile), []byte(output), 0644); err != nil {
+			return err
+		}
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("%s: %w", script, runErr)
+	}
+
+	return nil
+}
+
+// Runs nand2tetris test scripts against this translator's output
+func testScriptCommand(args []string) {
+	parseFlags(args)
+
+	if flag.NArg() == 0 {
+		log.Fatal("tst needs at least one .tst script")
+	}
+
+	failed := false
+
+	for _, script := range flag.Args() {
+		if err := runTestScript(script); err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("%s: end of script, comparison ended successfully\n", script)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Runs VM commands directly on a model of the Hack RAM, without translating
+// them to asm first
+type vmMachine struct {
+	ram      []int16
+	commands []Command
+	layout   programLayout
+	// Index of the next command to run
+	pc     int
+	halted bool
+	// How many commands have run
+	steps int
+}
+
+func newVMMachine(commands []Command) (*vmMachine, error) {
+	layout, err := layoutCommands(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return addresses live on the stack
+	if len(commands) > 32767 {
+		return nil, fmt.Errorf("too many commands to run: %d", len(commands))
+	}
+
+	m := &vmMachine{
+		ram:      make([]int16, memory.Keyboard+1),
+		commands: commands,
+		layout:   layout,
+	}
+	m.ram[0] = int16(memory.Stack)
+
+	return m, nil
+}
+
+func (m *vmMachine) push(value int16) {
+	m.ram[m.ram[0]] = value
+	m.ram[0]++
+}
+
+func (m *vmMachine) pop() int16 {
+	m.ram[0]--
+	return m.ram[m.ram[0]]
+}
+
+// The RAM address a push or pop goes to
+func (m *vmMachine) address(command Command) (int, error) {
+	segment := command.Args[0]
+
+	index, err := strconv.Atoi(command.Args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%s:%d: invalid index: %s", command.File, command.Line, command.Args[1])
+	}
+
+	var address int
+
+	switch segment {
+	case "local":
+		address = int(m.ram[1]) + index
+	case "argument":
+		address = int(m.ram[2]) + index
+	case "this":
+		address = int(m.ram[3]) + index
+	case "that":
+		address = int(m.ram[4]) + index
+	case "pointer":
+		address = 3 + index
+	case "temp":
+		address = memory.Temp + index
+	case "global":
+		if address, err = globalAddress(index); err != nil {
+			return 0, fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
+	case "static":
+		var ok bool
+		if address, ok = m.layout.statics[staticKey(command.File, command.Args[1])]; !ok {
+			return 0, fmt.Errorf("%s:%d: unknown static: %s", command.File, command.Line, command.Args[1])
+		}
+	default:
+		return 0, fmt.Errorf("%s:%d: invalid segment: %s", command.File, command.Line, segment)
+	}
+
+	if address < 0 || address >= len(m.ram) {
+		return 0, fmt.Errorf("%s:%d: %s is outside the RAM (address %d)", command.File, command.Line, command, address)
+	}
+
+	return address, nil
+}
+
+// Shifts go by the top of the stack, like the SHL and SHR routines
+func vmShift(kind string, x int16, y int16) int16 {
+	switch {
+	case y <= 0:
+		return x
+	case y >= 16 && kind == "shl":
+		return 0
+	case y >= 16:
+		return x >> 15
+	case kind == "shl":
+		return int16(uint16(x) << y)
+	}
+
+	return x >> y
+}
+
+// Dividing by zero gives 0, and leaves x as the remainder, like the DIV and
+// MOD routines
+func vmDivide(kind string, x int16, y int16) int16 {
+	switch {
+	case y == 0 && kind == "div":
+		return 0
+	case y == 0:
+		return x
+	case kind == "div":
+		return x / y
+	}
+
+	return x % y
+}
+
+func vmBool(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
+
+func vmBinary(kind string, x int16, y int16) int16 {
+	switch kind {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return vmBool(x == y)
+	case "gt":
+		return vmBool(x > y)
+	case "lt":
+		return vmBool(x < y)
+	case "mult":
+		return x * y
+	case "min", "max":
+		if x < y == (kind == "min") {
+			return x
+		}
+
+		return y
+	case "div", "mod":
+		return vmDivide(kind, x, y)
+	}
+
+	return vmShift(kind, x, y)
+}
+
+// Starts the program the way the bootstrap would, by calling the entry
+// function, or from the top with the segment bases the course's tests use
+// when there's no such function
+func (m *vmMachine) boot() error {
+	if _, ok := m.layout.functions[entryFunction]; ok {
+		for i := 0; i < entryArgs; i++ {
+			m.push(0)
+		}
+
+		return m.call(Command{Kind: "call", Args: []string{entryFunction, strconv.Itoa(entryArgs)}, File: "bootstrap"})
+	}
+
+	m.ram[1] = int16(testSegmentBases["local"])
+	m.ram[2] = int16(testSegmentBases["argument"])
+	m.ram[3] = int16(testSegmentBases["this"])
+	m.ram[4] = int16(testSegmentBases["that"])
+
+	return nil
+}
+
+func (m *vmMachine) call(command Command) error {
+	nArgs, err := strconv.Atoi(command.Args[1])
+	if err != nil {
+		return fmt.Errorf("%s:%d: invalid argument count: %s", command.File, command.Line, command.Args[1])
+	}
+
+	// Return addresses are command indexes, which have to fit on the stack
+	m.push(int16(m.pc))
+	for pointer := 1; pointer <= 4; pointer++ {
+		m.push(m.ram[pointer])
+	}
+
+	m.ram[2] = m.ram[0] - 5 - int16(nArgs)
+	m.ram[1] = m.ram[0]
+	m.pc = m.layout.functions[command.Args[0]]
+
+	return nil
+}
+
+func (m *vmMachine) ret() {
+	frame := m.ram[1]
+	returnAddress := m.ram[frame-5]
+
+	m.ram[m.ram[2]] = m.pop()
+	m.ram[0] = m.ram[2] + 1
+
+	for pointer := 4; pointer >= 1; pointer-- {
+		m.ram[pointer] = m.ram[frame-int16(5-pointer)]
+	}
+
+	m.pc = int(returnAddress)
+}
+
+// The function and line of the next command, e.g. Foo.bar (Foo.vm:42)
+func (m *vmMachine) location() string {
+	if m.pc < 0 || m.pc >= len(m.commands) {
+		return fmt.Sprintf("command %d", m.pc)
+	}
+
+	command := m.commands[m.pc]
+
+	for i := m.pc; i >= 0; i-- {
+		if m.commands[i].Kind == "function" {
+			return fmt.Sprintf("%s (%s:%d)", m.commands[i].Args[0], command.File, command.Line)
+		}
+	}
+
+	return fmt.Sprintf("%s:%d", command.File, command.Line)
+}
+
+// Runs the next command
+func (m *vmMachine) step() (err error) {
+	if m.pc < 0 || m.pc >= len(m.commands) {
+		m.halted = true
+		return nil
+	}
+
+	if m.layout.halts[m.pc] {
+		m.halted = true
+		return nil
+	}
+
+	command := m.commands[m.pc]
+	m.pc++
+	m.steps++
+
+	// A stack or frame that's wandered off the RAM shows up as a bad index
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("%s:%d: %s went outside the RAM", command.File, command.Line, command)
+		}
+	}()
+
+	switch command.Kind {
+	case "push":
+		if command.Args[0] == "constant" {
+			value, err := strconv.Atoi(command.Args[1])
+			if err != nil || value < 0 || value > 32767 {
+				return fmt.Errorf("%s:%d: invalid constant: %s", command.File, command.Line, command.Args[1])
+			}
+
+			m.push(int16(value))
+			return nil
+		}
+
+		address, err := m.address(command)
+		if err != nil {
+			return err
+		}
+
+		m.push(m.ram[address])
+
+	case "pop":
+		address, err := m.address(command)
+		if err != nil {
+			return err
+		}
+
+		m.ram[address] = m.pop()
+
+	case "neg":
+		m.push(-m.pop())
+
+	case "not":
+		m.push(^m.pop())
+
+	case "abs":
+		if x := m.pop(); x < 0 {
+			m.push(-x)
+		} else {
+			m.push(x)
+		}
+
+	case "add", "sub", "and", "or", "eq", "gt", "lt", "shl", "shr", "mult", "div", "mod", "min", "max":
+		y := m.pop()
+		x := m.pop()
+
+		m.push(vmBinary(command.Kind, x, y))
+
+	case "label", "breakpoint":
+
+	case "goto":
+		m.pc = m.layout.labels[scopedLabel(m.layout.scopes[m.pc-1], command.Args[0])]
+
+	case "if-goto":
+		if m.pop() != 0 {
+			m.pc = m.layout.labels[scopedLabel(m.layout.scopes[m.pc-1], command.Args[0])]
+		}
+
+	case "function":
+		locals, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid local count: %s", command.File, command.Line, command.Args[1])
+		}
+
+		for i := 0; i < locals; i++ {
+			m.push(0)
+		}
+
+	case "call":
+		return m.call(command)
+
+	case "return":
+		m.ret()
+
+	default:
+		return fmt.Errorf("%s:%d: can't run %s", command.File, command.Line, command)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Whether each file's translation is kept in a .vmcache folder beside the
+// .vm files, for the next build to reuse while nothing it depends on changes
+var useBuildCache bool
+
+const buildCacheFolder = ".vmcache"
+
+// A file's asm, and what its translator had counted by the end of it
+type buildCacheEntry struct {
+	Asm   string          `json:"asm"`
+	State translatorState `json:"state"`
+}
+
+// Whether the files can be translated one at a time, nothing looking across
+// them
+func translatedPerFile() bool {
+	return optimizationLevel < 2 && osFolder == "" && len(externalPasses) == 0 && favorMode == "speed"
+}
+
+// Cached translations only hold the asm, so not when the commands are kept
+func canUseBuildCache() bool {
+	return useBuildCache && translatedPerFile() && reportPath == "" && emitMode != "archive" && !shouldKeepCommands
+}
+
+// Everything besides a file and where translating it starts that its asm
+// depends on: the translator itself, the folder name labels start with and
+// the flags
+func buildCacheSettings() string {
+	var settings strings.Builder
+
+	if executable, err := os.Executable(); err == nil {
+		if info, err := os.Stat(executable); err == nil {
+			fmt.Fprintf(&settings, "%s %d %d\n", executable, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+
+	fmt.Fprintf(&settings, "folder %s\n", getFolderName())
+
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "path", "o", "jobs", "config", "MD", "pre", "post", "vmcache", "max-output-instructions":
+			return
+		}
+
+		fmt.Fprintf(&settings, "%s=%s\n", f.Name, f.Value)
+	})
+
+	return settings.String()
+}
+
+func buildCacheKey(settings string, fileName string, source []byte, start translatorState) (string, error) {
+	state, err := json.Marshal(start)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%d\n", settings, filepath.Base(fileName), len(source))
+	hash.Write(source)
+	hash.Write(state)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// A cached translation, if there is one. One that can't be read is as good
+// as missing
+func loadBuildCacheEntry(entryPath string) (buildCacheEntry, bool) {
+	var entry buildCacheEntry
+
+	contents, err := os.ReadFile(entryPath)
+	if err != nil {
+		return entry, false
+	}
+
+	if err := json.Unmarshal(contents, &entry); err != nil {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// Writes the entry under another name first, so a build running alongside
+// never reads half of one
+func saveBuildCacheEntry(entryPath string, entry buildCacheEntry) error {
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	temporary := entryPath + ".tmp"
+	if err := os.WriteFile(temporary, contents, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, entryPath)
+}
+
+// Translates the files in turn, taking each from the cache when the file,
+// the settings and the state translating it starts from are the same as
+// when it was cached. Those that aren't are translated and cached
+func translateFilesCached(out *asmSink, files []string) error {
+	folder := filepath.Join(filepath.Dir(files[0]), buildCacheFolder)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+
+	settings := buildCacheSettings()
+	included := newIncludeSet(files)
+
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		// What a file that may include others comes to depends on more than
+		// its source: on the files it includes, and which are in already
+		cacheable := !bytes.Contains(source, []byte("include"))
+		entryPath := ""
+
+		if cacheable {
+			key, err := buildCacheKey(settings, file, source, translation.translatorState)
+			if err != nil {
+				return err
+			}
+
+			entryPath = filepath.Join(folder, key+".json")
+
+			if entry, ok := loadBuildCacheEntry(entryPath); ok {
+				recordSourceFile(file)
+				translation.translatorState = entry.State.clone()
+
+				if err := out.write(entry.Asm); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		commands, err := included.parseFile(file, nil)
+		if err != nil {
+			return err
+		}
+
+		asm := collectAsm()
+		if err := translation.translateTo(asm, commands); err != nil {
+			return err
+		}
+
+		// The cache is only a shortcut, a build doesn't fail for want of it
+		if cacheable {
+			saveBuildCacheEntry(entryPath, buildCacheEntry{Asm: strings.Join(asm.pieces, ""), State: translation.translatorState})
+		}
+
+		if err := out.release(asm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Emits a WebAssembly text module exporting the RAM as memory "ram" (16-bit
+// words) and the program as function "run". Wasm has no goto, so the program
+// is split at every jump target and a br_table on the target number picks
+// where to carry on from
+type watBackend struct{}
+
+func (watBackend) Extension() string {
+	return ".wat"
+}
+
+const watPrelude = `  (memory (export "ram") 1)
+
+  (func $peek (param $a i32) (result i32)
+    local.get $a
+    i32.const 32767
+    i32.and
+    i32.const 1
+    i32.shl
+    i32.load16_s)
+
+  (func $poke (param $a i32) (param $v i32)
+    local.get $a
+    i32.const 32767
+    i32.and
+    i32.const 1
+    i32.shl
+    local.get $v
+    i32.store16)
+
+  (func $push (param $v i32)
+    i32.const 0
+    call $peek
+    local.get $v
+    call $poke
+    i32.const 0
+    i32.const 0
+    call $peek
+    i32.const 1
+    i32.add
+    call $poke)
+
+  (func $pop (result i32)
+    (local $sp i32)
+    i32.const 0
+    call $peek
+    i32.const 1
+    i32.sub
+    local.set $sp
+    i32.const 0
+    local.get $sp
+    call $poke
+    local.get $sp
+    call $peek)
+
+  ;; Wasm only looks at the low five bits of a shift count, so counts are
+  ;; clamped to where the result stops changing
+  (func $shl (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    local.get $y
+    i32.const 16
+    local.get $y
+    i32.const 16
+    i32.lt_s
+    select
+    i32.shl
+    local.get $x
+    local.get $y
+    i32.const 0
+    i32.gt_s
+    select)
+
+  (func $shr (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    local.get $y
+    i32.const 15
+    local.get $y
+    i32.const 15
+    i32.lt_s
+    select
+    i32.shr_s
+    local.get $x
+    local.get $y
+    i32.const 0
+    i32.gt_s
+    select)
+
+  ;; Dividing by zero traps in wasm, where it gives 0 and leaves x as the
+  ;; remainder in the DIV and MOD routines
+  (func $div (param $x i32) (param $y i32) (result i32)
+    local.get $y
+    i32.eqz
+    if (result i32)
+      i32.const 0
+    else
+      local.get $x
+      local.get $y
+      i32.div_s
+    end)
+
+  (func $mod (param $x i32) (param $y i32) (result i32)
+    local.get $y
+    i32.eqz
+    if (result i32)
+      local.get $x
+    else
+      local.get $x
+      local.get $y
+      i32.rem_s
+    end)
+
+  (func $min (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    local.get $y
+    local.get $x
+    local.get $y
+    i32.lt_s
+    select)
+
+  (func $max (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    local.get $y
+    local.get $x
+    local.get $y
+    i32.gt_s
+    select)
+
+  (func $abs (param $x i32) (result i32)
+    i32.const 0
+    local.get $x
+    i32.sub
+    local.get $x
+    local.get $x
+    i32.const 0
+    i32.lt_s
+    select)
+`
+
+var watOperators = map[string][]string{
+	"add": {"i32.add"}, "sub": {"i32.sub"}, "and": {"i32.and"}, "or": {"i32.or"},
+	"shl": {"call $shl"}, "shr": {"call $shr"},
+	"mult": {"i32.mul"}, "div": {"call $div"}, "mod": {"call $mod"},
+	"min": {"call $min"}, "max": {"call $max"},
+	"eq": {"i32.eq"}, "gt": {"i32.gt_s"}, "lt": {"i32.lt_s"},
+}
+
+// Where a command's code can be jumped to from, numbered in program order
+type watTargets struct {
+	indexes []int
+	number  map[int]int
+}
+
+func findWatTargets(layout programLayout, commands []Command) watTargets {
+	set := map[int]bool{0: true, len(commands): true}
+
+	for _, index := range layout.labels {
+		set[index] = true
+	}
+
+	for _, index := range layout.functions {
+		set[index] = true
+	}
+
+	for i, command := range commands {
+		if command.Kind == "call" {
+			set[i+1] = true
+		}
+	}
+
+	targets := watTargets{number: map[int]int{}}
+	for index := range set {
+		targets.indexes = append(targets.indexes, index)
+	}
+
+	sort.Ints(targets.indexes)
+
+	for n, index := range targets.indexes {
+		targets.number[index] = n
+	}
+
+	return targets
+}
+
+func (watBackend) Emit(commands []Command) (string, error) {
+	layout, err := layoutCommands(commands)
+	if err != nil {
+		return "", err
+	}
+
+	targets := findWatTargets(layout, commands)
+
+	lines := []string{
+		fmt.Sprintf(";; Translated from %s. Set up RAM through the exported memory, call", getFolderName()),
+		";; run, then read the results back",
+		"(module",
+		watPrelude,
+		`  (func (export "run")`,
+		"    (local $pc i32) (local $x i32) (local $y i32) (local $frame i32) (local $address i32)",
+	}
+
+	code := &watCode{}
+
+	if shouldStandardBootstrap || shouldSetStackPointer || shouldBootstrap {
+		code.add("i32.const 0", fmt.Sprintf("i32.const %d", memory.Stack), "call $poke")
+	}
+
+	// The entry function returns, if at all, to target -1, which halts
+	if shouldStandardBootstrap || shouldBootstrap {
+		init, ok := layout.functions[entryFunction]
+		if !ok {
+			return "", fmt.Errorf("bootstrap needs a %s function", entryFunction)
+		}
+
+		for i := 0; i < entryArgs; i++ {
+			code.add("i32.const 0", "call $push")
+		}
+
+		code.call(-1, entryArgs)
+		code.add(fmt.Sprintf("i32.const %d", targets.number[init]), "local.set $pc")
+	}
+
+	code.add("block $halt", "loop $dispatch")
+
+	for n := len(targets.indexes) - 1; n >= 0; n-- {
+		code.add(fmt.Sprintf("block $t%d", n))
+	}
+
+	table := "br_table"
+	for n := range targets.indexes {
+		table += fmt.Sprintf(" $t%d", n)
+	}
+
+	code.add("local.get $pc", table+" $halt", "end")
+
+	for i, command := range commands {
+		// Each target's code follows the end of its block
+		if n, ok := targets.number[i]; ok && n > 0 {
+			code.add(fmt.Sprintf("end ;; $t%d", n))
+		}
+
+		code.add(fmt.Sprintf(";; %s", command))
+
+		if err := code.command(layout, targets, i, command); err != nil {
+			return "", fmt.Errorf("%s:%d: %w", command.File, command.Line, err)
+		}
+	}
+
+	if n := targets.number[len(commands)]; n > 0 {
+		code.add(fmt.Sprintf("end ;; $t%d", n))
+	}
+
+	code.add("br $halt", "end", "end")
+
+	for _, line := range code.lines {
+		lines = append(lines, "    "+line)
+	}
+
+	lines = append(lines, "  )", ")")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+type watCode struct {
+	lines []string
+}
+
+func (c *watCode) add(lines ...string) {
+	c.lines = append(c.lines, lines...)
+}
+
+func (c *watCode) jump(targets watTargets, index int) {
+	c.add(fmt.Sprintf("i32.const %d", targets.number[index]), "local.set $pc", "br $dispatch")
+}
+
+// Leaves the address a segment access refers to on the stack
+func (c *watCode) address(layout programLayout, command Command) error {
+	segment, index := command.Args[0], command.Args[1]
+
+	n, err := strconv.Atoi(index)
+	if err != nil {
+		return fmt.Errorf("invalid index: %s", index)
+	}
+
+	pointers := map[string]int{"local": 1, "argument": 2, "this": 3, "that": 4}
+
+	switch segment {
+	case "local", "argument", "this", "that":
+		c.add(fmt.Sprintf("i32.const %d", pointers[segment]), "call $peek", "i32.const "+index, "i32.add")
+	case "pointer":
+		c.add("i32.const 3", "i32.const "+index, "i32.add")
+	case "temp":
+		c.add(fmt.Sprintf("i32.const %d", memory.Temp), "i32.const "+index, "i32.add")
+	case "global":
+		address, err := globalAddress(n)
+		if err != nil {
+			return err
+		}
+
+		c.add("i32.const " + strconv.Itoa(address))
+	case "static":
+		c.add("i32.const " + strconv.Itoa(layout.statics[staticKey(command.File, index)]))
+	default:
+		return fmt.Errorf("invalid segment: %s", segment)
+	}
+
+	return nil
+}
+
+// Pushes the frame and sets ARG and LCL for a call returning to `target`
+func (c *watCode) call(target int, args int) {
+	c.add(fmt.Sprintf("i32.const %d", target), "call $push")
+
+	for pointer := 1; pointer <= 4; pointer++ {
+		c.add(fmt.Sprintf("i32.const %d", pointer), "call $peek", "call $push")
+	}
+
+	c.add(
+		"i32.const 2", "i32.const 0", "call $peek", fmt.Sprintf("i32.const %d", 5+args), "i32.sub", "call $poke",
+		"i32.const 1", "i32.const 0", "call $peek", "call $poke",
+	)
+}
+
+func (c *watCode) command(layout programLayout, targets watTargets, i int, command Command) error {
+	switch command.Kind {
+	case "push":
+		if command.Args[0] == "constant" {
+			c.add("i32.const "+command.Args[1], "call $push")
+			return nil
+		}
+
+		if err := c.address(layout, command); err != nil {
+			return err
+		}
+
+		c.add("call $peek", "call $push")
+
+	case "pop":
+		c.add("call $pop", "local.set $x")
+
+		if err := c.address(layout, command); err != nil {
+			return err
+		}
+
+		c.add("local.get $x", "call $poke")
+
+	case "neg":
+		c.add("i32.const 0", "call $pop", "i32.sub", "call $push")
+
+	case "not":
+		c.add("call $pop", "i32.const -1", "i32.xor", "call $push")
+
+	case "abs":
+		c.add("call $pop", "call $abs", "call $push")
+
+	case "label", "breakpoint":
+
+	case "goto":
+		if layout.halts[i] {
+			c.add("br $halt")
+			return nil
+		}
+
+		c.jump(targets, layout.labels[scopedLabel(layout.scopes[i], command.Args[0])])
+
+	case "if-goto":
+		c.add("call $pop", "if")
+		c.jump(targets, layout.labels[scopedLabel(layout.scopes[i], command.Args[0])])
+		c.add("end")
+
+	case "function":
+		locals, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return fmt.Errorf("invalid vars for function definition (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		for n := 0; n < locals; n++ {
+			c.add("i32.const 0", "call $push")
+		}
+
+	case "call":
+		args, err := strconv.Atoi(command.Args[1])
+		if err != nil {
+			return fmt.Errorf("invalid args to function (%s): %s", command.Args[0], command.Args[1])
+		}
+
+		c.call(targets.number[i+1], args)
+		c.jump(targets, layout.functions[command.Args[0]])
+
+	case "return":
+		c.add(
+			"i32.const 1", "call $peek", "local.set $frame",
+			"local.get $frame", "i32.const 5", "i32.sub", "call $peek", "local.set $address",
+			"i32.const 2", "call $peek", "call $pop", "call $poke",
+			"i32.const 0", "i32.const 2", "call $peek", "i32.const 1", "i32.add", "call $poke",
+		)
+
+		for pointer := 4; pointer >= 1; pointer-- {
+			c.add(fmt.Sprintf("i32.const %d", pointer), "local.get $frame", fmt.Sprintf("i32.const %d", 5-pointer), "i32.sub", "call $peek", "call $poke")
+		}
+
+		c.add(
+			"local.get $address", "i32.const 0", "i32.lt_s", "br_if $halt",
+			"local.get $address", "local.set $pc", "br $dispatch",
+		)
+
+	default:
+		operator, ok := watOperators[command.Kind]
+		if !ok {
+			return fmt.Errorf("invalid command: %s", command)
+		}
+
+		c.add("call $pop", "local.set $y", "call $pop", "local.set $x")
+
+		// Comparisons give 1 for true where the VM wants -1
+		if command.Kind == "eq" || command.Kind == "gt" || command.Kind == "lt" {
+			c.add("i32.const 0", "local.get $x", "local.get $y")
+			c.add(operator...)
+			c.add("i32.sub", "call $push")
+			return nil
+		}
+
+		c.add("local.get $x", "local.get $y")
+		c.add(operator...)
+		c.add("call $push")
+	}
+
+	return nil
+}
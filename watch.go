@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RAM addresses whose changes are reported, with the values they last had
+type watchpoints struct {
+	names  map[int]string
+	values map[int]int16
+}
+
+func newWatchpoints() *watchpoints {
+	return &watchpoints{names: map[int]string{}, values: map[int]int16{}}
+}
+
+// The RAM address a watchpoint refers to: a number, RAM[N], or one of the
+// assembler's symbols, such as LCL or the static Foo.vm.3
+func resolveWatch(target string, symbols []asmSymbol) (int, error) {
+	if address, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(target, "RAM["), "]")); err == nil {
+		if address < 0 || address > memory.Keyboard {
+			return 0, fmt.Errorf("invalid address: %s", target)
+		}
+
+		return address, nil
+	}
+
+	if address, ok := predefinedSymbols[target]; ok {
+		return address, nil
+	}
+
+	for _, symbol := range symbols {
+		if symbol.Name == target && !symbol.Label {
+			return symbol.Address, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no variable %s", target)
+}
+
+func (w *watchpoints) add(address int, name string, ram []int16) {
+	w.names[address] = name
+	w.values[address] = ram[address]
+}
+
+// Removes the watchpoint on a target, or all of them
+func (w *watchpoints) remove(target string) {
+	for address, name := range w.names {
+		if target == "" || name == target {
+			delete(w.names, address)
+			delete(w.values, address)
+		}
+	}
+}
+
+func (w *watchpoints) addresses() []int {
+	var addresses []int
+	for address := range w.names {
+		addresses = append(addresses, address)
+	}
+	sort.Ints(addresses)
+
+	return addresses
+}
+
+// Describes the watched addresses that have changed since the last look
+func (w *watchpoints) changes(ram []int16) []string {
+	var changes []string
+
+	for _, address := range w.addresses() {
+		if ram[address] != w.values[address] {
+			changes = append(changes, fmt.Sprintf("%s (RAM[%d]): %d -> %d", w.names[address], address, w.values[address], ram[address]))
+			w.values[address] = ram[address]
+		}
+	}
+
+	return changes
+}
+
+// The VM command at a ROM address, or where it is if it isn't in one
+func (p *programMap) describe(address int) string {
+	owner := p.owner(address)
+	if owner < 0 {
+		return fmt.Sprintf("ROM %d (bootstrap or shared routine)", address)
+	}
+
+	mapping := p.mappings[owner]
+
+	return fmt.Sprintf("%s:%d %s%s", mapping.File, mapping.Line, mapping.Command, mapping.origin())
+}
+
+// The function and line of the command at a ROM address, e.g.
+// Foo.bar (Foo.vm:42), and the Jack line if a comment gave it
+func (p *programMap) location(address int) string {
+	owner := p.owner(address)
+	if owner < 0 {
+		return fmt.Sprintf("ROM %d", address)
+	}
+
+	mapping := p.mappings[owner]
+	if p.functions[owner] == "" {
+		return fmt.Sprintf("%s:%d%s", mapping.File, mapping.Line, mapping.origin())
+	}
+
+	return fmt.Sprintf("%s (%s:%d)%s", p.functions[owner], mapping.File, mapping.Line, mapping.origin())
+}